@@ -0,0 +1,301 @@
+// Package agent runs the recipe on an interval, so drift between runs is
+// corrected, reporting the status of its runs on a Unix socket
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/mikemackintosh/bakery/cli"
+	"github.com/mikemackintosh/bakery/config"
+)
+
+// Results of a run
+const (
+	ResultSuccess = "success"
+	ResultFailed  = "failed"
+	ResultStopped = "stopped"
+	ResultSkipped = "skipped"
+)
+
+// Job is a run of the recipe, which can be asked to stop before it finishes
+type Job interface {
+	Run() error
+	Stop()
+}
+
+// Run is the result of a run of the recipe
+type Run struct {
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	Duration float64   `json:"duration_seconds"`
+	Result   string    `json:"result"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// Status is the status of the agent, reported on its socket
+type Status struct {
+	Started     time.Time  `json:"started"`
+	Running     bool       `json:"running"`
+	NextRun     time.Time  `json:"next_run"`
+	Runs        int        `json:"runs"`
+	Failures    int        `json:"failures"`
+	LastRun     *Run       `json:"last_run,omitempty"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+}
+
+// Agent runs the recipe every interval, waiting a random splay longer so
+// hosts do not all run at once
+type Agent struct {
+	// Load fetches and loads the recipe before each run, and Reload reloads
+	// the configuration when the agent is sent SIGHUP
+	Load   func() (Job, error)
+	Reload func() error
+
+	mu     sync.Mutex
+	status Status
+}
+
+// Serve runs the recipe until the agent is sent SIGTERM or SIGINT, serving
+// its status on the socket. SIGHUP reloads the configuration and runs the
+// recipe straight away, or once the run in progress finishes.
+func (a *Agent) Serve(socket string, signals <-chan os.Signal) error {
+	l, err := listen(socket)
+	if err != nil {
+		return err
+	}
+	server := &http.Server{Handler: a}
+	go server.Serve(l)
+	defer server.Close()
+
+	a.mu.Lock()
+	a.status.Started = time.Now()
+	a.mu.Unlock()
+
+	// The first run only waits for the splay, so a new host converges soon
+	var wait = splay()
+	for {
+		a.setNextRun(time.Now().Add(wait))
+		cli.Debug(cli.INFO, "Next run", a.Status().NextRun.Format(time.RFC3339))
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case sig := <-signals:
+			timer.Stop()
+			if sig != syscall.SIGHUP {
+				return nil
+			}
+			a.reload()
+		}
+
+		reload, stop := a.converge(signals)
+		if stop {
+			return nil
+		}
+
+		wait = config.Registry.AgentInterval + splay()
+		if reload {
+			a.reload()
+			wait = 0
+		}
+	}
+}
+
+// converge runs the recipe, returning whether the agent was sent SIGHUP or
+// asked to stop while it ran. Stopping lets the blocks which are running
+// finish.
+func (a *Agent) converge(signals <-chan os.Signal) (reload, stop bool) {
+	var started = time.Now()
+	a.setRunning(true)
+
+	var jobs = make(chan Job, 1)
+	var done = make(chan error, 1)
+	go func() {
+		unlock, err := Lock(config.Registry.StateDir)
+		if err != nil {
+			done <- err
+			return
+		}
+		defer unlock()
+
+		job, err := a.Load()
+		if err != nil {
+			done <- err
+			return
+		}
+		jobs <- job
+		done <- job.Run()
+	}()
+
+	var job Job
+	for {
+		select {
+		case job = <-jobs:
+			if stop {
+				job.Stop()
+			}
+		case sig := <-signals:
+			if sig == syscall.SIGHUP {
+				reload = true
+				continue
+			}
+
+			cli.Debug(cli.WARNING, "Stopping once the blocks which are running finish", sig)
+			stop = true
+			if job != nil {
+				job.Stop()
+			}
+		case err := <-done:
+			a.finish(started, err, stop)
+			return reload, stop
+		}
+	}
+}
+
+// finish records the result of a run
+func (a *Agent) finish(started time.Time, err error, stopped bool) {
+	var run = &Run{
+		Started:  started,
+		Finished: time.Now(),
+		Result:   ResultSuccess,
+	}
+	run.Duration = run.Finished.Sub(started).Seconds()
+
+	switch {
+	case err == ErrLocked:
+		run.Result = ResultSkipped
+	case err != nil && stopped:
+		run.Result = ResultStopped
+	case err != nil:
+		run.Result = ResultFailed
+	}
+	if err != nil {
+		run.Error = err.Error()
+		cli.Debug(cli.ERROR, "Run "+run.Result, err)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.status.Running = false
+	a.status.LastRun = run
+	if run.Result == ResultSkipped {
+		return
+	}
+
+	a.status.Runs++
+	if run.Result == ResultSuccess {
+		a.status.LastSuccess = &run.Finished
+	} else {
+		a.status.Failures++
+	}
+}
+
+// reload reloads the configuration, keeping the current configuration when
+// it cannot be loaded
+func (a *Agent) reload() {
+	cli.Debug(cli.INFO, "Reloading configuration", nil)
+	if err := a.Reload(); err != nil {
+		cli.Debug(cli.ERROR, "Error reloading configuration", err)
+	}
+}
+
+// Status returns the status of the agent
+func (a *Agent) Status() Status {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.status
+}
+
+func (a *Agent) setRunning(running bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.status.Running = running
+}
+
+func (a *Agent) setNextRun(next time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.status.NextRun = next
+}
+
+// ServeHTTP serves the status of the agent as JSON
+func (a *Agent) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path != "/" && req.URL.Path != "/status" {
+		http.NotFound(w, req)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(a.Status())
+}
+
+// Query returns the status of the agent listening on the socket
+func Query(socket string) (*Status, error) {
+	client := &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+			},
+		},
+	}
+
+	resp, err := client.Get("http://agent/status")
+	if err != nil {
+		return nil, fmt.Errorf("Error connecting to the agent: %s", err)
+	}
+	defer resp.Body.Close()
+
+	var status Status
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return nil, fmt.Errorf("Error reading the agent status: %s", err)
+	}
+
+	return &status, nil
+}
+
+// listen listens on the socket, replacing a socket left behind by an agent
+// which is no longer running
+func listen(socket string) (net.Listener, error) {
+	if err := os.MkdirAll(filepath.Dir(socket), 0755); err != nil {
+		return nil, err
+	}
+
+	if _, err := os.Stat(socket); err == nil {
+		if conn, err := net.Dial("unix", socket); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("An agent is already listening on %s", socket)
+		}
+		os.Remove(socket)
+	}
+
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		return nil, err
+	}
+
+	return l, os.Chmod(socket, 0660)
+}
+
+// splay returns a random wait of up to the configured splay
+func splay() time.Duration {
+	if config.Registry.AgentSplay <= 0 {
+		return 0
+	}
+
+	return time.Duration(rand.Int63n(int64(config.Registry.AgentSplay) + 1))
+}
+
+func init() {
+	rand.Seed(time.Now().UnixNano())
+}
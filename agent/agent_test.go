@@ -0,0 +1,139 @@
+package agent
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/mikemackintosh/bakery/config"
+)
+
+// testJob is a run which blocks until it is stopped, or released
+type testJob struct {
+	started chan bool
+	release chan bool
+	stopped chan bool
+	err     error
+}
+
+func (j *testJob) Run() error {
+	j.started <- true
+	select {
+	case <-j.release:
+		return j.err
+	case <-j.stopped:
+		return fmt.Errorf("The run was stopped before it finished")
+	}
+}
+
+func (j *testJob) Stop() {
+	close(j.stopped)
+}
+
+// waitFor polls the status of the agent until the condition is met
+func waitFor(t *testing.T, socket string, cond func(*Status) bool) *Status {
+	for i := 0; i < 200; i++ {
+		if status, err := Query(socket); err == nil && cond(status) {
+			return status
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("gave up waiting for the agent")
+	return nil
+}
+
+func TestAgent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	previous := *config.Registry
+	config.Registry.StateDir = dir
+	config.Registry.AgentInterval = time.Hour
+	config.Registry.AgentSplay = 0
+	defer func() {
+		*config.Registry = previous
+	}()
+
+	var jobs = make(chan *testJob, 10)
+	var reloads int
+	a := &Agent{
+		Load: func() (Job, error) {
+			j := &testJob{started: make(chan bool, 1), release: make(chan bool), stopped: make(chan bool)}
+			jobs <- j
+			return j, nil
+		},
+		Reload: func() error {
+			reloads++
+			return nil
+		},
+	}
+
+	var socket = filepath.Join(dir, "agent.sock")
+	var signals = make(chan os.Signal)
+	var served = make(chan error)
+	go func() {
+		served <- a.Serve(socket, signals)
+	}()
+
+	// The first run starts straight away, as there is no splay
+	j := <-jobs
+	<-j.started
+	waitFor(t, socket, func(s *Status) bool { return s.Running })
+
+	// Runs on the command line wait for the agent
+	if _, err := Lock(dir); err != ErrLocked {
+		t.Errorf("want the run lock to be held but got %v", err)
+	}
+
+	j.err = fmt.Errorf("shell.a: failed")
+	j.release <- true
+	status := waitFor(t, socket, func(s *Status) bool { return !s.Running })
+	if status.Runs != 1 || status.Failures != 1 || status.LastRun.Result != ResultFailed || status.LastRun.Error != "shell.a: failed" {
+		t.Errorf("want a failed run but got %#v", status.LastRun)
+	}
+	if time.Until(status.NextRun) < 59*time.Minute {
+		t.Errorf("want the next run in an hour but got %s", status.NextRun)
+	}
+
+	// SIGHUP reloads the configuration and runs again
+	signals <- syscall.SIGHUP
+	j = <-jobs
+	<-j.started
+	if reloads != 1 {
+		t.Errorf("want the configuration to be reloaded but got %d reloads", reloads)
+	}
+	j.release <- true
+	status = waitFor(t, socket, func(s *Status) bool { return s.Runs == 2 })
+	if status.LastRun.Result != ResultSuccess || status.LastSuccess == nil {
+		t.Errorf("want a successful run but got %#v", status.LastRun)
+	}
+
+	// SIGTERM stops the run in progress, then the agent
+	signals <- syscall.SIGHUP
+	j = <-jobs
+	<-j.started
+	signals <- syscall.SIGTERM
+	if err := <-served; err != nil {
+		t.Errorf("want no error but got %s", err)
+	}
+	if status := a.Status(); status.LastRun.Result != ResultStopped {
+		t.Errorf("want the run to be stopped but got %#v", status.LastRun)
+	}
+	if _, err := os.Stat(socket); !os.IsNotExist(err) {
+		t.Errorf("want the socket to be removed but got %v", err)
+	}
+
+	unlock, err := Lock(dir)
+	if err != nil {
+		t.Fatalf("want the run lock to be released but got %s", err)
+	}
+	unlock()
+}
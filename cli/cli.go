@@ -3,7 +3,11 @@ package cli
 import (
 	"flag"
 	"fmt"
+	"log/slog"
 	"os"
+	"strings"
+
+	"github.com/mikemackintosh/bakery/config"
 )
 
 // flag options for CLI
@@ -14,26 +18,59 @@ var (
 	FlagBundle    bool
 	FlagDebug     bool
 	FlagVerbosity int
+	FlagQuiet     bool
+	FlagLogDir    string
+	FlagPlugins   string
+
+	FlagCacheDir      string
+	FlagStateDir      string
+	FlagLogFile       string
+	FlagParallelism   int
+	FlagFailurePolicy string
+	FlagLogFormat     string
+	FlagOutput        string
+
+	// configFlags maps the flags which override the manifest to its keys
+	configFlags = map[string]string{
+		"r":              "recipe",
+		"temp-dir":       "tmp_dir",
+		"cache-dir":      "cache_dir",
+		"state-dir":      "state_dir",
+		"log-dir":        "log_dir",
+		"log-file":       "log_file",
+		"log-format":     "log_format",
+		"plugin-path":    "plugin_path",
+		"parallelism":    "parallelism",
+		"failure-policy": "failure_policy",
+	}
 
 	// severityName maps severity const's to string names
-	severityName = []Severity{
-		INFO:    Severity{Name: "INFO", Color: "\033[38;5;45m"},
+	severityName = map[slog.Level]Severity{
 		ERROR:   Severity{Name: "ERROR", Color: "\033[38;5;196m"},
 		WARNING: Severity{Name: "WARNING", Color: "\033[38;5;214m"},
+		INFO:    Severity{Name: "INFO", Color: "\033[38;5;45m"},
 		DEBUG:   Severity{Name: "DEBUG", Color: "\033[38;5;45m"},
 		DEBUG2:  Severity{Name: "DEBUG2", Color: "\033[38;5;45m"},
 		DEBUG3:  Severity{Name: "DEBUG3", Color: "\033[38;5;45m"},
 	}
 )
 
-// iota is used to set incrementing value for constant list
+// The severities are the levels of log/slog, with two more detailed debug
+// levels. Errors and warnings are always shown, info with -d, and the debug
+// levels with -d and a -v of 3 or more.
+const (
+	ERROR   = slog.LevelError
+	WARNING = slog.LevelWarn
+	INFO    = slog.LevelInfo
+	DEBUG   = slog.LevelDebug
+	DEBUG2  = slog.LevelDebug - 4
+	DEBUG3  = slog.LevelDebug - 8
+)
+
+// Output formats of a run
 const (
-	INFO = iota
-	ERROR
-	WARNING
-	DEBUG
-	DEBUG2
-	DEBUG3
+	OutputText = "text"
+	OutputJSON = "json"
 )
 
 type Severity struct {
@@ -44,33 +81,68 @@ type Severity struct {
 // Init flags
 func init() {
 	flag.StringVar(&FlagConfig, "c", "manifest.yml", "Configuration file")
-	flag.StringVar(&FlagRecipe, "r", "config.yum", "Client recipe file")
-	flag.StringVar(&FlagTempDir, "-temp-dir", " /var/bakery/tmp", "Temporary resource directory")
+	flag.StringVar(&FlagRecipe, "r", config.DefaultRecipe, "Client recipe file, directory of recipe files, or https:// URL of a signed recipe")
+	flag.StringVar(&FlagTempDir, "temp-dir", config.DefaultTempDir, "Temporary resource directory")
 	flag.BoolVar(&FlagBundle, "b", false, "Bundle client config with binary")
 	flag.BoolVar(&FlagDebug, "d", false, "When enabled, turns on debugging")
 	flag.IntVar(&FlagVerbosity, "v", 1, "Sets output verbosity level")
+	flag.BoolVar(&FlagQuiet, "q", false, "Only show command output when a command fails")
+	flag.BoolVar(&FlagQuiet, "quiet", false, "Only show command output when a command fails")
+	flag.StringVar(&FlagLogDir, "log-dir", config.DefaultLogDir, "Directory to keep the command output of each run in")
+	flag.StringVar(&FlagPlugins, "plugin-path", config.DefaultPluginPath, "Directories to find resource plugins in, separated by colons")
+	flag.StringVar(&FlagCacheDir, "cache-dir", config.DefaultCacheDir, "Directory to cache downloaded modules in")
+	flag.StringVar(&FlagStateDir, "state-dir", config.DefaultStateDir, "Directory to keep state between runs in")
+	flag.StringVar(&FlagLogFile, "log-file", "", "File to append the log and command output of every run to, rotated by size, instead of a file per run in the log directory")
+	flag.StringVar(&FlagOutput, "output", OutputText, "Format of the output of a run, text, or json for a line of JSON per event on stdout")
+	flag.StringVar(&FlagLogFormat, "log-format", config.LogText, "Format of the log, text or json")
+	flag.IntVar(&FlagParallelism, "parallelism", 1, "How many blocks can run at once")
+	flag.StringVar(&FlagFailurePolicy, "failure-policy", config.FailureStop, "Whether to stop or continue the run when a block fails")
 }
 
-// Debug prints out debug messaging with log levels when set
-func Debug(verbosity int, msg string, value interface{}) string {
-	var output string
-	if FlagDebug && verbosity <= FlagVerbosity {
-		output = fmt.Sprintf("\033[0m[%7s ] %s", severityName[verbosity].Name, severityName[verbosity].Color)
-		if value != nil {
-			output = fmt.Sprintf("%s%s: %v\n", output, msg, value)
-			fmt.Print(output)
-			return output
+// IsSet returns true when the flag was given on the command line
+func IsSet(name string) bool {
+	var set bool
+	flag.Visit(func(f *flag.Flag) {
+		set = set || f.Name == name
+	})
+
+	return set
+}
+
+// ApplyFlags overrides the settings with the flags given on the command line
+func ApplyFlags(c *config.Configuration) error {
+	var err error
+	flag.Visit(func(f *flag.Flag) {
+		key, ok := configFlags[f.Name]
+		if !ok || err != nil {
+			return
+		}
+
+		if err = c.Set(key, f.Value.String()); err != nil {
+			err = fmt.Errorf("Error in -%s: %s", f.Name, err)
 		}
+	})
+
+	// The recipe given with -r is used over a recipe_url in the manifest
+	if IsSet("r") {
+		c.RecipeURL = ""
+	}
+
+	return err
+}
 
-		output = fmt.Sprintf("%s%s\033[0m\n", output, msg)
+// Debug logs a message at the severity, followed by the value when it is
+// not nil
+func Debug(level slog.Level, msg string, value interface{}) string {
+	if value != nil {
+		msg = fmt.Sprintf("%s: %v", msg, value)
 	}
 
-	fmt.Print(output)
-	return output
+	return Log(level, msg, nil)
 }
 
-// ErrorAndExit will print an error and exit the program
+// ErrorAndExit will print an error to stderr and exit the program
 func ErrorAndExit(err error) {
-	fmt.Printf("%s", err)
+	fmt.Fprintf(os.Stderr, "%s\n", strings.TrimRight(err.Error(), "\n"))
 	os.Exit(1)
 }
@@ -2,36 +2,49 @@ package cli
 
 import (
 	"fmt"
+	"log/slog"
 	"os"
 	"os/exec"
 	"testing"
+
+	"github.com/mikemackintosh/bakery/config"
 )
 
 /*
  * Test_debug tests debug messaging output and leveling
  */
 var debugOutputTest = []struct {
+	Level     slog.Level
 	Verbosity int
 	Debug     bool
 	Message   string
 	Expected  string
 }{
 	{
-		ERROR, true, "Test", "\033[0m[  ERROR ] \033[38;5;196mTest\033[0m\n",
+		ERROR, 1, true, "Test", "\033[0m[  ERROR ] \033[38;5;196mTest\033[0m\n",
+	},
+	{
+		ERROR, 1, false, "Test", "\033[0m[  ERROR ] \033[38;5;196mTest\033[0m\n",
 	},
 	{
-		ERROR, false, "Test", "",
+		INFO, 1, false, "Test", "",
 	},
 	{
-		DEBUG, true, "Debug Message", "\033[0m[  DEBUG ] \033[38;5;45mDebug Message\033[0m\n",
+		DEBUG, 3, true, "Debug Message", "\033[0m[  DEBUG ] \033[38;5;45mDebug Message\033[0m\n",
+	},
+	{
+		DEBUG2, 3, true, "Debug Message", "",
 	},
 }
 
 func TestDebug(t *testing.T) {
+	_, _, restore := useLog(config.LogText)
+	defer restore()
+
 	for _, test := range debugOutputTest {
 		FlagVerbosity = test.Verbosity
 		FlagDebug = test.Debug
-		output := Debug(test.Verbosity, test.Message, nil)
+		output := Debug(test.Level, test.Message, nil)
 		if output != test.Expected {
 			t.Errorf("wanted '%s' but got '%s'", test.Expected, output)
 		}
@@ -0,0 +1,303 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mikemackintosh/bakery/config"
+)
+
+// redacted replaces sensitive values in the log
+const redacted = "[REDACTED]"
+
+// Fields are the values logged with a message, such as the resource it is
+// about
+type Fields map[string]interface{}
+
+var (
+	// LogFormat is the format of the log, text or json
+	LogFormat = config.LogText
+
+	// LogOutput is where log messages are shown
+	LogOutput io.Writer = os.Stderr
+
+	// secrets are the values which are redacted from the log and command
+	// output
+	secrets   []string
+	secretsMu sync.Mutex
+
+	// sensitiveKey matches the fields whose values are always redacted
+	sensitiveKey = regexp.MustCompile(`(?i)password|passwd|secret|token|api_?key|private_?key|credential`)
+
+	// urlPassword matches the password of a URL, such as a proxy
+	urlPassword = regexp.MustCompile(`(://[^:/@\s]+:)[^@/\s]+@`)
+)
+
+// Redact keeps the value, such as a token, out of the log and command output
+func Redact(value string) {
+	if value == "" {
+		return
+	}
+
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	secrets = append(secrets, value)
+}
+
+// redact returns the string with the secrets and the passwords of URLs
+// replaced
+func redact(s string) string {
+	secretsMu.Lock()
+	for _, secret := range secrets {
+		s = strings.Replace(s, secret, redacted, -1)
+	}
+	secretsMu.Unlock()
+
+	return urlPassword.ReplaceAllString(s, "${1}"+redacted+"@")
+}
+
+// threshold returns the least severe level which is shown, which is warning
+// unless debugging, when it is info, or a debug level for a verbosity of 3
+// to 5
+func threshold() slog.Level {
+	switch {
+	case !FlagDebug:
+		return WARNING
+	case FlagVerbosity <= 2:
+		return INFO
+	case FlagVerbosity >= 5:
+		return DEBUG3
+	}
+
+	return INFO - slog.Level(4*(FlagVerbosity-2))
+}
+
+// Logger returns a logger which logs through the Handler, for code which
+// uses log/slog
+func Logger() *slog.Logger {
+	return slog.New(&Handler{})
+}
+
+// Log logs a message at the level with the fields, returning what was
+// shown. Errors and warnings are always shown, and info and the debug levels
+// when debugging with the verbosity. The run log keeps info and above, and
+// anything shown.
+func Log(level slog.Level, msg string, fields Fields) string {
+	var shown bytes.Buffer
+	h := &Handler{output: io.MultiWriter(LogOutput, &shown)}
+	if !h.Enabled(context.Background(), level) {
+		return ""
+	}
+
+	rec := slog.NewRecord(time.Now(), level, msg, 0)
+	rec.AddAttrs(fields.attrs()...)
+	h.Handle(context.Background(), rec)
+
+	return shown.String()
+}
+
+// attrs returns the fields as attributes, sorted by name
+func (f Fields) attrs() []slog.Attr {
+	var keys []string
+	for k := range f {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var attrs []slog.Attr
+	for _, k := range keys {
+		attrs = append(attrs, slog.Any(k, f[k]))
+	}
+
+	return attrs
+}
+
+// Handler is the slog.Handler of the log. Records at the threshold are
+// shown on LogOutput, colored when the format is text, and records at info
+// and above, as well as those shown, are written to RunLog with their time.
+type Handler struct {
+	// output is where records are shown, LogOutput when it is nil
+	output io.Writer
+
+	// with adds the attributes and groups of WithAttrs and WithGroup to
+	// the handlers of the log format, in order. attrs are the attributes
+	// shown on the terminal, with the groups as a prefix of their keys.
+	with   []func(slog.Handler) slog.Handler
+	attrs  []slog.Attr
+	prefix string
+}
+
+// Enabled returns true when records at the level are shown or kept
+func (h *Handler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= INFO || level >= threshold()
+}
+
+// WithAttrs returns a handler which logs the attributes with every record
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := h.clone()
+	c.with = append(c.with, func(sh slog.Handler) slog.Handler {
+		return sh.WithAttrs(attrs)
+	})
+	for _, a := range attrs {
+		c.attrs = append(c.attrs, slog.Attr{Key: c.prefix + a.Key, Value: a.Value})
+	}
+
+	return c
+}
+
+// WithGroup returns a handler which logs the attributes of records in the
+// group
+func (h *Handler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+
+	c := h.clone()
+	c.with = append(c.with, func(sh slog.Handler) slog.Handler {
+		return sh.WithGroup(name)
+	})
+	c.prefix += name + "."
+
+	return c
+}
+
+// clone returns a copy of the handler which can be added to
+func (h *Handler) clone() *Handler {
+	c := *h
+	c.with = c.with[:len(c.with):len(c.with)]
+	c.attrs = c.attrs[:len(c.attrs):len(c.attrs)]
+	return &c
+}
+
+// Handle shows the record when it is at the threshold, and keeps it in the
+// run log when it is shown or at info and above
+func (h *Handler) Handle(ctx context.Context, r slog.Record) error {
+	var output = h.output
+	if output == nil {
+		output = LogOutput
+	}
+
+	outputMu.Lock()
+	defer outputMu.Unlock()
+
+	var shown = r.Level >= threshold()
+	if shown && LogFormat == config.LogJSON {
+		h.handler(output, config.LogJSON).Handle(ctx, r)
+	} else if shown {
+		io.WriteString(output, h.colored(r))
+	}
+
+	if !shown && r.Level < INFO {
+		return nil
+	}
+
+	return h.handler(RunLog, LogFormat).Handle(ctx, r)
+}
+
+// handler returns the slog handler of the format writing to w, with the
+// attributes and groups of the handler
+func (h *Handler) handler(w io.Writer, format string) slog.Handler {
+	var sh = newHandler(w, format)
+	for _, with := range h.with {
+		sh = with(sh)
+	}
+
+	return sh
+}
+
+// newHandler returns a slog handler writing records in the format to w,
+// with the levels named and values redacted
+func newHandler(w io.Writer, format string) slog.Handler {
+	opts := &slog.HandlerOptions{Level: DEBUG3, ReplaceAttr: replaceAttr}
+	if format == config.LogJSON {
+		return slog.NewJSONHandler(w, opts)
+	}
+
+	return slog.NewTextHandler(w, opts)
+}
+
+// replaceAttr names the level of a record, and writes errors, durations and
+// other values with a String method as strings, with the secrets and the
+// passwords of URLs redacted. The values of sensitive fields are always
+// redacted.
+func replaceAttr(groups []string, a slog.Attr) slog.Attr {
+	if level, ok := a.Value.Any().(slog.Level); ok && len(groups) == 0 && a.Key == slog.LevelKey {
+		return slog.String(slog.LevelKey, severity(level).Name)
+	}
+	if a.Value.Kind() == slog.KindTime {
+		return a
+	}
+	if sensitiveKey.MatchString(a.Key) {
+		return slog.String(a.Key, redacted)
+	}
+
+	switch v := a.Value.Any().(type) {
+	case error:
+		a.Value = slog.StringValue(v.Error())
+	case fmt.Stringer:
+		a.Value = slog.StringValue(v.String())
+	case []byte:
+		a.Value = slog.StringValue(string(v))
+	}
+	if a.Value.Kind() == slog.KindString {
+		a.Value = slog.StringValue(redact(a.Value.String()))
+	}
+
+	return a
+}
+
+// colored returns the record as a line for the terminal, colored by its
+// level, with the attributes of the handler and the record
+func (h *Handler) colored(r slog.Record) string {
+	var fields strings.Builder
+	var add func(prefix string, a slog.Attr)
+	add = func(prefix string, a slog.Attr) {
+		a.Value = a.Value.Resolve()
+		if a.Value.Kind() == slog.KindGroup {
+			for _, member := range a.Value.Group() {
+				add(prefix+a.Key+".", member)
+			}
+			return
+		}
+		if a.Key == "" {
+			return
+		}
+
+		a = replaceAttr(nil, a)
+		var v = a.Value.String()
+		if v == "" || strings.ContainsAny(v, " \t\n\"=") {
+			v = strconv.Quote(v)
+		}
+		fmt.Fprintf(&fields, " %s%s=%s", prefix, a.Key, v)
+	}
+
+	for _, a := range h.attrs {
+		add("", a)
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		add(h.prefix, a)
+		return true
+	})
+
+	var sev = severity(r.Level)
+	return fmt.Sprintf("\033[0m[%7s ] %s%s%s\033[0m\n", sev.Name, sev.Color, redact(r.Message), fields.String())
+}
+
+// severity returns the name and color of the level
+func severity(level slog.Level) Severity {
+	if sev, ok := severityName[level]; ok {
+		return sev
+	}
+
+	return Severity{Name: level.String(), Color: severityName[DEBUG].Color}
+}
@@ -0,0 +1,178 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/mikemackintosh/bakery/config"
+)
+
+var (
+	// Output is where command output is streamed to
+	Output io.Writer = color.Output
+
+	// RunLog captures the output of every command streamed during the run
+	RunLog io.Writer = ioutil.Discard
+
+	// outputMu keeps the lines of streams running at the same time whole
+	outputMu sync.Mutex
+)
+
+// OpenRunLog creates a log file for this run in the directory, and captures
+// the log and streamed command output in it until it is closed
+func OpenRunLog(dir string) (*RotatingFile, error) {
+	return OpenLogFile(filepath.Join(dir, fmt.Sprintf("run-%s.log", time.Now().Format("20060102T150405"))), 0, 0)
+}
+
+// OpenLogFile opens the log file for appending, creating it and its
+// directory when needed, and captures the log and streamed command output in
+// it until it is closed. It is rotated once it grows past maxSize bytes,
+// keeping keep previous files.
+func OpenLogFile(name string, maxSize int64, keep int) (*RotatingFile, error) {
+	f, err := OpenRotatingFile(name, maxSize, keep)
+	if err != nil {
+		return nil, err
+	}
+
+	RunLog = f
+	return f, nil
+}
+
+// Stream writes the output of a command line by line, each line prefixed
+// with the name of the resource running it. Output is written to the run log
+// as it arrives, and to the terminal unless quiet, in which case it is held
+// back and only shown when the command fails.
+type Stream struct {
+	prefix string
+	quiet  bool
+
+	mu   sync.Mutex
+	held bytes.Buffer
+	open []*streamWriter
+}
+
+// NewStream starts streaming command output for the resource
+func NewStream(prefix string, args []string) *Stream {
+	s := &Stream{prefix: prefix, quiet: FlagQuiet}
+	outputMu.Lock()
+	defer outputMu.Unlock()
+	var command = redact(strings.Join(args, " "))
+	if LogFormat == config.LogJSON {
+		slog.New(newHandler(RunLog, config.LogJSON)).LogAttrs(context.Background(), INFO, "Running command", Fields{"resource": prefix, "command": command}.attrs()...)
+	} else {
+		fmt.Fprintf(RunLog, "[%s] $ %s\n", prefix, command)
+	}
+	return s
+}
+
+// Stdout returns the writer for the standard output of the command
+func (s *Stream) Stdout() io.Writer {
+	return s.writer("stdout", color.FgGreen)
+}
+
+// Stderr returns the writer for the standard error of the command
+func (s *Stream) Stderr() io.Writer {
+	return s.writer("stderr", color.FgRed)
+}
+
+func (s *Stream) writer(name string, c color.Attribute) io.Writer {
+	w := &streamWriter{stream: s, name: name, color: color.New(c)}
+	s.open = append(s.open, w)
+	return w
+}
+
+// Close writes any incomplete lines, and the held back output when the
+// command failed
+func (s *Stream) Close(failed bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, w := range s.open {
+		if line := redrawn(w.partial); len(line) > 0 {
+			s.line(w, line)
+		}
+		w.partial = nil
+	}
+
+	if s.quiet && failed {
+		outputMu.Lock()
+		Output.Write(s.held.Bytes())
+		outputMu.Unlock()
+	}
+	s.held.Reset()
+}
+
+// line writes a complete line, with any secrets redacted, the caller
+// holding the lock
+func (s *Stream) line(w *streamWriter, b []byte) {
+	outputMu.Lock()
+	defer outputMu.Unlock()
+
+	var line = redact(string(b))
+	if LogFormat == config.LogJSON {
+		slog.New(newHandler(RunLog, config.LogJSON)).LogAttrs(context.Background(), INFO, "Command output", Fields{"resource": s.prefix, "stream": w.name, "line": line}.attrs()...)
+	} else {
+		fmt.Fprintf(RunLog, "[%s] %s\n", s.prefix, line)
+	}
+
+	var out = Output
+	if s.quiet {
+		out = &s.held
+	}
+	color.New(color.FgBlue).Fprintf(out, "[%s] ", s.prefix)
+	w.color.Fprintf(out, "%s\n", line)
+}
+
+type streamWriter struct {
+	stream  *Stream
+	name    string
+	color   *color.Color
+	partial []byte
+}
+
+// Write splits the output into lines, holding on to any incomplete line
+// until the rest of it arrives
+func (w *streamWriter) Write(b []byte) (int, error) {
+	w.stream.mu.Lock()
+	defer w.stream.mu.Unlock()
+
+	w.partial = append(w.partial, b...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			break
+		}
+
+		if line := redrawn(w.partial[:i]); len(line) > 0 {
+			w.stream.line(w, line)
+		}
+		w.partial = w.partial[i+1:]
+	}
+
+	// Progress bars redraw the line with carriage returns, so only the
+	// latest state of an incomplete line is kept
+	if i := bytes.LastIndexByte(w.partial, '\r'); i >= 0 && i < len(w.partial)-1 {
+		w.partial = append([]byte{}, w.partial[i+1:]...)
+	}
+
+	return len(b), nil
+}
+
+// redrawn returns what is left of a line once carriage returns are applied
+func redrawn(line []byte) []byte {
+	line = bytes.TrimRight(line, "\r")
+	if i := bytes.LastIndexByte(line, '\r'); i >= 0 {
+		line = line[i+1:]
+	}
+
+	return bytes.TrimRight(line, " \t")
+}
@@ -0,0 +1,76 @@
+package cli
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/fatih/color"
+)
+
+// useOutput captures streamed output and the run log for a test
+func useOutput(quiet bool) (*bytes.Buffer, *bytes.Buffer, func()) {
+	var out, log bytes.Buffer
+	output, runLog, flagQuiet, noColor := Output, RunLog, FlagQuiet, color.NoColor
+	Output, RunLog, FlagQuiet, color.NoColor = &out, &log, quiet, true
+	return &out, &log, func() {
+		Output, RunLog, FlagQuiet, color.NoColor = output, runLog, flagQuiet, noColor
+	}
+}
+
+var testStreamOutput = []struct {
+	Writes   []string
+	Expected string
+}{
+	{[]string{"one\ntwo\n"}, "[test] one\n[test] two\n"},
+	{[]string{"o", "ne\ntw", "o"}, "[test] one\n[test] two\n"},
+	{[]string{"10%\r50%\r100%\ndone\n"}, "[test] 100%\n[test] done\n"},
+	{[]string{"10%\r", "50%\r"}, "[test] 50%\n"},
+	{[]string{"crlf\r\n", "\n"}, "[test] crlf\n"},
+}
+
+func TestStream(t *testing.T) {
+	for _, test := range testStreamOutput {
+		out, log, restore := useOutput(false)
+
+		s := NewStream("test", []string{"echo", "hello"})
+		w := s.Stdout()
+		for _, b := range test.Writes {
+			w.Write([]byte(b))
+		}
+		s.Close(false)
+		restore()
+
+		if out.String() != test.Expected {
+			t.Errorf("want %q but got %q", test.Expected, out.String())
+		}
+
+		if expected := "[test] $ echo hello\n" + test.Expected; log.String() != expected {
+			t.Errorf("want %q but got %q", expected, log.String())
+		}
+	}
+}
+
+func TestStreamQuiet(t *testing.T) {
+	for _, failed := range []bool{false, true} {
+		out, log, restore := useOutput(true)
+
+		s := NewStream("test", []string{"false"})
+		io.WriteString(s.Stdout(), "out\n")
+		io.WriteString(s.Stderr(), "err\n")
+		s.Close(failed)
+		restore()
+
+		var expected string
+		if failed {
+			expected = "[test] out\n[test] err\n"
+		}
+		if out.String() != expected {
+			t.Errorf("want %q but got %q", expected, out.String())
+		}
+
+		if expected := "[test] $ false\n[test] out\n[test] err\n"; log.String() != expected {
+			t.Errorf("want %q but got %q", expected, log.String())
+		}
+	}
+}
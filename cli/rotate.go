@@ -0,0 +1,120 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// RotatingFile is a log file which is rotated once it grows past its
+// maximum size, keeping the previous files as name.1, name.2 and so on,
+// newest first
+type RotatingFile struct {
+	name    string
+	maxSize int64
+	keep    int
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// OpenRotatingFile opens the log file for appending, creating it and its
+// directory when needed. It is rotated once it grows past maxSize bytes,
+// keeping keep previous files, or never when maxSize is 0.
+func OpenRotatingFile(name string, maxSize int64, keep int) (*RotatingFile, error) {
+	if err := os.MkdirAll(filepath.Dir(name), 0750); err != nil {
+		return nil, err
+	}
+
+	rf := &RotatingFile{name: name, maxSize: maxSize, keep: keep}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+
+	return rf, nil
+}
+
+// open opens the file, carrying on from its size
+func (rf *RotatingFile) open() error {
+	f, err := os.OpenFile(rf.name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	rf.f, rf.size = f, info.Size()
+	return nil
+}
+
+// Write writes to the file, rotating it first when the write would take it
+// past its maximum size
+func (rf *RotatingFile) Write(b []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.f == nil {
+		return 0, os.ErrClosed
+	}
+
+	if rf.maxSize > 0 && rf.size > 0 && rf.size+int64(len(b)) > rf.maxSize {
+		if err := rf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := rf.f.Write(b)
+	rf.size += int64(n)
+	return n, err
+}
+
+// rotate shifts the previous files along, dropping the oldest, and starts a
+// new file
+func (rf *RotatingFile) rotate() error {
+	if err := rf.f.Close(); err != nil {
+		return err
+	}
+	rf.f = nil
+
+	if rf.keep < 1 {
+		os.Remove(rf.name)
+		return rf.open()
+	}
+
+	os.Remove(fmt.Sprintf("%s.%d", rf.name, rf.keep))
+	for i := rf.keep - 1; i > 0; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", rf.name, i), fmt.Sprintf("%s.%d", rf.name, i+1))
+	}
+	if err := os.Rename(rf.name, rf.name+".1"); err != nil {
+		// Carry on writing to the file which could not be rotated
+		rf.open()
+		return err
+	}
+
+	return rf.open()
+}
+
+// Name returns the name of the file
+func (rf *RotatingFile) Name() string {
+	return rf.name
+}
+
+// Close closes the file
+func (rf *RotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.f == nil {
+		return nil
+	}
+
+	err := rf.f.Close()
+	rf.f = nil
+	return err
+}
@@ -0,0 +1,166 @@
+// Command bakery-receiver is a reference receiver for the reports bakery
+// posts after each run. It stores the reports it is sent in a directory per
+// host, and lists the latest report of each host, for testing reporting
+// locally.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mikemackintosh/bakery/report"
+)
+
+// maxReportSize limits the size of the reports which are accepted
+const maxReportSize = 10 << 20
+
+// unsafeName matches the characters which are not kept in file names
+var unsafeName = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+var (
+	flagListen string
+	flagDir    string
+	flagCert   string
+	flagKey    string
+	flagToken  string
+)
+
+func init() {
+	flag.StringVar(&flagListen, "listen", "127.0.0.1:8080", "Address to listen on")
+	flag.StringVar(&flagDir, "dir", "reports", "Directory to store reports in")
+	flag.StringVar(&flagCert, "tls-cert", "", "TLS certificate, to serve https")
+	flag.StringVar(&flagKey, "tls-key", "", "TLS key, to serve https")
+	flag.StringVar(&flagToken, "token", "", "Bearer token reports must be posted with")
+}
+
+func main() {
+	flag.Parse()
+
+	http.Handle("/reports", &receiver{dir: flagDir, token: flagToken})
+
+	var err error
+	fmt.Printf("Receiving reports on %s, storing them in %s\n", flagListen, flagDir)
+	if flagCert != "" {
+		err = http.ListenAndServeTLS(flagListen, flagCert, flagKey, nil)
+	} else {
+		err = http.ListenAndServe(flagListen, nil)
+	}
+
+	fmt.Printf("%s\n", err)
+	os.Exit(1)
+}
+
+// receiver stores the reports posted to it, and lists the latest report of
+// each host
+type receiver struct {
+	dir   string
+	token string
+}
+
+// summary is the latest report of a host
+type summary struct {
+	Hostname      string        `json:"hostname"`
+	RecipeVersion string        `json:"recipe_version"`
+	Finished      time.Time     `json:"finished"`
+	Success       bool          `json:"success"`
+	Totals        report.Totals `json:"totals"`
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if rc.token != "" && req.Header.Get("Authorization") != "Bearer "+rc.token {
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	switch req.Method {
+	case http.MethodPost:
+		rc.store(w, req)
+	case http.MethodGet:
+		rc.list(w)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// store stores a report as <dir>/<hostname>/<finished>.json, and as the
+// latest report of the host
+func (rc *receiver) store(w http.ResponseWriter, req *http.Request) {
+	var rep report.Report
+	if err := json.NewDecoder(http.MaxBytesReader(w, req.Body, maxReportSize)).Decode(&rep); err != nil {
+		http.Error(w, fmt.Sprintf("invalid report: %s", err), http.StatusBadRequest)
+		return
+	}
+	if rep.Host == nil || rep.Host.Hostname == "" {
+		http.Error(w, "invalid report: the report has no hostname", http.StatusBadRequest)
+		return
+	}
+
+	b, err := json.MarshalIndent(rep, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var host = unsafeName.ReplaceAllString(rep.Host.Hostname, "_")
+	if strings.Trim(host, ".") == "" {
+		host = "_"
+	}
+	var dir = filepath.Join(rc.dir, host)
+	var name = rep.Finished.UTC().Format("20060102T150405.000000000") + ".json"
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, file := range []string{filepath.Join(dir, name), filepath.Join(dir, "latest.json")} {
+		if err := ioutil.WriteFile(file, b, 0644); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	fmt.Printf("Stored report from %s, success: %t\n", rep.Host.Hostname, rep.Success)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// list writes the latest report of each host
+func (rc *receiver) list(w http.ResponseWriter) {
+	names, err := filepath.Glob(filepath.Join(rc.dir, "*", "latest.json"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sort.Strings(names)
+
+	var summaries = []summary{}
+	for _, name := range names {
+		b, err := ioutil.ReadFile(name)
+		if err != nil {
+			continue
+		}
+
+		var rep report.Report
+		if err := json.Unmarshal(b, &rep); err != nil || rep.Host == nil {
+			continue
+		}
+
+		summaries = append(summaries, summary{
+			Hostname:      rep.Host.Hostname,
+			RecipeVersion: rep.RecipeVersion,
+			Finished:      rep.Finished,
+			Success:       rep.Success,
+			Totals:        rep.Totals,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summaries)
+}
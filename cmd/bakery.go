@@ -3,177 +3,279 @@ package main
 import (
 	"flag"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
+	"net/url"
 	"os"
-	"reflect"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
 
 	rice "github.com/GeertJohan/go.rice"
-	"github.com/hashicorp/hcl2/gohcl"
+	"github.com/fatih/color"
 	"github.com/hashicorp/hcl2/hcl"
-	"github.com/hashicorp/hcl2/hclparse"
+	"github.com/mikemackintosh/bakery/agent"
 	"github.com/mikemackintosh/bakery/cli"
 	"github.com/mikemackintosh/bakery/config"
 	"github.com/mikemackintosh/bakery/pantry"
-	"github.com/zclconf/go-cty/cty"
+	"github.com/mikemackintosh/bakery/recipe"
+	"github.com/mikemackintosh/bakery/report"
 )
 
-var runList = &Runlist{
-	Items: map[string]pantry.PantryInterface{},
-	// CompletedItems: map[string]pantry.PantryInterface{},
-}
+// messages is where bakery writes what it is doing, which is stderr when the
+// output of the run is JSON
+var messages io.Writer = os.Stdout
 
-// Variable contains variables
-type Variable struct {
-	Name    string         `hcl:"name,label"`
-	Default hcl.Attributes `hcl:"default,remain"`
-}
+func main() {
+	flag.Parse()
 
-// Bakery is the parent struct
-type Bakery struct {
-	Variables []*Variable     `hcl:"variable,block"`
-	Dmgs      []*pantry.Dmg   `hcl:"dmg,block"`
-	Pkgs      []*pantry.Pkg   `hcl:"pkg,block"`
-	Shells    []*pantry.Shell `hcl:"shell,block"`
-	Zips      []*pantry.Zip   `hcl:"zip,block"`
-	Gits      []*pantry.Git   `hcl:"git,block"`
-	Brews     []*pantry.Brew  `hcl:"brew,block"`
-	Fonts     []*pantry.Font  `hcl:"font,block"`
-}
+	switch cli.FlagOutput {
+	case cli.OutputText:
+	case cli.OutputJSON:
+		messages, cli.Output = os.Stderr, color.Error
+	default:
+		cli.ErrorAndExit(fmt.Errorf("-output must be %s or %s, not %q\n", cli.OutputText, cli.OutputJSON, cli.FlagOutput))
+	}
 
-// Runlist contains a list of items
-type Runlist struct {
-	Items map[string]pantry.PantryInterface
-}
+	if err := loadConfig(); err != nil {
+		cli.ErrorAndExit(err)
+	}
+
+	// The log file, when there is one, keeps the log of every run and of the
+	// agent, so it is open for as long as bakery runs
+	if config.Registry.LogFile != "" {
+		logFile, err := cli.OpenLogFile(config.Registry.LogFile, config.Registry.LogMaxBytes(), config.Registry.LogMaxFiles)
+		if err != nil {
+			cli.ErrorAndExit(err)
+		}
+		defer logFile.Close()
+	}
+
+	recipe.ReadBundleFile = readBundleFile
+	recipe.GlobBundleFiles = globBundleFiles
+
+	switch flag.Arg(0) {
+	case "import":
+		importCommand(flag.Args()[1:])
+		return
+	case "export":
+		exportCommand(flag.Args()[1:])
+		return
+	case "functions":
+		functionsCommand()
+		return
+	case "convert":
+		convertCommand(flag.Args()[1:])
+		return
+	case "agent":
+		agentCommand(flag.Args()[1:])
+		return
+	}
 
-// Add adds an item to the Item list
-func (rl *Runlist) Add(name string, pi pantry.PantryInterface) {
-	rl.Items[name] = pi
+	if err := makeDirs(); err != nil {
+		runFailed(err)
+	}
+
+	// Runs started on the command line are refused while another run holds
+	// the lock, rather than waiting for it
+	unlock, err := agent.Lock(config.Registry.StateDir)
+	if err != nil {
+		runFailed(err)
+	}
+	defer unlock()
+
+	r, err := loadRecipe()
+	if err != nil {
+		runFailed(err)
+	}
+
+	// The exit code tells whether the run failed, or changed the host
+	rep, err := runRecipe(r)
+	switch {
+	case rep == nil:
+		runFailed(err)
+	case err != nil:
+		cli.ErrorAndExit(err)
+	}
+	os.Exit(rep.ExitCode())
 }
 
-func main() {
-	flag.Parse()
-	var err error
-	var file *hcl.File
-	var diags hcl.Diagnostics
-	var p = hclparse.NewParser()
+// runFailed exits with the error of a run which could not start. With JSON
+// output the start and summary of the run are still written, so there is
+// always a summary to read.
+func runFailed(err error) {
+	if cli.FlagOutput == cli.OutputJSON {
+		report.NewEvents(os.Stdout).RunFailed(recipeSource(), err)
+	}
 
-	// Override the config registry
-	config.Registry.TempDir = cli.FlagTempDir
+	cli.ErrorAndExit(err)
+}
 
-	if cli.FlagBundle {
-		// find a rice.Box
-		templateBox, err := rice.FindBox("recipes")
-		if err != nil {
-			log.Fatal(err)
+// makeDirs makes the directories bakery keeps its files in
+func makeDirs() error {
+	for _, dir := range []string{config.Registry.TempDir, config.Registry.CacheDir, config.Registry.StateDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
 		}
+	}
+
+	return nil
+}
 
-		// get file contents as string
-		templateString, err := templateBox.String("config.yum")
+// runRecipe runs the recipe, keeping the log and the output of every
+// command run so it can be reviewed later, in the log file or a file for the
+// run in the log directory. With JSON output, the events of the run are
+// written to stdout as it happens.
+func runRecipe(r *recipe.Recipe) (*report.Report, error) {
+	var logName = config.Registry.LogFile
+	if logName == "" {
+		runLog, err := cli.OpenRunLog(config.Registry.LogDir)
 		if err != nil {
-			log.Fatal(err)
+			return nil, err
 		}
+		defer runLog.Close()
+		logName = runLog.Name()
+	}
+
+	var events *report.Events
+	if cli.FlagOutput == cli.OutputJSON {
+		events = report.NewEvents(os.Stdout)
+		events.Follow(r)
+		events.RunStart(r, recipeSource())
+	}
+
+	var started = time.Now()
+	err := r.Run()
+	fmt.Fprintf(messages, "Command output was saved to %s\n", logName)
+
+	rep := report.New(r, recipeSource(), started, err)
+	if events != nil {
+		events.RunSummary(rep)
+	}
 
-		file, diags = p.ParseHCL([]byte(templateString), "config.yum")
+	sendReport(rep)
+	return rep, err
+}
+
+// sendReport writes the report of the run, and posts it when there is a
+// report URL. The run does not fail when it cannot be reported.
+func sendReport(rep *report.Report) {
+	var name = config.Registry.ReportPath()
+	if err := rep.WriteFile(name); err != nil {
+		fmt.Fprintf(messages, "Error writing run report: %s\n", err)
 	} else {
-		file, diags = p.ParseHCLFile(cli.FlagRecipe)
+		fmt.Fprintf(messages, "Run report was saved to %s\n", name)
 	}
 
-	if len(diags) != 0 {
-		for _, diag := range diags {
-			fmt.Printf("- %s\n", diag)
-		}
+	if config.Registry.ReportURL == "" {
 		return
 	}
 
-	// Make the temp file directory
-	// TODO: refactor this out
-	err = os.MkdirAll(cli.FlagTempDir, 0755)
-	if err != nil {
-		cli.ErrorAndExit(err)
+	sender := report.NewSender(config.Registry.ReportURL, config.Registry.ReportToken, config.Registry.SpoolDir())
+	if err := sender.Send(rep); err != nil {
+		fmt.Fprintf(messages, "%s\n", err)
 	}
+}
 
-	body := file.Body
+// recipeSource returns the recipe which is run
+func recipeSource() string {
+	if cli.FlagBundle {
+		return "bundle:config.yum"
+	}
 
-	var bakery Bakery
-	diags = gohcl.DecodeBody(body, nil, &bakery)
-	if len(diags) != 0 {
-		for _, diag := range diags {
-			fmt.Printf("decoding - %s\n", diag)
-		}
-		return
+	return config.Registry.RecipeSource()
+}
+
+// loadConfig loads the settings from the manifest, the BAKERY_ environment
+// variables and then the flags given on the command line
+func loadConfig() error {
+	c, err := config.Load(cli.FlagConfig, cli.IsSet("c"), os.Environ())
+	if err != nil {
+		return err
 	}
 
-	variables := map[string]cty.Value{}
-	for _, v := range bakery.Variables {
-		if len(v.Default) == 0 {
-			continue
-		}
+	if err := cli.ApplyFlags(c); err != nil {
+		return err
+	}
 
-		val, diags := v.Default["default"].Expr.Value(nil)
-		if len(diags) != 0 {
-			for _, diag := range diags {
-				fmt.Printf("decoding - %s\n", diag)
-			}
-			return
-		}
+	if err := c.Validate(); err != nil {
+		return err
+	}
 
-		variables[v.Name] = val
+	// Tokens and passwords are kept out of the log, which is also used by
+	// anything logging with log/slog
+	cli.LogFormat = c.LogFormat
+	slog.SetDefault(cli.Logger())
+	cli.Redact(c.ReportToken)
+	if u, err := url.Parse(c.Proxy); err == nil && u.User != nil {
+		password, _ := u.User.Password()
+		cli.Redact(password)
 	}
 
-	evalContext := &hcl.EvalContext{
-		Variables: map[string]cty.Value{
-			"var": cty.ObjectVal(nil),
-		},
+	// Downloads and plugins use the proxy from the environment
+	if c.Proxy != "" {
+		os.Setenv("HTTP_PROXY", c.Proxy)
+		os.Setenv("HTTPS_PROXY", c.Proxy)
 	}
 
-	rootVal := reflect.ValueOf(bakery)
-	for i := 0; i < rootVal.NumField(); i++ {
-		for sliceinc := 0; sliceinc < rootVal.Field(i).Len(); sliceinc++ {
-			entry := rootVal.Field(i).Index(sliceinc)
-			params := []reflect.Value{reflect.ValueOf(evalContext)}
-			r := entry.MethodByName("Parse").Call(params)
-			if len(r) > 0 && r[0].Interface() != nil {
-				cli.ErrorAndExit(fmt.Errorf("%v", r[0].Interface()))
-			}
+	config.Registry = c
+	return nil
+}
 
-			runList.Add(entry.Elem().FieldByName("Name").String(), entry.Interface().(pantry.PantryInterface))
-		}
+// loadRecipe loads the recipe file or directory, or the recipe bundled with
+// the binary, printing any warnings and returning any errors
+func loadRecipe() (*recipe.Recipe, error) {
+	if err := pantry.LoadPlugins(config.Registry.PluginPath); err != nil {
+		return nil, err
 	}
 
-	for name, module := range runList.Items {
-		err := RunItem(name, module)
-		if err != nil {
-			panic(err)
+	r, diags := recipe.LoadPath(recipeSource())
+	var errs []string
+	for _, diag := range diags {
+		if diag.Severity == hcl.DiagError {
+			errs = append(errs, fmt.Sprintf("- %s", diag))
+			continue
 		}
+		fmt.Fprintf(messages, "- %s\n", diag)
 	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("%s", strings.Join(errs, "\n"))
+	}
+
+	return r, nil
 }
 
-// RunItem will perform dependency validation and perform the action
-func RunItem(name string, module pantry.PantryInterface) error {
-	if !module.Ready() {
-		deps := module.GetDependencies()
-		if len(deps) > 0 {
-			for _, k := range deps {
-				if !runList.Items[k].Ready() {
-					cli.Debug(cli.INFO, fmt.Sprintf("%s has an Unmet dependency: %s, running now\n", name, k), nil)
-					RunItem(k, runList.Items[k])
-				}
-			}
-		}
+// readBundleFile reads a file from the recipes bundled with the binary
+func readBundleFile(name string) ([]byte, error) {
+	box, err := rice.FindBox("recipes")
+	if err != nil {
+		return nil, err
+	}
+
+	return box.Bytes(name)
+}
 
-		cli.Debug(cli.INFO, "Baking", name)
-		m := module.(pantry.PantryInterface)
+// globBundleFiles returns the files bundled with the binary which match the
+// pattern
+func globBundleFiles(pattern string) ([]string, error) {
+	box, err := rice.FindBox("recipes")
+	if err != nil {
+		return nil, err
+	}
 
-		if m.ValidateOnlyIf() {
-			return nil
+	var names []string
+	err = box.Walk("", func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
 		}
 
-		if m.ValidateNotIf() {
-			return nil
+		name = strings.TrimPrefix(filepath.ToSlash(name), "/")
+		if ok, _ := path.Match(pattern, name); ok && !info.IsDir() {
+			names = append(names, name)
 		}
+		return nil
+	})
 
-		m.Bake()
-		module.Baked()
-	}
-	return nil
+	return names, err
 }
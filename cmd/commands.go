@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/mikemackintosh/bakery/agent"
+	"github.com/mikemackintosh/bakery/cli"
+	"github.com/mikemackintosh/bakery/config"
+	"github.com/mikemackintosh/bakery/pantry"
+	"github.com/mikemackintosh/bakery/recipe"
+)
+
+// importCommand converts other configuration formats into recipe blocks
+func importCommand(args []string) {
+	if len(args) != 2 || args[0] != "brewfile" {
+		cli.ErrorAndExit(fmt.Errorf("usage: bakery import brewfile <path>\n"))
+	}
+
+	f, err := os.Open(args[1])
+	if err != nil {
+		cli.ErrorAndExit(err)
+	}
+	defer f.Close()
+
+	entries, err := pantry.ParseBrewfile(f)
+	if err != nil {
+		cli.ErrorAndExit(err)
+	}
+
+	os.Stdout.Write(pantry.BrewfileToHCL(entries))
+}
+
+// exportCommand converts the recipe blocks into other configuration formats
+func exportCommand(args []string) {
+	if len(args) != 1 || args[0] != "brewfile" {
+		cli.ErrorAndExit(fmt.Errorf("usage: bakery [-r recipe] export brewfile\n"))
+	}
+
+	r, err := loadRecipe()
+	if err != nil {
+		cli.ErrorAndExit(err)
+	}
+
+	var entries []*pantry.BrewfileEntry
+	for _, n := range r.Runlist {
+		brew, ok := n.Resource.(*pantry.Brew)
+		if !ok {
+			continue
+		}
+
+		if err := brew.Parse(r.BlockEvalContext(n)); err != nil {
+			cli.ErrorAndExit(err)
+		}
+
+		if brew.Action == "remove" {
+			continue
+		}
+		entries = append(entries, brew.BrewfileEntry())
+	}
+
+	if err := pantry.WriteBrewfile(os.Stdout, entries); err != nil {
+		cli.ErrorAndExit(err)
+	}
+}
+
+// convertCommand converts a JSON recipe into native syntax, or a native
+// recipe into JSON
+func convertCommand(args []string) {
+	if len(args) != 1 {
+		cli.ErrorAndExit(fmt.Errorf("usage: bakery convert <path>\n"))
+	}
+
+	src, err := ioutil.ReadFile(args[0])
+	if err != nil {
+		cli.ErrorAndExit(err)
+	}
+
+	var convert = recipe.HCLToJSON
+	if strings.HasSuffix(args[0], ".json") {
+		convert = recipe.JSONToHCL
+	}
+
+	out, diags := convert(src, args[0])
+	if diags.HasErrors() {
+		for _, diag := range diags {
+			fmt.Printf("- %s\n", diag)
+		}
+		os.Exit(1)
+	}
+
+	os.Stdout.Write(out)
+}
+
+// functionsCommand lists the functions which can be used in recipes
+func functionsCommand() {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, f := range recipe.DescribeFunctions() {
+		fmt.Fprintf(w, "%s\t%s\n", f.Signature, f.Description)
+	}
+	w.Flush()
+}
+
+// agentCommand runs the recipe on an interval until it is stopped, or
+// prints the status of the agent which is running
+func agentCommand(args []string) {
+	switch {
+	case len(args) == 1 && args[0] == "status":
+		status, err := agent.Query(config.Registry.Socket())
+		if err != nil {
+			cli.ErrorAndExit(err)
+		}
+
+		b, err := json.MarshalIndent(status, "", "  ")
+		if err != nil {
+			cli.ErrorAndExit(err)
+		}
+		fmt.Printf("%s\n", b)
+		return
+	case len(args) != 0:
+		cli.ErrorAndExit(fmt.Errorf("usage: bakery agent [status]\n"))
+	}
+
+	if err := makeDirs(); err != nil {
+		cli.ErrorAndExit(err)
+	}
+
+	a := &agent.Agent{
+		Load: func() (agent.Job, error) {
+			r, err := loadRecipe()
+			if err != nil {
+				return nil, fmt.Errorf("The recipe could not be loaded:\n%s", err)
+			}
+			return recipeJob{r}, nil
+		},
+		Reload: func() error {
+			if err := loadConfig(); err != nil {
+				return err
+			}
+			return makeDirs()
+		},
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGTERM, os.Interrupt)
+	if err := a.Serve(config.Registry.Socket(), signals); err != nil {
+		cli.ErrorAndExit(err)
+	}
+}
+
+// recipeJob runs a recipe for the agent
+type recipeJob struct {
+	*recipe.Recipe
+}
+
+// Run runs the recipe, keeping the output of its commands
+func (j recipeJob) Run() error {
+	_, err := runRecipe(j.Recipe)
+	return err
+}
@@ -1,70 +1,402 @@
 package config
 
 import (
-	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	yaml "gopkg.in/yaml.v2"
 )
 
+// Defaults of the settings which are not set by the manifest, environment or
+// flags
+const (
+	DefaultTempDir    = "/var/bakery/tmp"
+	DefaultCacheDir   = "/var/bakery/cache"
+	DefaultStateDir   = "/var/bakery/state"
+	DefaultLogDir     = "/var/bakery/logs"
+	DefaultPluginPath = "/var/bakery/plugins"
+	DefaultRecipe     = "config.yum"
+)
+
+// Failure policies, which decide what happens to the rest of the run when a
+// block fails
+const (
+	FailureStop     = "stop"
+	FailureContinue = "continue"
+)
+
+// Log formats
+const (
+	LogText = "text"
+	LogJSON = "json"
+)
+
+// EnvPrefix is the prefix of the environment variables which override the
+// settings of the manifest, such as BAKERY_TMP_DIR
+const EnvPrefix = "BAKERY_"
+
 var Registry *Configuration
 
+// Configuration holds the settings of bakery. Each setting is named by its
+// manifest key, which is also used for its environment variable.
 type Configuration struct {
-	TempDir string `json:"tmp_dir" yaml:"tmp_dir"`
+	TempDir    string `json:"tmp_dir" yaml:"tmp_dir"`
+	CacheDir   string `json:"cache_dir" yaml:"cache_dir"`
+	StateDir   string `json:"state_dir" yaml:"state_dir"`
+	LogDir     string `json:"log_dir" yaml:"log_dir"`
+	LogFile    string `json:"log_file" yaml:"log_file"`
+	PluginPath string `json:"plugin_path" yaml:"plugin_path"`
+
+	// LogFormat is the format of the log, text or json. The log file is
+	// rotated once it grows past LogMaxSize megabytes, keeping LogMaxFiles
+	// previous files.
+	LogFormat   string `json:"log_format" yaml:"log_format"`
+	LogMaxSize  int    `json:"log_max_size" yaml:"log_max_size"`
+	LogMaxFiles int    `json:"log_max_files" yaml:"log_max_files"`
+
+	// Parallelism is how many blocks can run at once, and FailurePolicy
+	// whether the run stops or continues when a block fails
+	Parallelism   int    `json:"parallelism" yaml:"parallelism"`
+	FailurePolicy string `json:"failure_policy" yaml:"failure_policy"`
+
+	// Proxy is used for HTTP and HTTPS requests, and Mirrors replace the
+	// prefix of the URLs files are downloaded from
+	Proxy   string            `json:"proxy" yaml:"proxy"`
+	Mirrors map[string]string `json:"mirrors" yaml:"mirrors"`
+
+	// Recipe is the recipe file or directory which is run, unless RecipeURL
+	// is set to fetch a signed recipe from
+	Recipe    string `json:"recipe" yaml:"recipe"`
+	RecipeURL string `json:"recipe_url" yaml:"recipe_url"`
+
+	// AgentInterval is how often the agent runs the recipe, waiting up to
+	// AgentSplay longer so hosts do not all run at once, and AgentSocket
+	// the Unix socket it reports its status on
+	AgentInterval time.Duration `json:"agent_interval" yaml:"agent_interval"`
+	AgentSplay    time.Duration `json:"agent_splay" yaml:"agent_splay"`
+	AgentSocket   string        `json:"agent_socket" yaml:"agent_socket"`
+
+	// ReportFile is where the report of each run is written, and ReportURL
+	// where it is posted to, with ReportToken as its bearer token
+	ReportFile  string `json:"report_file" yaml:"report_file"`
+	ReportURL   string `json:"report_url" yaml:"report_url"`
+	ReportToken string `json:"report_token" yaml:"report_token"`
 }
 
 func init() {
 	Registry = &Configuration{}
 }
 
+// Defaults returns the settings used when nothing else sets them
+func Defaults() *Configuration {
+	return &Configuration{
+		TempDir:       DefaultTempDir,
+		CacheDir:      DefaultCacheDir,
+		StateDir:      DefaultStateDir,
+		LogDir:        DefaultLogDir,
+		PluginPath:    DefaultPluginPath,
+		LogFormat:     LogText,
+		LogMaxSize:    10,
+		LogMaxFiles:   5,
+		Parallelism:   1,
+		FailurePolicy: FailureStop,
+		Recipe:        DefaultRecipe,
+		AgentInterval: 30 * time.Minute,
+		AgentSplay:    5 * time.Minute,
+	}
+}
+
+// Load returns the defaults, overridden by the manifest file and then by the
+// BAKERY_ environment variables. A manifest which does not exist is skipped,
+// unless it is required.
+func Load(file string, required bool, environ []string) (*Configuration, error) {
+	c := Defaults()
+
+	b, err := ioutil.ReadFile(file)
+	switch {
+	case err == nil:
+		if err := c.parse(b); err != nil {
+			return nil, fmt.Errorf("Error in manifest %s: %s", file, err)
+		}
+	case required || !os.IsNotExist(err):
+		return nil, fmt.Errorf("Error while loading config from file %s", err)
+	}
+
+	for _, kv := range environ {
+		if !strings.HasPrefix(kv, EnvPrefix) {
+			continue
+		}
+
+		i := strings.Index(kv, "=")
+		if i < 0 {
+			continue
+		}
+
+		key := strings.ToLower(strings.TrimPrefix(kv[:i], EnvPrefix))
+		if !IsSetting(key) {
+			continue
+		}
+		if err := c.Set(key, kv[i+1:]); err != nil {
+			return nil, fmt.Errorf("Error in %s: %s", kv[:i], err)
+		}
+	}
+
+	return c, nil
+}
+
 // NewFromFile loads the config at the filepath of f
 func NewFromFile(f string) error {
-
-	configFile, err := ioutil.ReadFile(f)
+	c, err := Load(f, true, nil)
 	if err != nil {
-		return fmt.Errorf("Error while loading config from file %s", err)
+		return err
 	}
 
-	c, err := ParseConfig(configFile)
-	if err != nil {
+	if err := c.Validate(); err != nil {
 		return err
 	}
 
 	Registry = c
-
 	return nil
 }
 
-// ParseConfig parses a configuration file
+// ParseConfig parses a YAML or JSON configuration file over the defaults,
+// rejecting any keys which are not settings
 func ParseConfig(config []byte) (*Configuration, error) {
-	var c *Configuration
-	var ok bool
-	// This should only really be used locally now that the configs are made and stored in datastore
-	c, ok = isYaml(config)
+	c := Defaults()
+	if err := c.parse(config); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// parse parses a YAML manifest, which can also be written as JSON, into the
+// configuration
+func (c *Configuration) parse(b []byte) error {
+	if err := yaml.UnmarshalStrict(b, c); err != nil {
+		return fmt.Errorf("Configuration file is not valid: %s", err)
+	}
+
+	return nil
+}
+
+// settings set each setting from a string, by its manifest key
+var settings = map[string]func(c *Configuration, v string) error{
+	"tmp_dir":     func(c *Configuration, v string) error { c.TempDir = v; return nil },
+	"cache_dir":   func(c *Configuration, v string) error { c.CacheDir = v; return nil },
+	"state_dir":   func(c *Configuration, v string) error { c.StateDir = v; return nil },
+	"log_dir":     func(c *Configuration, v string) error { c.LogDir = v; return nil },
+	"log_file":    func(c *Configuration, v string) error { c.LogFile = v; return nil },
+	"plugin_path": func(c *Configuration, v string) error { c.PluginPath = v; return nil },
+	"log_format":  func(c *Configuration, v string) error { c.LogFormat = v; return nil },
+	"log_max_size": func(c *Configuration, v string) (err error) {
+		c.LogMaxSize, err = strconv.Atoi(v)
+		return err
+	},
+	"log_max_files": func(c *Configuration, v string) (err error) {
+		c.LogMaxFiles, err = strconv.Atoi(v)
+		return err
+	},
+	"parallelism": func(c *Configuration, v string) (err error) {
+		c.Parallelism, err = strconv.Atoi(v)
+		return err
+	},
+	"failure_policy": func(c *Configuration, v string) error { c.FailurePolicy = v; return nil },
+	"proxy":          func(c *Configuration, v string) error { c.Proxy = v; return nil },
+	"mirrors": func(c *Configuration, v string) error {
+		c.Mirrors = map[string]string{}
+		for _, m := range strings.Split(v, ",") {
+			i := strings.Index(m, "=")
+			if i < 0 {
+				return fmt.Errorf("mirrors must be a list of url=mirror, separated by commas")
+			}
+			c.Mirrors[strings.TrimSpace(m[:i])] = strings.TrimSpace(m[i+1:])
+		}
+		return nil
+	},
+	"recipe":     func(c *Configuration, v string) error { c.Recipe = v; return nil },
+	"recipe_url": func(c *Configuration, v string) error { c.RecipeURL = v; return nil },
+	"agent_interval": func(c *Configuration, v string) (err error) {
+		c.AgentInterval, err = time.ParseDuration(v)
+		return err
+	},
+	"agent_splay": func(c *Configuration, v string) (err error) {
+		c.AgentSplay, err = time.ParseDuration(v)
+		return err
+	},
+	"agent_socket": func(c *Configuration, v string) error { c.AgentSocket = v; return nil },
+	"report_file":  func(c *Configuration, v string) error { c.ReportFile = v; return nil },
+	"report_url":   func(c *Configuration, v string) error { c.ReportURL = v; return nil },
+	"report_token": func(c *Configuration, v string) error { c.ReportToken = v; return nil },
+}
+
+// IsSetting returns true when the key is the manifest key of a setting
+func IsSetting(key string) bool {
+	_, ok := settings[key]
+	return ok
+}
+
+// Set sets a setting by its manifest key, from a string as it is given in
+// the environment or a flag. Mirrors are given as url=mirror, separated by
+// commas.
+func (c *Configuration) Set(key, value string) error {
+	set, ok := settings[key]
 	if !ok {
-		c, ok = isJSON(config)
-		if !ok {
-			return c, fmt.Errorf("Configuration file is not in valid JSON or YAML format")
+		return fmt.Errorf("%q is not a setting", key)
+	}
+
+	return set(c, value)
+}
+
+// Validate returns an error for the first setting which is not valid
+func (c *Configuration) Validate() error {
+	if c.Parallelism < 1 {
+		return fmt.Errorf("parallelism must be at least 1, not %d", c.Parallelism)
+	}
+
+	if c.LogFormat != LogText && c.LogFormat != LogJSON {
+		return fmt.Errorf("log_format must be %s or %s, not %q", LogText, LogJSON, c.LogFormat)
+	}
+	if c.LogMaxSize < 1 {
+		return fmt.Errorf("log_max_size must be at least 1, not %d", c.LogMaxSize)
+	}
+	if c.LogMaxFiles < 0 {
+		return fmt.Errorf("log_max_files cannot be negative, not %d", c.LogMaxFiles)
+	}
+
+	if c.FailurePolicy != FailureStop && c.FailurePolicy != FailureContinue {
+		return fmt.Errorf("failure_policy must be %s or %s, not %q", FailureStop, FailureContinue, c.FailurePolicy)
+	}
+
+	if c.Proxy != "" {
+		if err := validURL(c.Proxy, "http", "https", "socks5"); err != nil {
+			return fmt.Errorf("proxy %s", err)
 		}
 	}
-	return c, nil
+
+	for prefix, mirror := range c.Mirrors {
+		if err := validURL(prefix, "http", "https"); err != nil {
+			return fmt.Errorf("mirror %s", err)
+		}
+		if err := validURL(mirror, "http", "https"); err != nil {
+			return fmt.Errorf("mirror %s", err)
+		}
+	}
+
+	if c.RecipeURL != "" {
+		if err := validURL(c.RecipeURL, "https"); err != nil {
+			return fmt.Errorf("recipe_url %s", err)
+		}
+	}
+
+	if c.AgentInterval <= 0 {
+		return fmt.Errorf("agent_interval must be longer than 0s, not %s", c.AgentInterval)
+	}
+	if c.AgentSplay < 0 {
+		return fmt.Errorf("agent_splay cannot be negative, not %s", c.AgentSplay)
+	}
+
+	// Reports can be posted over http to a receiver on the host itself, for
+	// testing
+	if c.ReportURL != "" && !isLocalhost(c.ReportURL) {
+		if err := validURL(c.ReportURL, "https"); err != nil {
+			return fmt.Errorf("report_url %s", err)
+		}
+	}
+
+	return nil
 }
 
-// isJSON tests if the file is JSON and returns decoded config
-func isJSON(file []byte) (*Configuration, bool) {
-	test := &Configuration{}
-	if err := json.Unmarshal(file, &test); err != nil {
-		return test, false
+// RecipeSource returns the URL the recipe is fetched from, or the recipe
+// file or directory
+func (c *Configuration) RecipeSource() string {
+	if c.RecipeURL != "" {
+		return c.RecipeURL
 	}
-	return test, true
+
+	return c.Recipe
 }
 
-// isYaml tests if the file is YAML and returns decoded config
-func isYaml(file []byte) (*Configuration, bool) {
-	test := &Configuration{}
-	if err := yaml.Unmarshal(file, &test); err != nil {
-		return test, false
+// Socket returns the Unix socket the agent reports its status on, which is
+// in the state directory unless it is set
+func (c *Configuration) Socket() string {
+	if c.AgentSocket != "" {
+		return c.AgentSocket
 	}
-	return test, true
+
+	return filepath.Join(c.StateDir, "agent.sock")
+}
+
+// ReportPath returns the file the report of each run is written to, which is
+// in the state directory unless it is set
+func (c *Configuration) ReportPath() string {
+	if c.ReportFile != "" {
+		return c.ReportFile
+	}
+
+	return filepath.Join(c.StateDir, "last_run.json")
+}
+
+// LogMaxBytes returns the size in bytes the log file is rotated at
+func (c *Configuration) LogMaxBytes() int64 {
+	return int64(c.LogMaxSize) << 20
+}
+
+// SpoolDir returns the directory reports are kept in until they are posted
+func (c *Configuration) SpoolDir() string {
+	return filepath.Join(c.StateDir, "spool")
+}
+
+// validURL returns an error unless the URL has a host and one of the schemes
+func validURL(s string, schemes ...string) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return fmt.Errorf("%q is not a valid URL: %s", s, err)
+	}
+
+	for _, scheme := range schemes {
+		if u.Scheme == scheme && u.Host != "" {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%q must be a URL starting with %s://", s, strings.Join(schemes, ":// or "))
+}
+
+// isLocalhost returns true for http URLs of the host itself
+func isLocalhost(s string) bool {
+	u, err := url.Parse(s)
+	if err != nil || u.Scheme != "http" {
+		return false
+	}
+
+	switch u.Hostname() {
+	case "localhost", "127.0.0.1", "::1":
+		return true
+	}
+
+	return false
+}
+
+// Mirror returns the URL a file is downloaded from, replacing the longest
+// prefix of the source which has a mirror
+func (c *Configuration) Mirror(source string) string {
+	var longest string
+	for prefix := range c.Mirrors {
+		if strings.HasPrefix(source, prefix) && len(prefix) > len(longest) {
+			longest = prefix
+		}
+	}
+
+	if longest == "" {
+		return source
+	}
+
+	return c.Mirrors[longest] + strings.TrimPrefix(source, longest)
 }
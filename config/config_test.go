@@ -0,0 +1,110 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	manifest := filepath.Join(dir, "manifest.yml")
+	err = ioutil.WriteFile(manifest, []byte(`---
+tmp_dir: /tmp/bakery
+parallelism: 4
+agent_interval: 1h
+mirrors:
+  https://github.com/: https://mirror.example.com/github/
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := Load(manifest, true, []string{"BAKERY_PARALLELISM=8", "BAKERY_AGENT_SPLAY=10s", "BAKERY_NOPE=1", "PATH=/bin"})
+	if err != nil {
+		t.Fatalf("want no error but got %s", err)
+	}
+
+	if c.TempDir != "/tmp/bakery" {
+		t.Errorf("want the manifest to set tmp_dir but got %s", c.TempDir)
+	}
+	if c.Parallelism != 8 {
+		t.Errorf("want the environment to override parallelism but got %d", c.Parallelism)
+	}
+	if c.AgentInterval != time.Hour || c.AgentSplay != 10*time.Second {
+		t.Errorf("want the agent to run every 1h with a 10s splay but got %s and %s", c.AgentInterval, c.AgentSplay)
+	}
+	if c.CacheDir != DefaultCacheDir || c.FailurePolicy != FailureStop {
+		t.Errorf("want the defaults for unset settings but got %#v", c)
+	}
+	if err := c.Validate(); err != nil {
+		t.Errorf("want no error but got %s", err)
+	}
+
+	got := c.Mirror("https://github.com/org/repo.tar.gz")
+	if got != "https://mirror.example.com/github/org/repo.tar.gz" {
+		t.Errorf("want the mirror to be used but got %s", got)
+	}
+	if got := c.Mirror("https://example.com/a.zip"); got != "https://example.com/a.zip" {
+		t.Errorf("want sources without a mirror to be kept but got %s", got)
+	}
+
+	if _, err := Load(filepath.Join(dir, "missing.yml"), false, nil); err != nil {
+		t.Errorf("want a missing manifest to be skipped but got %s", err)
+	}
+	if _, err := Load(filepath.Join(dir, "missing.yml"), true, nil); err == nil {
+		t.Errorf("want an error for a missing manifest which is required")
+	}
+}
+
+var configErrorTests = []struct {
+	Manifest string
+	Environ  []string
+	Expected string
+}{
+	{"tmp_dir: /tmp\ntemp_dir: /tmp", nil, "field temp_dir not found"},
+	{`{"tmp_dir": "/tmp", "paralellism": 2}`, nil, "field paralellism not found"},
+	{"parallelism: many", nil, "cannot unmarshal"},
+	{"", []string{"BAKERY_PARALLELISM=many"}, "Error in BAKERY_PARALLELISM"},
+	{"", []string{"BAKERY_MIRRORS=https://example.com"}, "url=mirror"},
+	{"parallelism: 0", nil, "parallelism must be at least 1"},
+	{"agent_interval: 0s", nil, "agent_interval must be longer than 0s"},
+	{"", []string{"BAKERY_AGENT_SPLAY=often"}, "Error in BAKERY_AGENT_SPLAY"},
+	{"log_format: xml", nil, `log_format must be text or json, not "xml"`},
+	{"", []string{"BAKERY_LOG_MAX_SIZE=0"}, "log_max_size must be at least 1"},
+	{"failure_policy: ignore", nil, `failure_policy must be stop or continue, not "ignore"`},
+	{"proxy: proxy.example.com:3128", nil, "proxy \"proxy.example.com:3128\" must be a URL"},
+	{"mirrors:\n  https://example.com/: /srv/mirror", nil, "mirror \"/srv/mirror\" must be a URL"},
+	{"report_url: http://reports.example.com/reports", nil, "report_url \"http://reports.example.com/reports\" must be a URL starting with https://"},
+}
+
+func TestConfigErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	manifest := filepath.Join(dir, "manifest.yml")
+	for _, test := range configErrorTests {
+		if err := ioutil.WriteFile(manifest, []byte(test.Manifest), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		c, err := Load(manifest, true, test.Environ)
+		if err == nil {
+			err = c.Validate()
+		}
+		if err == nil || !strings.Contains(err.Error(), test.Expected) {
+			t.Errorf("want %s but got %v", test.Expected, err)
+		}
+	}
+}
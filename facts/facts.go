@@ -0,0 +1,143 @@
+package facts
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// Platform families
+const (
+	FamilyDarwin = "darwin"
+	FamilyDebian = "debian"
+	FamilyRHEL   = "rhel"
+	FamilyFedora = "fedora"
+	FamilySUSE   = "suse"
+	FamilyAlpine = "alpine"
+	FamilyArch   = "arch"
+)
+
+// Facts describes the host bakery is running on
+type Facts struct {
+	OS              string `json:"os"`
+	Arch            string `json:"arch"`
+	Hostname        string `json:"hostname"`
+	Platform        string `json:"platform"`
+	PlatformFamily  string `json:"platform_family"`
+	PlatformVersion string `json:"platform_version"`
+}
+
+var osReleasePath = "/etc/os-release"
+
+var host *Facts
+var once sync.Once
+
+// Get returns the facts for the current host, gathering them on first use
+func Get() *Facts {
+	once.Do(func() {
+		host = Gather()
+	})
+
+	return host
+}
+
+// Gather collects the facts for the current host
+func Gather() *Facts {
+	f := &Facts{
+		OS:   runtime.GOOS,
+		Arch: runtime.GOARCH,
+	}
+
+	f.Hostname, _ = os.Hostname()
+
+	switch runtime.GOOS {
+	case "darwin":
+		f.Platform = "mac_os_x"
+		f.PlatformFamily = FamilyDarwin
+		if out, err := exec.Command("sw_vers", "-productVersion").Output(); err == nil {
+			f.PlatformVersion = strings.TrimSpace(string(out))
+		}
+	case "linux":
+		if r, err := os.Open(osReleasePath); err == nil {
+			defer r.Close()
+			f.setOSRelease(ParseOSRelease(r))
+		}
+	}
+
+	return f
+}
+
+// setOSRelease populates the platform facts from an os-release file
+func (f *Facts) setOSRelease(release map[string]string) {
+	f.Platform = release["ID"]
+	f.PlatformVersion = release["VERSION_ID"]
+	f.PlatformFamily = family(release["ID"], strings.Fields(release["ID_LIKE"]))
+}
+
+// ParseOSRelease reads the key value pairs from an os-release file
+func ParseOSRelease(r io.Reader) map[string]string {
+	release := map[string]string{}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+
+		release[kv[0]] = strings.Trim(kv[1], `"'`)
+	}
+
+	return release
+}
+
+// families maps distribution ids to their platform family
+var families = map[string]string{
+	"debian":    FamilyDebian,
+	"ubuntu":    FamilyDebian,
+	"linuxmint": FamilyDebian,
+	"raspbian":  FamilyDebian,
+	"pop":       FamilyDebian,
+	"rhel":      FamilyRHEL,
+	"centos":    FamilyRHEL,
+	"rocky":     FamilyRHEL,
+	"almalinux": FamilyRHEL,
+	"ol":        FamilyRHEL,
+	"amzn":      FamilyRHEL,
+	"fedora":    FamilyFedora,
+	"opensuse":  FamilySUSE,
+	"sles":      FamilySUSE,
+	"suse":      FamilySUSE,
+	"alpine":    FamilyAlpine,
+	"arch":      FamilyArch,
+	"manjaro":   FamilyArch,
+}
+
+// family finds the platform family from the distribution id, falling back
+// to the distributions it is like
+func family(id string, like []string) string {
+	if strings.HasPrefix(id, "opensuse") {
+		return FamilySUSE
+	}
+
+	if f, ok := families[id]; ok {
+		return f
+	}
+
+	for _, l := range like {
+		if f, ok := families[l]; ok {
+			return f
+		}
+	}
+
+	return id
+}
@@ -0,0 +1,75 @@
+package facts
+
+import (
+	"strings"
+	"testing"
+)
+
+var testOSRelease = []struct {
+	Release  string
+	Platform string
+	Family   string
+	Version  string
+}{
+	{
+		Release: `NAME="Ubuntu"
+VERSION="20.04 LTS (Focal Fossa)"
+ID=ubuntu
+ID_LIKE=debian
+VERSION_ID="20.04"`,
+		Platform: "ubuntu",
+		Family:   FamilyDebian,
+		Version:  "20.04",
+	},
+	{
+		Release: `NAME="Rocky Linux"
+ID="rocky"
+ID_LIKE="rhel centos fedora"
+VERSION_ID="8.5"`,
+		Platform: "rocky",
+		Family:   FamilyRHEL,
+		Version:  "8.5",
+	},
+	{
+		Release: `# openSUSE
+NAME="openSUSE Leap"
+ID="opensuse-leap"
+ID_LIKE="suse opensuse"
+VERSION_ID="15.3"`,
+		Platform: "opensuse-leap",
+		Family:   FamilySUSE,
+		Version:  "15.3",
+	},
+	{
+		Release: `NAME="Alpine Linux"
+ID=alpine
+VERSION_ID=3.15.0`,
+		Platform: "alpine",
+		Family:   FamilyAlpine,
+		Version:  "3.15.0",
+	},
+	{
+		Release: `ID=elementary
+ID_LIKE="ubuntu debian"
+VERSION_ID="6.1"`,
+		Platform: "elementary",
+		Family:   FamilyDebian,
+		Version:  "6.1",
+	},
+}
+
+func TestParseOSRelease(t *testing.T) {
+	for _, test := range testOSRelease {
+		f := &Facts{}
+		f.setOSRelease(ParseOSRelease(strings.NewReader(test.Release)))
+		if f.Platform != test.Platform {
+			t.Errorf("want platform %s but got %s", test.Platform, f.Platform)
+		}
+		if f.PlatformFamily != test.Family {
+			t.Errorf("want family %s but got %s", test.Family, f.PlatformFamily)
+		}
+		if f.PlatformVersion != test.Version {
+			t.Errorf("want version %s but got %s", test.Version, f.PlatformVersion)
+		}
+	}
+}
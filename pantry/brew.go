@@ -4,8 +4,9 @@ package pantry
 
 import (
 	"fmt"
-	"os"
-	"strconv"
+	"path"
+	"strings"
+	"syscall"
 
 	"github.com/hashicorp/hcl2/hcl"
 	"github.com/hashicorp/hcl2/hcldec"
@@ -13,12 +14,30 @@ import (
 	"github.com/zclconf/go-cty/cty"
 )
 
-var brewBin = "/usr/local/bin/brew"
+var brewPrefix = "/usr/local"
+var brewBin = brewPrefix + "/bin/brew"
+var masBin = brewPrefix + "/bin/mas"
+
+// Brew package types
+const (
+	BrewTypeFormula = "formula"
+	BrewTypeCask    = "cask"
+	BrewTypeTap     = "tap"
+	BrewTypeMas     = "mas"
+)
 
 // Brew is a brew object
 type Brew struct {
 	PantryItem
-	Action string `json:"action"`
+	Action string  `json:"action"`
+	Type   *string `json:"type"`
+	ID     *string `json:"id"`
+	Source *string `json:"source"`
+
+	// args are added to the install command, and restartService restarts
+	// the service of a formula, from the options of a Brewfile entry
+	args           []string
+	restartService string
 }
 
 // Identifies the brew spec
@@ -28,6 +47,21 @@ var brewSpec = NewPantrySpec(&hcldec.ObjectSpec{
 		Required: true,
 		Type:     cty.String,
 	},
+	"type": &hcldec.AttrSpec{
+		Name:     "type",
+		Required: false,
+		Type:     cty.String,
+	},
+	"id": &hcldec.AttrSpec{
+		Name:     "id",
+		Required: false,
+		Type:     cty.String,
+	},
+	"source": &hcldec.AttrSpec{
+		Name:     "source",
+		Required: false,
+		Type:     cty.String,
+	},
 })
 
 // Parse the confgiuration with the provided spec
@@ -46,41 +80,141 @@ func (p *Brew) Parse(evalContext *hcl.EvalContext) error {
 		return err
 	}
 
+	switch p.GetType() {
+	case BrewTypeFormula, BrewTypeCask, BrewTypeTap:
+	case BrewTypeMas:
+		if p.ID == nil {
+			return fmt.Errorf("brew %q of type mas requires an id", p.Name)
+		}
+	default:
+		return fmt.Errorf("brew %q has an invalid type %q", p.Name, p.GetType())
+	}
+
 	return nil
 }
 
+// GetType returns the brew type, defaulting to a formula
+func (p *Brew) GetType() string {
+	if p.Type != nil && len(*p.Type) > 0 {
+		return *p.Type
+	}
+
+	return BrewTypeFormula
+}
+
+// Installed reports if the formula, cask, tap or app is already present
+func (p *Brew) Installed() bool {
+	switch p.GetType() {
+	case BrewTypeCask:
+		return FileExists(brewPrefix + "/Caskroom/" + path.Base(p.Name))
+	case BrewTypeTap:
+		parts := strings.SplitN(p.Name, "/", 2)
+		if len(parts) != 2 {
+			return false
+		}
+		return FileExists(fmt.Sprintf("%s/Homebrew/Library/Taps/%s/homebrew-%s", brewPrefix, parts[0], strings.TrimPrefix(parts[1], "homebrew-")))
+	case BrewTypeMas:
+		o, err := p.run([]string{masBin, "list"}, "")
+		if err != nil {
+			return false
+		}
+		for _, line := range o.ByLine() {
+			if strings.HasPrefix(line, *p.ID+" ") {
+				return true
+			}
+		}
+		return false
+	}
+
+	return FileExists(brewPrefix + "/Cellar/" + path.Base(p.Name))
+}
+
+// command builds the command used to action the brew for the provided verb
+func (p *Brew) command(action string) ([]string, error) {
+	switch p.GetType() {
+	case BrewTypeCask:
+		switch action {
+		case "install", "upgrade":
+			return append([]string{brewBin, action, "--cask", p.Name}, p.args...), nil
+		case "remove":
+			return []string{brewBin, "uninstall", "--cask", p.Name}, nil
+		}
+	case BrewTypeTap:
+		switch action {
+		case "install", "upgrade":
+			cmd := []string{brewBin, "tap", p.Name}
+			if p.Source != nil {
+				cmd = append(cmd, *p.Source)
+			}
+			return cmd, nil
+		case "remove":
+			return []string{brewBin, "untap", p.Name}, nil
+		}
+	case BrewTypeMas:
+		switch action {
+		case "install", "upgrade":
+			return []string{masBin, action, *p.ID}, nil
+		case "remove":
+			return []string{masBin, "uninstall", *p.ID}, nil
+		}
+	default:
+		switch action {
+		case "install", "upgrade":
+			return append([]string{brewBin, action, p.Name}, p.args...), nil
+		case "remove":
+			return []string{brewBin, action, p.Name}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("Please provide a valid verb")
+}
+
+// run will run the command as the user, or the invoking user when it is not
+// set, since brew refuses to run as root, streaming its output when stream is
+// set
+func (p *Brew) run(cmd []string, stream string) (*CommandResponse, error) {
+	var user = "self"
+	if p.User != nil {
+		user = *p.User
+	}
+
+	uid, gid, err := GetUIDAndGID(user)
+	if err != nil {
+		return nil, err
+	}
+
+	return Runner.Run(&Command{
+		Args:       cmd,
+		Credential: &syscall.Credential{Uid: uid, Gid: gid},
+		Stream:     stream,
+	})
+}
+
 // Bake will action the configuration
 func (p *Brew) Bake() {
 	//
 	if !FileExists(brewBin) {
 		cli.Debug(cli.ERROR, "Missing Brew Dependency", nil)
+		p.Fail(fmt.Errorf("brew is not installed at %s", brewBin))
 		return
 	}
 
-	var verb string
-	switch p.Action {
-	case "install":
-		verb = "install"
-		if FileExists("/usr/local/Cellar/" + p.Name) {
-			cli.Debug(cli.INFO, "\t-> Skipping, already installed - Did you mean 'upgrade'?", nil)
-			return
-		}
-	case "upgrade":
-		verb = "upgrade"
-	case "remove":
-		verb = "remove"
-	default:
-		fmt.Printf("Please provide a valid verb")
+	if p.Action == "install" && p.Installed() {
+		cli.Debug(cli.INFO, "\t-> Skipping, already installed - Did you mean 'upgrade'?", nil)
+		p.Unchanged()
 		return
 	}
 
-	uid, _ := strconv.ParseUint(os.Getenv("SUDO_UID"), 10, 64)
-	gid, _ := strconv.ParseUint(os.Getenv("SUDO_GID"), 10, 64)
-	var brewCmd = []string{brewBin, verb, p.Name}
-	o, err := RunCommandAsUser(brewCmd, uint32(uid), uint32(gid))
+	brewCmd, err := p.command(p.Action)
 	if err != nil {
 		cli.Debug(cli.ERROR, err.Error(), nil)
+		p.Fail(err)
+		return
 	}
 
-	cli.Debug(cli.INFO, "\t-> Output:", o)
+	_, err = p.run(brewCmd, p.Name)
+	if err != nil {
+		cli.Debug(cli.ERROR, err.Error(), nil)
+		p.Fail(err)
+	}
 }
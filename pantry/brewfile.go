@@ -0,0 +1,487 @@
+package pantry
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl2/hcl"
+	"github.com/hashicorp/hcl2/hcldec"
+	"github.com/hashicorp/hcl2/hclwrite"
+	"github.com/mikemackintosh/bakery/cli"
+	homedir "github.com/mitchellh/go-homedir"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// Brewfile is a brewfile object, which applies a Brewfile directly
+type Brewfile struct {
+	PantryItem
+	Source string `json:"source"`
+}
+
+// Identifies the brewfile spec
+var brewfileSpec = NewPantrySpec(&hcldec.ObjectSpec{
+	"source": &hcldec.AttrSpec{
+		Name:     "source",
+		Required: true,
+		Type:     cty.String,
+	},
+})
+
+// Parse the confgiuration with the provided spec
+func (p *Brewfile) Parse(evalContext *hcl.EvalContext) error {
+	cli.Debug(cli.INFO, "Preparing brewfile", p.Name)
+	cfg, diags := hcldec.Decode(p.Config, brewfileSpec, evalContext)
+	if len(diags) != 0 {
+		for _, diag := range diags {
+			cli.Debug(cli.INFO, "\t#", diag)
+		}
+		return fmt.Errorf("%s", diags.Errs()[0])
+	}
+
+	err := p.Populate(cfg, p)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Bake will install each of the entries in the Brewfile which are not
+// already present
+func (p *Brewfile) Bake() {
+	if !FileExists(brewBin) {
+		cli.Debug(cli.ERROR, "Missing Brew Dependency", nil)
+		p.Fail(fmt.Errorf("brew is not installed at %s", brewBin))
+		return
+	}
+
+	source, err := homedir.Expand(p.Source)
+	if err != nil {
+		cli.Debug(cli.ERROR, "\t-> Error finding Brewfile", err)
+		p.Fail(err)
+		return
+	}
+
+	f, err := os.Open(source)
+	if err != nil {
+		cli.Debug(cli.ERROR, "\t-> Error opening Brewfile", err)
+		p.Fail(err)
+		return
+	}
+	defer f.Close()
+
+	entries, err := ParseBrewfile(f)
+	if err != nil {
+		cli.Debug(cli.ERROR, "\t-> Error parsing Brewfile", err)
+		p.Fail(err)
+		return
+	}
+
+	var installed, present, failed []string
+	for _, entry := range entries {
+		brew, err := entry.Brew()
+		if err != nil {
+			cli.Debug(cli.ERROR, fmt.Sprintf("\t-> Error reading %s", entry), err)
+			failed = append(failed, entry.String())
+			continue
+		}
+		brew.User = p.User
+
+		if unsupported := entry.unsupportedOptions(); len(unsupported) > 0 {
+			cli.Log(cli.WARNING, "Ignoring Brewfile options which are not supported", cli.Fields{"entry": entry.String(), "options": strings.Join(unsupported, ", ")})
+		}
+
+		// A service restarted with restart_service: true, rather than
+		// :changed, is restarted even when the formula is present
+		if brew.Installed() {
+			if brew.restartService != "true" {
+				present = append(present, entry.String())
+				continue
+			}
+		} else {
+			cmd, err := brew.command("install")
+			if err != nil {
+				failed = append(failed, entry.String())
+				continue
+			}
+
+			cli.Debug(cli.INFO, "\t-> Installing", entry.String())
+			if _, err := brew.run(cmd, p.Name); err != nil {
+				cli.Debug(cli.ERROR, fmt.Sprintf("\t-> Error installing %s", entry), err)
+				failed = append(failed, entry.String())
+				continue
+			}
+		}
+
+		if len(brew.restartService) > 0 {
+			cli.Debug(cli.INFO, "\t-> Restarting service", entry.Name)
+			if _, err := brew.run([]string{brewBin, "services", "restart", entry.Name}, p.Name); err != nil {
+				cli.Debug(cli.ERROR, fmt.Sprintf("\t-> Error restarting %s", entry.Name), err)
+				failed = append(failed, entry.String())
+				continue
+			}
+		}
+		installed = append(installed, entry.String())
+	}
+
+	cli.Debug(cli.INFO, "\t-> Installed", strings.Join(installed, ", "))
+	cli.Debug(cli.INFO, "\t-> Already present", strings.Join(present, ", "))
+	if len(failed) > 0 {
+		cli.Debug(cli.ERROR, "\t-> Failed", strings.Join(failed, ", "))
+		p.Fail(fmt.Errorf("Error installing %s", strings.Join(failed, ", ")))
+	}
+	if len(installed) == 0 && len(failed) == 0 {
+		p.Unchanged()
+	}
+}
+
+// BrewfileEntry is a single tap, brew, cask or mas line from a Brewfile
+type BrewfileEntry struct {
+	Type    string
+	Name    string
+	Source  string
+	ID      string
+	Options map[string]string
+}
+
+// brewfileTypes maps the Brewfile keywords to brew types
+var brewfileTypes = map[string]string{
+	"brew": BrewTypeFormula,
+	"cask": BrewTypeCask,
+	"tap":  BrewTypeTap,
+	"mas":  BrewTypeMas,
+}
+
+// String returns the entry as it would be written in a Brewfile
+func (e *BrewfileEntry) String() string {
+	var keyword = "brew"
+	for k, v := range brewfileTypes {
+		if v == e.Type {
+			keyword = k
+		}
+	}
+
+	line := fmt.Sprintf("%s %q", keyword, e.Name)
+	if len(e.Source) > 0 {
+		line = fmt.Sprintf("%s, %q", line, e.Source)
+	}
+	if len(e.ID) > 0 {
+		line = fmt.Sprintf("%s, id: %s", line, e.ID)
+	}
+
+	return line
+}
+
+// brewfileOptions are the options of each type of entry which are applied,
+// besides its id
+var brewfileOptions = map[string][]string{
+	BrewTypeFormula: {"args", "restart_service"},
+	BrewTypeCask:    {"args"},
+}
+
+// unsupportedOptions returns the options of the entry which are not applied,
+// sorted by name
+func (e *BrewfileEntry) unsupportedOptions() []string {
+	var unsupported []string
+	for k := range e.Options {
+		var supported bool
+		for _, option := range brewfileOptions[e.Type] {
+			supported = supported || k == option
+		}
+		if !supported {
+			unsupported = append(unsupported, k)
+		}
+	}
+	sort.Strings(unsupported)
+
+	return unsupported
+}
+
+// Brew returns the brew pantry item for the entry, with the args and
+// restart_service options of a formula or cask
+func (e *BrewfileEntry) Brew() (*Brew, error) {
+	brew := &Brew{
+		PantryItem: PantryItem{Name: e.Name},
+		Action:     "install",
+	}
+
+	if args, ok := e.Options["args"]; ok && e.Type == BrewTypeFormula {
+		list, err := brewfileList(args)
+		if err != nil {
+			return nil, fmt.Errorf("invalid args %s: %s", args, err)
+		}
+		for _, arg := range list {
+			brew.args = append(brew.args, "--"+arg)
+		}
+	} else if ok && e.Type == BrewTypeCask {
+		hash, err := brewfileHash(args)
+		if err != nil {
+			return nil, fmt.Errorf("invalid args %s: %s", args, err)
+		}
+		for _, kv := range hash {
+			switch kv[1] {
+			case "true":
+				brew.args = append(brew.args, "--"+kv[0])
+			case "false":
+			default:
+				brew.args = append(brew.args, fmt.Sprintf("--%s=%s", kv[0], kv[1]))
+			}
+		}
+	}
+
+	if restart, ok := e.Options["restart_service"]; ok && e.Type == BrewTypeFormula {
+		switch restart = strings.TrimPrefix(restart, ":"); restart {
+		case "true", "changed":
+			brew.restartService = restart
+		case "false":
+		default:
+			return nil, fmt.Errorf("invalid restart_service %s", restart)
+		}
+	}
+
+	if e.Type != BrewTypeFormula {
+		t := e.Type
+		brew.Type = &t
+	}
+	if len(e.ID) > 0 {
+		id := e.ID
+		brew.ID = &id
+	}
+	if len(e.Source) > 0 {
+		source := e.Source
+		brew.Source = &source
+	}
+
+	return brew, nil
+}
+
+// BrewfileEntry returns the Brewfile entry for the brew
+func (p *Brew) BrewfileEntry() *BrewfileEntry {
+	entry := &BrewfileEntry{
+		Type: p.GetType(),
+		Name: p.Name,
+	}
+
+	if p.ID != nil {
+		entry.ID = *p.ID
+	}
+	if p.Source != nil {
+		entry.Source = *p.Source
+	}
+
+	return entry
+}
+
+// ParseBrewfile reads the tap, brew, cask and mas entries from a Brewfile
+func ParseBrewfile(r io.Reader) ([]*BrewfileEntry, error) {
+	var entries []*BrewfileEntry
+	var lineNumber int
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(stripBrewfileComment(scanner.Text()))
+		if len(line) == 0 {
+			continue
+		}
+
+		fields := strings.SplitN(line, " ", 2)
+		brewType, ok := brewfileTypes[fields[0]]
+		if !ok {
+			cli.Debug(cli.DEBUG, fmt.Sprintf("\t-> Skipping unsupported Brewfile entry on line %d", lineNumber), line)
+			continue
+		}
+
+		if len(fields) != 2 {
+			return entries, fmt.Errorf("Brewfile line %d: missing name for %s", lineNumber, fields[0])
+		}
+
+		args := splitBrewfileArgs(fields[1])
+		name, ok := unquoteBrewfile(args[0])
+		if !ok {
+			return entries, fmt.Errorf("Brewfile line %d: invalid name %s", lineNumber, args[0])
+		}
+
+		entry := &BrewfileEntry{
+			Type:    brewType,
+			Name:    name,
+			Options: map[string]string{},
+		}
+
+		for _, arg := range args[1:] {
+			if v, ok := unquoteBrewfile(arg); ok {
+				entry.Source = v
+				continue
+			}
+
+			kv := strings.SplitN(arg, ":", 2)
+			if len(kv) != 2 {
+				return entries, fmt.Errorf("Brewfile line %d: invalid option %s", lineNumber, arg)
+			}
+
+			key, value := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+			if v, ok := unquoteBrewfile(value); ok {
+				value = v
+			}
+
+			if key == "id" {
+				entry.ID = value
+				continue
+			}
+			entry.Options[key] = value
+		}
+
+		if entry.Type == BrewTypeMas && len(entry.ID) == 0 {
+			return entries, fmt.Errorf("Brewfile line %d: mas %q requires an id", lineNumber, name)
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, scanner.Err()
+}
+
+// WriteBrewfile writes the entries to w in Brewfile format
+func WriteBrewfile(w io.Writer, entries []*BrewfileEntry) error {
+	for _, entry := range entries {
+		if _, err := fmt.Fprintln(w, entry.String()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// BrewfileToHCL converts Brewfile entries into brew blocks
+func BrewfileToHCL(entries []*BrewfileEntry) []byte {
+	f := hclwrite.NewEmptyFile()
+	for i, entry := range entries {
+		if i > 0 {
+			f.Body().AppendNewline()
+		}
+
+		block := f.Body().AppendNewBlock("brew", []string{entry.Name})
+		block.Body().SetAttributeValue("action", cty.StringVal("install"))
+		if entry.Type != BrewTypeFormula {
+			block.Body().SetAttributeValue("type", cty.StringVal(entry.Type))
+		}
+		if len(entry.ID) > 0 {
+			block.Body().SetAttributeValue("id", cty.StringVal(entry.ID))
+		}
+		if len(entry.Source) > 0 {
+			block.Body().SetAttributeValue("source", cty.StringVal(entry.Source))
+		}
+	}
+
+	return f.Bytes()
+}
+
+// stripBrewfileComment removes a trailing ruby comment which is not quoted
+func stripBrewfileComment(line string) string {
+	var quote rune
+	for i, c := range line {
+		switch {
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		case quote == 0 && c == '#':
+			return line[:i]
+		}
+	}
+
+	return line
+}
+
+// splitBrewfileArgs splits the arguments on commas which are not quoted or
+// nested within an array or hash
+func splitBrewfileArgs(s string) []string {
+	var args []string
+	var quote rune
+	var depth, start int
+	for i, c := range s {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[' || c == '{':
+			depth++
+		case c == ']' || c == '}':
+			depth--
+		case c == ',' && depth == 0:
+			args = append(args, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+
+	return append(args, strings.TrimSpace(s[start:]))
+}
+
+// brewfileList returns the strings of a ruby array, such as ["HEAD"]
+func brewfileList(s string) ([]string, error) {
+	if !strings.HasPrefix(s, "[") || !strings.HasSuffix(s, "]") {
+		return nil, fmt.Errorf("not an array")
+	}
+	if len(strings.TrimSpace(s[1:len(s)-1])) == 0 {
+		return nil, nil
+	}
+
+	var list []string
+	for _, arg := range splitBrewfileArgs(s[1 : len(s)-1]) {
+		v, ok := unquoteBrewfile(arg)
+		if !ok {
+			return nil, fmt.Errorf("%s is not a string", arg)
+		}
+		list = append(list, v)
+	}
+
+	return list, nil
+}
+
+// brewfileHash returns the keys and values of a ruby hash, such as
+// { appdir: "~/Applications" }, in order, with the underscores of keys
+// written as dashes like brew's own options
+func brewfileHash(s string) ([][2]string, error) {
+	if !strings.HasPrefix(s, "{") || !strings.HasSuffix(s, "}") {
+		return nil, fmt.Errorf("not a hash")
+	}
+	if len(strings.TrimSpace(s[1:len(s)-1])) == 0 {
+		return nil, nil
+	}
+
+	var hash [][2]string
+	for _, arg := range splitBrewfileArgs(s[1 : len(s)-1]) {
+		kv := strings.SplitN(arg, ":", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid option %s", arg)
+		}
+
+		key, value := strings.Replace(strings.TrimSpace(kv[0]), "_", "-", -1), strings.TrimSpace(kv[1])
+		if v, ok := unquoteBrewfile(value); ok {
+			value = v
+		}
+		hash = append(hash, [2]string{key, value})
+	}
+
+	return hash, nil
+}
+
+// unquoteBrewfile returns the contents of a quoted ruby string
+func unquoteBrewfile(s string) (string, bool) {
+	if len(s) < 2 {
+		return s, false
+	}
+
+	if (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1], true
+	}
+
+	return s, false
+}
@@ -0,0 +1,183 @@
+package pantry
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/hcl2/hcl"
+	"github.com/hashicorp/hcl2/hclparse"
+	"github.com/mikemackintosh/bakery/cli"
+)
+
+var testBrewfile = `# Taps
+tap "homebrew/cask"
+tap "user/repo", "https://github.com/user/homebrew-repo"
+cask_args appdir: "/Applications"
+
+brew "git" # version control
+brew "mysql@5.6", restart_service: true, link: true, conflicts_with: ["mysql"]
+cask "firefox", args: { appdir: "~/Applications" }
+mas "Xcode", id: 497799835
+`
+
+var testParseBrewfile = []*BrewfileEntry{
+	{Type: BrewTypeTap, Name: "homebrew/cask", Options: map[string]string{}},
+	{Type: BrewTypeTap, Name: "user/repo", Source: "https://github.com/user/homebrew-repo", Options: map[string]string{}},
+	{Type: BrewTypeFormula, Name: "git", Options: map[string]string{}},
+	{Type: BrewTypeFormula, Name: "mysql@5.6", Options: map[string]string{
+		"restart_service": "true",
+		"link":            "true",
+		"conflicts_with":  `["mysql"]`,
+	}},
+	{Type: BrewTypeCask, Name: "firefox", Options: map[string]string{"args": `{ appdir: "~/Applications" }`}},
+	{Type: BrewTypeMas, Name: "Xcode", ID: "497799835", Options: map[string]string{}},
+}
+
+func TestParseBrewfile(t *testing.T) {
+	entries, err := ParseBrewfile(strings.NewReader(testBrewfile))
+	if err != nil {
+		t.Fatalf("ParseBrewfile failed with %s", err)
+	}
+
+	if len(entries) != len(testParseBrewfile) {
+		t.Fatalf("want %d entries but got %d", len(testParseBrewfile), len(entries))
+	}
+
+	for i, want := range testParseBrewfile {
+		if !reflect.DeepEqual(entries[i], want) {
+			t.Errorf("want %#v but got %#v", want, entries[i])
+		}
+	}
+}
+
+var testParseBrewfileErrors = []string{
+	`brew`,
+	`brew git`,
+	`mas "Xcode"`,
+	`brew "git", restart_service`,
+}
+
+func TestParseBrewfileErrors(t *testing.T) {
+	for _, test := range testParseBrewfileErrors {
+		if _, err := ParseBrewfile(strings.NewReader(test)); err == nil {
+			t.Errorf("want error for %q but got none", test)
+		}
+	}
+}
+
+func TestWriteBrewfile(t *testing.T) {
+	var buf bytes.Buffer
+	err := WriteBrewfile(&buf, testParseBrewfile)
+	if err != nil {
+		t.Fatalf("WriteBrewfile failed with %s", err)
+	}
+
+	entries, err := ParseBrewfile(&buf)
+	if err != nil {
+		t.Fatalf("ParseBrewfile failed with %s", err)
+	}
+
+	for i, want := range testParseBrewfile {
+		if entries[i].Type != want.Type || entries[i].Name != want.Name || entries[i].ID != want.ID || entries[i].Source != want.Source {
+			t.Errorf("want %s but got %s", want, entries[i])
+		}
+	}
+}
+
+func TestBrewfileToHCL(t *testing.T) {
+	src := BrewfileToHCL(testParseBrewfile)
+	file, diags := hclparse.NewParser().ParseHCL(src, "brewfile.yum")
+	if len(diags) != 0 {
+		t.Fatalf("generated invalid HCL: %s\n%s", diags, src)
+	}
+
+	content, diags := file.Body.Content(&hcl.BodySchema{
+		Blocks: []hcl.BlockHeaderSchema{{Type: "brew", LabelNames: []string{"name"}}},
+	})
+	if len(diags) != 0 {
+		t.Fatalf("generated unexpected HCL: %s\n%s", diags, src)
+	}
+
+	for i, block := range content.Blocks {
+		brew := &Brew{PantryItem: PantryItem{Name: block.Labels[0], Config: block.Body}}
+		if err := brew.Parse(nil); err != nil {
+			t.Fatalf("failed to parse brew %s: %s", brew.Name, err)
+		}
+
+		got := brew.BrewfileEntry()
+		want := testParseBrewfile[i]
+		if got.Type != want.Type || got.Name != want.Name || got.ID != want.ID || got.Source != want.Source {
+			t.Errorf("want %s but got %s", want, got)
+		}
+	}
+}
+
+func TestBrewfileBake(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bakery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	previousPrefix, previousBin := brewPrefix, brewBin
+	brewPrefix, brewBin = dir, filepath.Join(dir, "bin", "brew")
+	defer func() { brewPrefix, brewBin = previousPrefix, previousBin }()
+	for _, name := range []string{filepath.Join(dir, "bin"), filepath.Join(dir, "Cellar", "present")} {
+		if err := os.MkdirAll(name, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ioutil.WriteFile(brewBin, nil, 0755)
+
+	source := filepath.Join(dir, "Brewfile")
+	ioutil.WriteFile(source, []byte(`
+brew "git", args: ["HEAD"], restart_service: :changed
+brew "present", restart_service: true
+cask "firefox", args: { appdir: "~/Applications", require_sha: true, no_quarantine: false }
+brew "mysql", link: true
+`), 0644)
+
+	// The user of the block is used rather than the invoking user
+	defer os.Setenv("SUDO_UID", os.Getenv("SUDO_UID"))
+	os.Setenv("SUDO_UID", "501")
+
+	var log bytes.Buffer
+	previousLog := cli.LogOutput
+	cli.LogOutput = &log
+	defer func() { cli.LogOutput = previousLog }()
+
+	runner, restore := useFakeRunner(map[string]fakeBinary{"brew": succeed})
+	defer restore()
+
+	p := &Brewfile{Source: source}
+	p.Name = "team"
+	p.User = &[]string{"root"}[0]
+	p.Bake()
+	if err := p.Failed(); err != nil {
+		t.Fatalf("want no error but got %s", err)
+	}
+
+	want := []string{
+		brewBin + " install git --HEAD",
+		brewBin + " services restart git",
+		brewBin + " services restart present",
+		brewBin + " install --cask firefox --appdir=~/Applications --require-sha",
+		brewBin + " install mysql",
+	}
+	if !reflect.DeepEqual(runner.Ran(), want) {
+		t.Errorf("want %#v but got %#v", want, runner.Ran())
+	}
+	for _, c := range runner.Commands {
+		if c.Credential == nil || c.Credential.Uid != 0 {
+			t.Errorf("want %v to run as root but got %#v", c.Args, c.Credential)
+		}
+	}
+	if !strings.Contains(log.String(), `entry="brew \"mysql\"" options=link`) {
+		t.Errorf("want a warning about the link option but got %q", log.String())
+	}
+}
@@ -0,0 +1,71 @@
+package pantry
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/hcl2/hcl"
+	"github.com/hashicorp/hcl2/hcldec"
+	"github.com/mikemackintosh/bakery/cli"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// DataSource is a data block, which is read before the blocks referencing it
+// to provide them with values, without changing anything on the host
+type DataSource interface {
+	Parse(*hcl.EvalContext) error
+	Read() error
+	OutputInterface
+}
+
+// DataSources create each type of data block from its name and body
+var DataSources = map[string]func(name string, config hcl.Body) DataSource{
+	"exec": func(name string, config hcl.Body) DataSource {
+		return &ExecData{DataItem: DataItem{Name: name, Config: config}}
+	},
+	"file": func(name string, config hcl.Body) DataSource {
+		return &FileData{DataItem: DataItem{Name: name, Config: config}}
+	},
+	"env": func(name string, config hcl.Body) DataSource {
+		return &EnvData{DataItem: DataItem{Name: name, Config: config}}
+	},
+	"http": func(name string, config hcl.Body) DataSource {
+		return &HTTPData{DataItem: DataItem{Name: name, Config: config}}
+	},
+	"yaml": func(name string, config hcl.Body) DataSource {
+		return &DocumentData{DataItem: DataItem{Name: name, Config: config}, format: "yaml"}
+	},
+	"plist": func(name string, config hcl.Body) DataSource {
+		return &DocumentData{DataItem: DataItem{Name: name, Config: config}, format: "plist"}
+	},
+	"ini": func(name string, config hcl.Body) DataSource {
+		return &DocumentData{DataItem: DataItem{Name: name, Config: config}, format: "ini"}
+	},
+}
+
+// DataItem is embedded by each data source
+type DataItem struct {
+	Name   string
+	Config hcl.Body
+
+	DependsOn string `json:"depends_on"`
+}
+
+// NewDataSpec appends the fields common to data sources to a data spec
+func NewDataSpec(spec *hcldec.ObjectSpec) *hcldec.ObjectSpec {
+	(*spec)["depends_on"] = dependsOn
+	return spec
+}
+
+// decode decodes the configuration with the spec into the data source,
+// returning the decoded configuration
+func (d *DataItem) decode(evalContext *hcl.EvalContext, spec hcldec.Spec, obj interface{}) (cty.Value, error) {
+	cfg, diags := hcldec.Decode(d.Config, spec, evalContext)
+	if len(diags) != 0 {
+		for _, diag := range diags {
+			cli.Debug(cli.INFO, "\t#", diag)
+		}
+		return cfg, fmt.Errorf("%s", diags.Errs()[0])
+	}
+
+	return cfg, (&PantryItem{}).Populate(cfg, obj)
+}
@@ -0,0 +1,94 @@
+package pantry
+
+import (
+	"bytes"
+	"io/ioutil"
+
+	"github.com/hashicorp/hcl2/hcl"
+	"github.com/hashicorp/hcl2/hcldec"
+	"github.com/mikemackintosh/bakery/cli"
+	homedir "github.com/mitchellh/go-homedir"
+	"github.com/zclconf/go-cty/cty"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// documentReaders decode each format of document on disk
+var documentReaders = map[string]func(name string) (interface{}, error){
+	"yaml": func(name string) (interface{}, error) {
+		b, err := ioutil.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+
+		var doc interface{}
+		return doc, yaml.Unmarshal(b, &doc)
+	},
+	"plist": ReadPlist,
+	"ini": func(name string) (interface{}, error) {
+		b, err := ioutil.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+
+		return ParseINI(bytes.NewReader(b))
+	},
+}
+
+// DocumentData decodes a YAML, property list or INI file on disk, the
+// format being the type of the data block
+type DocumentData struct {
+	DataItem
+	Path string `json:"path"`
+
+	format string
+	value  *cty.Value
+}
+
+// Identifies the document data spec
+var documentDataSpec = NewDataSpec(&hcldec.ObjectSpec{
+	"path": &hcldec.AttrSpec{
+		Name:     "path",
+		Required: true,
+		Type:     cty.String,
+	},
+})
+
+// Parse the confgiuration with the provided spec
+func (d *DocumentData) Parse(evalContext *hcl.EvalContext) error {
+	cli.Debug(cli.INFO, "Preparing "+d.format, d.Name)
+	_, err := d.decode(evalContext, documentDataSpec, d)
+	return err
+}
+
+// Read decodes the document
+func (d *DocumentData) Read() error {
+	name, err := homedir.Expand(d.Path)
+	if err != nil {
+		return err
+	}
+
+	doc, err := documentReaders[d.format](name)
+	if err != nil {
+		return err
+	}
+
+	value, err := ToValue(doc)
+	if err != nil {
+		return err
+	}
+
+	d.value = &value
+	return nil
+}
+
+// Outputs returns the decoded document as the value
+func (d *DocumentData) Outputs() cty.Value {
+	var value = cty.NullVal(cty.DynamicPseudoType)
+	if d.value != nil {
+		value = *d.value
+	}
+
+	return cty.ObjectVal(map[string]cty.Value{
+		"value": value,
+	})
+}
@@ -0,0 +1,77 @@
+package pantry
+
+import (
+	"os"
+
+	"github.com/hashicorp/hcl2/hcl"
+	"github.com/hashicorp/hcl2/hcldec"
+	"github.com/mikemackintosh/bakery/cli"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// EnvData reads an environment variable
+type EnvData struct {
+	DataItem
+	Variable string  `json:"variable"`
+	Default  *string `json:"default"`
+
+	value *string
+	set   bool
+}
+
+// Identifies the env data spec
+var envDataSpec = NewDataSpec(&hcldec.ObjectSpec{
+	"variable": &hcldec.AttrSpec{
+		Name:     "variable",
+		Required: false,
+		Type:     cty.String,
+	},
+	"default": &hcldec.AttrSpec{
+		Name:     "default",
+		Required: false,
+		Type:     cty.String,
+	},
+})
+
+// Parse the confgiuration with the provided spec
+func (d *EnvData) Parse(evalContext *hcl.EvalContext) error {
+	cli.Debug(cli.INFO, "Preparing env", d.Name)
+	_, err := d.decode(evalContext, envDataSpec, d)
+	return err
+}
+
+// GetVariable returns the name of the environment variable, defaulting to
+// the block name
+func (d *EnvData) GetVariable() string {
+	if len(d.Variable) > 0 {
+		return d.Variable
+	}
+
+	return d.Name
+}
+
+// Read reads the environment variable, using the default when it is not set
+func (d *EnvData) Read() error {
+	value, ok := os.LookupEnv(d.GetVariable())
+	d.set = ok
+	d.value = d.Default
+	if ok {
+		d.value = &value
+	}
+
+	return nil
+}
+
+// Outputs returns the value of the variable, which is null when it is not
+// set and has no default, and whether it was set
+func (d *EnvData) Outputs() cty.Value {
+	var value = cty.NullVal(cty.String)
+	if d.value != nil {
+		value = cty.StringVal(*d.value)
+	}
+
+	return cty.ObjectVal(map[string]cty.Value{
+		"value": value,
+		"set":   cty.BoolVal(d.set),
+	})
+}
@@ -0,0 +1,101 @@
+package pantry
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/hcl2/hcl"
+	"github.com/hashicorp/hcl2/hcldec"
+	"github.com/mikemackintosh/bakery/cli"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// ExecData runs a command, exposing its output to the recipe
+type ExecData struct {
+	DataItem
+	Command StringList        `json:"command"`
+	Env     map[string]string `json:"env"`
+	Cwd     *string           `json:"cwd"`
+	Timeout *string           `json:"timeout"`
+
+	shell    bool
+	response *CommandResponse
+}
+
+// Identifies the exec data spec
+var execDataSpec = NewDataSpec(&hcldec.ObjectSpec{
+	"command": &hcldec.AttrSpec{
+		Name:     "command",
+		Required: true,
+		Type:     cty.DynamicPseudoType,
+	},
+	"env": &hcldec.AttrSpec{
+		Name:     "env",
+		Required: false,
+		Type:     cty.Map(cty.String),
+	},
+	"cwd": &hcldec.AttrSpec{
+		Name:     "cwd",
+		Required: false,
+		Type:     cty.String,
+	},
+	"timeout": &hcldec.AttrSpec{
+		Name:     "timeout",
+		Required: false,
+		Type:     cty.String,
+	},
+})
+
+// Parse the confgiuration with the provided spec
+func (d *ExecData) Parse(evalContext *hcl.EvalContext) error {
+	cli.Debug(cli.INFO, "Preparing exec", d.Name)
+	cfg, err := d.decode(evalContext, execDataSpec, d)
+	if err != nil {
+		return err
+	}
+	d.shell = cfg.GetAttr("command").Type() == cty.String
+
+	if len(d.Command) == 0 {
+		return fmt.Errorf("exec %q has no command", d.Name)
+	}
+
+	if _, err := parseTimeout(d.Timeout); err != nil {
+		return fmt.Errorf("exec %q has an invalid timeout %q", d.Name, *d.Timeout)
+	}
+
+	return nil
+}
+
+// GetCommand returns the command to run, a string being run by the shell
+// and a list being run as is
+func (d *ExecData) GetCommand() []string {
+	if d.shell {
+		return []string{"/bin/sh", "-c", d.Command[0]}
+	}
+
+	return d.Command
+}
+
+// Read runs the command. A command which exits with an error is still read,
+// its exit code being part of the output, but one which could not be run or
+// timed out is not.
+func (d *ExecData) Read() error {
+	timeout, _ := parseTimeout(d.Timeout)
+	var cmd = &Command{Args: d.GetCommand(), Env: envList(d.Env), Timeout: timeout}
+	if d.Cwd != nil {
+		cmd.Dir = *d.Cwd
+	}
+
+	o, err := Runner.Run(cmd)
+	if err != nil && (o == nil || o.ExitCode == 127 || o.ExitCode == -1) {
+		return fmt.Errorf("Error running %s: %s", d.Command[0], err)
+	}
+	cli.Debug(cli.DEBUG, "\t-> Exit code", o.ExitCode)
+
+	d.response = o
+	return nil
+}
+
+// Outputs returns the stdout, stderr and exit code of the command
+func (d *ExecData) Outputs() cty.Value {
+	return commandOutputs(d.response)
+}
@@ -0,0 +1,55 @@
+package pantry
+
+import (
+	"strings"
+	"testing"
+)
+
+var testExecData = []struct {
+	Src      string
+	Ran      string
+	ExitCode int
+	Error    bool
+}{
+	{`command = "git describe --tags"`, "/bin/sh -c git describe --tags", 0, false},
+	{`command = ["git", "describe"]`, "git describe", 0, false},
+	{`command = ["false"]`, "false", 1, false},
+	{`command = ["missing", "arg"]`, "missing arg", 127, true},
+}
+
+func TestExecDataRead(t *testing.T) {
+	for _, test := range testExecData {
+		r, restore := useFakeRunner(map[string]fakeBinary{
+			"sh":  succeed,
+			"git": func(args []string) (string, int) { return "v1.2.3\n", 0 },
+			"false": func(args []string) (string, int) {
+				return "", 1
+			},
+		})
+
+		d := DataSources["exec"]("test", testBody(t, test.Src))
+		if err := d.Parse(nil); err != nil {
+			t.Fatalf("want no error but got %s", err)
+		}
+
+		err := d.Read()
+		restore()
+		if (err != nil) != test.Error {
+			t.Errorf("want error %t but got %v", test.Error, err)
+			continue
+		}
+
+		if got := strings.Join(r.Ran(), "; "); got != test.Ran {
+			t.Errorf("want %s but got %s", test.Ran, got)
+		}
+
+		if test.Error {
+			continue
+		}
+
+		exitCode, _ := d.Outputs().GetAttr("exit_code").AsBigFloat().Int64()
+		if int(exitCode) != test.ExitCode {
+			t.Errorf("want %d but got %d", test.ExitCode, exitCode)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package pantry
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/hashicorp/hcl2/hcl"
+	"github.com/hashicorp/hcl2/hcldec"
+	"github.com/mikemackintosh/bakery/cli"
+	homedir "github.com/mitchellh/go-homedir"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// FileData reads the contents of a local file
+type FileData struct {
+	DataItem
+	Path string `json:"path"`
+
+	content []byte
+}
+
+// Identifies the file data spec
+var fileDataSpec = NewDataSpec(&hcldec.ObjectSpec{
+	"path": &hcldec.AttrSpec{
+		Name:     "path",
+		Required: true,
+		Type:     cty.String,
+	},
+})
+
+// Parse the confgiuration with the provided spec
+func (d *FileData) Parse(evalContext *hcl.EvalContext) error {
+	cli.Debug(cli.INFO, "Preparing file", d.Name)
+	_, err := d.decode(evalContext, fileDataSpec, d)
+	return err
+}
+
+// Read reads the file
+func (d *FileData) Read() error {
+	name, err := homedir.Expand(d.Path)
+	if err != nil {
+		return err
+	}
+
+	d.content, err = ioutil.ReadFile(name)
+	return err
+}
+
+// Outputs returns the content of the file and its sha256 checksum
+func (d *FileData) Outputs() cty.Value {
+	if d.content == nil {
+		return cty.ObjectVal(map[string]cty.Value{
+			"content": cty.NullVal(cty.String),
+			"sha256":  cty.NullVal(cty.String),
+		})
+	}
+
+	return cty.ObjectVal(map[string]cty.Value{
+		"content": cty.StringVal(string(d.content)),
+		"sha256":  cty.StringVal(fmt.Sprintf("%x", sha256.Sum256(d.content))),
+	})
+}
@@ -0,0 +1,125 @@
+package pantry
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/hashicorp/hcl2/hcl"
+	"github.com/hashicorp/hcl2/hcldec"
+	"github.com/mikemackintosh/bakery/cli"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// HTTPData fetches a document over HTTP, decoding it when it is JSON
+type HTTPData struct {
+	DataItem
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+	Timeout *string           `json:"timeout"`
+
+	status int
+	body   []byte
+	json   cty.Value
+}
+
+// Identifies the http data spec
+var httpDataSpec = NewDataSpec(&hcldec.ObjectSpec{
+	"url": &hcldec.AttrSpec{
+		Name:     "url",
+		Required: true,
+		Type:     cty.String,
+	},
+	"headers": &hcldec.AttrSpec{
+		Name:     "headers",
+		Required: false,
+		Type:     cty.Map(cty.String),
+	},
+	"timeout": &hcldec.AttrSpec{
+		Name:     "timeout",
+		Required: false,
+		Type:     cty.String,
+	},
+})
+
+// Parse the confgiuration with the provided spec
+func (d *HTTPData) Parse(evalContext *hcl.EvalContext) error {
+	cli.Debug(cli.INFO, "Preparing http", d.Name)
+	_, err := d.decode(evalContext, httpDataSpec, d)
+	if err != nil {
+		return err
+	}
+
+	if _, err := parseTimeout(d.Timeout); err != nil {
+		return fmt.Errorf("http %q has an invalid timeout %q", d.Name, *d.Timeout)
+	}
+
+	return nil
+}
+
+// Read fetches the document, failing unless the response is successful
+func (d *HTTPData) Read() error {
+	timeout, _ := parseTimeout(d.Timeout)
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+
+	req, err := http.NewRequest(http.MethodGet, d.URL, nil)
+	if err != nil {
+		return err
+	}
+	for k, v := range d.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := (&http.Client{Timeout: timeout}).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	d.status = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Error fetching %s: %s", d.URL, resp.Status)
+	}
+
+	d.body, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	// Documents which are not JSON are still available as the body
+	d.json = cty.NullVal(cty.DynamicPseudoType)
+	var doc interface{}
+	dec := json.NewDecoder(bytes.NewReader(d.body))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err == nil {
+		d.json, err = ToValue(doc)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Outputs returns the status code and body of the response, and the decoded
+// document when it is JSON
+func (d *HTTPData) Outputs() cty.Value {
+	if d.body == nil {
+		return cty.ObjectVal(map[string]cty.Value{
+			"status_code": cty.NullVal(cty.Number),
+			"body":        cty.NullVal(cty.String),
+			"json":        cty.NullVal(cty.DynamicPseudoType),
+		})
+	}
+
+	return cty.ObjectVal(map[string]cty.Value{
+		"status_code": cty.NumberIntVal(int64(d.status)),
+		"body":        cty.StringVal(string(d.body)),
+		"json":        d.json,
+	})
+}
@@ -0,0 +1,194 @@
+package pantry
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/zclconf/go-cty/cty"
+)
+
+// readData parses and reads a data block of the type
+func readData(t *testing.T, dataType, src string) (DataSource, error) {
+	d := DataSources[dataType]("test", testBody(t, src))
+	if err := d.Parse(nil); err != nil {
+		t.Fatalf("want no error but got %s", err)
+	}
+
+	return d, d.Read()
+}
+
+// writeTestFile writes a file into the directory, returning its path
+func writeTestFile(t *testing.T, dir, name, content string) string {
+	name = filepath.Join(dir, name)
+	if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return name
+}
+
+func TestFileData(t *testing.T) {
+	dir, err := ioutil.TempDir("", "data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	name := writeTestFile(t, dir, "version", "1.2.3\n")
+	d, err := readData(t, "file", fmt.Sprintf("path = %q", name))
+	if err != nil {
+		t.Fatalf("want no error but got %s", err)
+	}
+
+	if got := d.Outputs().GetAttr("content").AsString(); got != "1.2.3\n" {
+		t.Errorf("want 1.2.3 but got %s", got)
+	}
+
+	if _, err := readData(t, "file", fmt.Sprintf("path = %q", name+".missing")); err == nil {
+		t.Errorf("want an error for a missing file")
+	}
+}
+
+var testEnvData = []struct {
+	Src   string
+	Value cty.Value
+	Set   bool
+}{
+	{`variable = "BAKERY_TEST_ENV"`, cty.StringVal("set"), true},
+	{`variable = "BAKERY_TEST_UNSET"`, cty.NullVal(cty.String), false},
+	{`variable = "BAKERY_TEST_UNSET"
+	  default = "fallback"`, cty.StringVal("fallback"), false},
+}
+
+func TestEnvData(t *testing.T) {
+	os.Setenv("BAKERY_TEST_ENV", "set")
+	defer os.Unsetenv("BAKERY_TEST_ENV")
+
+	for _, test := range testEnvData {
+		d, err := readData(t, "env", test.Src)
+		if err != nil {
+			t.Fatalf("want no error but got %s", err)
+		}
+
+		out := d.Outputs()
+		if !out.GetAttr("value").RawEquals(test.Value) {
+			t.Errorf("want %#v but got %#v", test.Value, out.GetAttr("value"))
+		}
+		if out.GetAttr("set").True() != test.Set {
+			t.Errorf("want %t but got %t", test.Set, out.GetAttr("set").True())
+		}
+	}
+}
+
+func TestHTTPData(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/release.json":
+			if r.Header.Get("Accept") != "application/json" {
+				w.WriteHeader(http.StatusNotAcceptable)
+				return
+			}
+			fmt.Fprint(w, `{"tag_name": "v1.2.3", "assets": [{"size": 1024}]}`)
+		case "/text":
+			fmt.Fprint(w, "plain")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	d, err := readData(t, "http", fmt.Sprintf(`
+url = "%s/release.json"
+headers = {
+  Accept = "application/json"
+}`, ts.URL))
+	if err != nil {
+		t.Fatalf("want no error but got %s", err)
+	}
+
+	doc := d.Outputs().GetAttr("json")
+	if got := doc.GetAttr("tag_name").AsString(); got != "v1.2.3" {
+		t.Errorf("want v1.2.3 but got %s", got)
+	}
+	if got := doc.GetAttr("assets").Index(cty.NumberIntVal(0)).GetAttr("size"); !got.RawEquals(cty.NumberIntVal(1024)) {
+		t.Errorf("want 1024 but got %#v", got)
+	}
+
+	d, err = readData(t, "http", fmt.Sprintf(`url = "%s/text"`, ts.URL))
+	if err != nil {
+		t.Fatalf("want no error but got %s", err)
+	}
+	if out := d.Outputs(); out.GetAttr("body").AsString() != "plain" || !out.GetAttr("json").IsNull() {
+		t.Errorf("want a plain body without json but got %#v", out)
+	}
+
+	if _, err := readData(t, "http", fmt.Sprintf(`url = "%s/missing"`, ts.URL)); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("want a 404 error but got %v", err)
+	}
+}
+
+var testDocumentData = []struct {
+	Type    string
+	Name    string
+	Content string
+	Path    []string
+	Value   cty.Value
+}{
+	{"yaml", "config.yml", "tools:\n  editor: vim\n  ports: [80, 443]\n", []string{"tools", "editor"}, cty.StringVal("vim")},
+	{"yaml", "config.yml", "tools:\n  editor: vim\n  ports: [80, 443]\n", []string{"tools", "ports", "1"}, cty.NumberIntVal(443)},
+	{"plist", "Info.plist", `<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0">
+<dict>
+	<key>CFBundleShortVersionString</key>
+	<string>4.6.7</string>
+	<key>LSRequiresNativeExecution</key>
+	<true/>
+	<key>Architectures</key>
+	<array>
+		<string>x86_64</string>
+		<integer>64</integer>
+	</array>
+</dict>
+</plist>`, []string{"Architectures", "1"}, cty.NumberIntVal(64)},
+	{"plist", "Info.plist", `<plist><dict><key>LSRequiresNativeExecution</key><true/></dict></plist>`, []string{"LSRequiresNativeExecution"}, cty.True},
+	{"ini", "config.ini", "name = top\n\n[user]\n; comment\nemail = \"mike@example.com\"\n", []string{"user", "email"}, cty.StringVal("mike@example.com")},
+	{"ini", "config.ini", "name = top\n\n[user]\nemail: mike@example.com\n", []string{"name"}, cty.StringVal("top")},
+}
+
+func TestDocumentData(t *testing.T) {
+	dir, err := ioutil.TempDir("", "data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, test := range testDocumentData {
+		name := writeTestFile(t, dir, test.Name, test.Content)
+		d, err := readData(t, test.Type, fmt.Sprintf("path = %q", name))
+		if err != nil {
+			t.Errorf("want no error but got %s", err)
+			continue
+		}
+
+		val := d.Outputs().GetAttr("value")
+		for _, step := range test.Path {
+			if val.Type().IsTupleType() {
+				var i int
+				fmt.Sscan(step, &i)
+				val = val.Index(cty.NumberIntVal(int64(i)))
+				continue
+			}
+			val = val.GetAttr(step)
+		}
+
+		if !val.RawEquals(test.Value) {
+			t.Errorf("want %#v but got %#v", test.Value, val)
+		}
+	}
+}
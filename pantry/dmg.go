@@ -2,8 +2,9 @@ package pantry
 
 import (
 	"fmt"
-	"net/url"
-	"path"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/hashicorp/hcl2/hcl"
@@ -13,10 +14,15 @@ import (
 	"github.com/zclconf/go-cty/cty"
 )
 
+var hdiutilBin = "/usr/bin/hdiutil"
+var dittoBin = "/usr/bin/ditto"
+
 // Dmg is a MacOS DMG object
 type Dmg struct {
 	PantryItem
 	App            *string `json:"app"`
+	Pkg            *string `json:"pkg"`
+	Version        *string `json:"version"`
 	Source         string  `json:"source"`
 	Destination    *string `json:"destination"`
 	Checksum       *string `json:"checksum"`
@@ -37,6 +43,16 @@ var dmgSpec = NewPantrySpec(&hcldec.ObjectSpec{
 		Required: false,
 		Type:     cty.String,
 	},
+	"pkg": &hcldec.AttrSpec{
+		Name:     "pkg",
+		Required: false,
+		Type:     cty.String,
+	},
+	"version": &hcldec.AttrSpec{
+		Name:     "version",
+		Required: false,
+		Type:     cty.String,
+	},
 	"checksum": &hcldec.AttrSpec{
 		Name:     "checksum",
 		Required: true,
@@ -77,6 +93,15 @@ func (p *Dmg) GetDestination() string {
 	return "/Applications/"
 }
 
+// GetAppName returns the name of the app bundle, defaulting to the block name
+func (p *Dmg) GetAppName() string {
+	if p.App != nil {
+		return *p.App
+	}
+
+	return p.Name
+}
+
 // Parse will parse the config with the spec
 func (p *Dmg) Parse(evalContext *hcl.EvalContext) error {
 	cli.Debug(cli.INFO, "Preparing DMG", p.Name)
@@ -98,88 +123,159 @@ func (p *Dmg) Parse(evalContext *hcl.EvalContext) error {
 
 // Bake will perform the DMG installation
 func (p *Dmg) Bake() {
-	// Rsync the app from the mounted DMG to the destination folder
-	var appName = p.Name
-	if p.App != nil {
-		appName = *p.App
+	var installedApp = p.GetDestination() + p.GetAppName() + ".app"
+
+	// When the version is known upfront, we can skip the download entirely
+	if p.Version != nil && !p.Force && p.Pkg == nil {
+		installed, err := ReadPlistString(installedApp+"/Contents/Info.plist", "CFBundleShortVersionString")
+		if err == nil && CompareVersions(installed, *p.Version) >= 0 {
+			cli.Debug(cli.INFO, fmt.Sprintf("\t-> %s %s is already installed", installedApp, installed), nil)
+			p.Unchanged()
+			return
+		}
 	}
-	// Sets the app name with the .app extension
-	var appNameWithExt = appName + ".app"
 
-	if FileExists(p.GetDestination()+appNameWithExt) && !p.Force {
-		cli.Debug(cli.INFO, "\t-> Package already exists", p.GetDestination()+appNameWithExt)
+	dmgFile, err := FetchSource(p.Source, p.Checksum)
+	if err != nil {
+		cli.Debug(cli.ERROR, "\t-> Error fetching source:", err)
+		p.Fail(err)
 		return
 	}
 
-	u, err := url.Parse(p.Source)
+	mountpoint, err := ioutil.TempDir(config.Registry.TempDir, "dmg")
 	if err != nil {
-		cli.Debug(cli.INFO, fmt.Sprintf("Error finding source %s", p.Source), err)
+		cli.Debug(cli.ERROR, "\t-> Error creating mountpoint:", err)
+		p.Fail(err)
+		return
 	}
+	defer os.Remove(mountpoint)
 
-	var tmpFile string
-	if u.Scheme == "http" || u.Scheme == "https" {
-		cli.Debug(cli.DEBUG, "\t-> Using HTTP(s) source for download", nil)
+	err = p.attach(dmgFile, mountpoint)
+	if err != nil {
+		cli.Debug(cli.ERROR, fmt.Sprintf("Error mounting %s to %s", dmgFile, mountpoint), err)
+		p.Fail(fmt.Errorf("Error mounting %s: %s", dmgFile, err))
+		return
+	}
+	defer p.detach(mountpoint)
 
-		urlParse, urlErr := url.Parse(p.Source)
-		if err != nil {
-			cli.Debug(cli.INFO, fmt.Sprintf("Error finding source %s", p.Source), urlErr)
-		}
+	err = p.install(mountpoint)
+	if err != nil {
+		cli.Debug(cli.ERROR, fmt.Sprintf("Error installing %s", dmgFile), err)
+		p.Fail(fmt.Errorf("Error installing %s: %s", dmgFile, err))
+	}
+}
 
-		tmpFile = config.Registry.TempDir + "/" + path.Base(urlParse.Path)
-		urlErr = DownloadFile(p.Source, tmpFile, p.Checksum)
-		if urlErr != nil {
-			cli.Debug(cli.INFO, fmt.Sprintf("Error downloading file %s", p.Source), urlErr)
-		}
+// attach mounts the DMG, accepting the EULA when configured
+func (p *Dmg) attach(dmgFile, mountpoint string) error {
+	var mountCmd = &Command{
+		Args: []string{
+			hdiutilBin,
+			"attach",
+			dmgFile,
+			"-nobrowse",
+			"-noautoopen",
+			"-mountpoint",
+			mountpoint,
+		},
 	}
 
-	// Mount it
-	var mountpoint = fmt.Sprintf("/Volumes/%s", p.Name)
-	var hdiutilBinary = "/usr/bin/hdiutil"
-	var mountCmd = []string{
-		hdiutilBinary,
-		"attach",
-		strings.Replace(tmpFile, " ", "\\ ", -1),
-		"-nobrowse",
-		"-mountpoint",
-		mountpoint}
-
-	cli.Debug(cli.INFO, fmt.Sprintf("Mounting %s", tmpFile), err)
-	cli.Debug(cli.DEBUG2, fmt.Sprintf("\t-> Install command: %#v", strings.Join(mountCmd, " ")), err)
-	r, err := RunCommand(mountCmd)
+	// hdiutil pages the EULA and then waits for agreement, so skip the
+	// pager and agree. Without a reader stdin is empty, which declines it.
+	if p.AcceptEula {
+		mountCmd.Env = []string{"PAGER=cat"}
+		mountCmd.Stdin = strings.NewReader("Y\n")
+	}
+
+	cli.Debug(cli.INFO, fmt.Sprintf("Mounting %s", dmgFile), nil)
+	r, err := Runner.Run(mountCmd)
 	if err != nil {
-		cli.Debug(cli.ERROR, fmt.Sprintf("Error mounting %s to %s", tmpFile, mountpoint), err)
-	}
-	cli.Debug(cli.DEBUG2, fmt.Sprintf("\t-> Mount command response: \n%s", r.FormattedString()), err)
-
-	var installCmd = []string{
-		"sudo",
-		"/usr/bin/rsync",
-		"--force",
-		"--recursive",
-		"--links",
-		"--perms",
-		"--executability",
-		"--owner",
-		"--group",
-		"--times",
-		fmt.Sprintf("%s/%s", mountpoint, appNameWithExt),
-		p.GetDestination()}
-	cli.Debug(cli.INFO, fmt.Sprintf("Installing %s to %s", tmpFile, p.GetDestination()), err)
-	cli.Debug(cli.DEBUG2, fmt.Sprintf("\t-> Install command: %s", strings.Join(installCmd, " ")), err)
-	r, err = RunCommand(installCmd)
+		return fmt.Errorf("%s: %s", err, r.String())
+	}
+	cli.Debug(cli.DEBUG2, fmt.Sprintf("\t-> Mount command response: \n%s", r.FormattedString()), nil)
+
+	return nil
+}
+
+// detach unmounts the DMG
+func (p *Dmg) detach(mountpoint string) {
+	r, err := Runner.Run(&Command{Args: []string{hdiutilBin, "detach", mountpoint, "-force"}})
 	if err != nil {
-		cli.Debug(cli.INFO, fmt.Sprintf("Error installing %s", appName), err)
+		cli.Debug(cli.ERROR, "Error unmounting", r.String())
 	}
-	cli.Debug(cli.DEBUG2, fmt.Sprintf("\t-> Install command response: \n%s", r.FormattedString()), err)
+}
 
-	// unmount the DMG after copying it over
-	var unmountCommand = []string{
-		hdiutilBinary,
-		"unmount",
-		mountpoint,
+// install will copy the app bundle, or install the package, from the
+// mounted DMG
+func (p *Dmg) install(mountpoint string) error {
+	if p.Pkg != nil {
+		return p.installPkg(filepath.Join(mountpoint, *p.Pkg))
 	}
-	_, err = RunCommand(unmountCommand)
-	if err != nil {
-		cli.Debug(cli.INFO, "Error unmounting", err)
+
+	app := filepath.Join(mountpoint, p.GetAppName()+".app")
+	if FileExists(app) {
+		return p.installApp(app)
 	}
+
+	// Fall back to the only app or package on the volume
+	for _, pattern := range []string{"*.app", "*.pkg", "*.mpkg"} {
+		matches, _ := filepath.Glob(filepath.Join(mountpoint, pattern))
+		if len(matches) != 1 {
+			continue
+		}
+
+		if pattern == "*.app" {
+			return p.installApp(matches[0])
+		}
+		return p.installPkg(matches[0])
+	}
+
+	return fmt.Errorf("No %s.app or package found in %s", p.GetAppName(), p.Source)
+}
+
+// installApp copies the app bundle to the destination unless the installed
+// bundle is the same or a newer version
+func (p *Dmg) installApp(app string) error {
+	var destination = p.GetDestination() + p.GetAppName() + ".app"
+
+	if FileExists(destination) && !p.Force {
+		installed, err := ReadPlistString(destination+"/Contents/Info.plist", "CFBundleShortVersionString")
+		if err == nil {
+			available, err := ReadPlistString(app+"/Contents/Info.plist", "CFBundleShortVersionString")
+			if err == nil && CompareVersions(installed, available) >= 0 {
+				cli.Debug(cli.INFO, fmt.Sprintf("\t-> %s %s is already installed", destination, installed), nil)
+				p.Unchanged()
+				return nil
+			}
+		}
+	}
+
+	// ditto merges into an existing bundle, so remove the old version first
+	if FileExists(destination) {
+		if err := os.RemoveAll(destination); err != nil {
+			return err
+		}
+	}
+
+	cli.Debug(cli.INFO, fmt.Sprintf("Installing %s to %s", app, destination), nil)
+	_, err := Runner.Run(&Command{Args: []string{dittoBin, app, destination}, Stream: p.Name})
+	return err
+}
+
+// installPkg installs a package from the DMG unless it is already installed
+func (p *Dmg) installPkg(pkgFile string) error {
+	// Bundle packages are directories, and have no xar to inspect
+	if info, err := os.Stat(pkgFile); err == nil && !info.IsDir() && !p.Force {
+		refs, err := ReadPkgRefs(pkgFile)
+		if err != nil {
+			return err
+		}
+
+		if PkgRefsInstalled(refs, PkgReceipts(refs)) {
+			cli.Debug(cli.INFO, "\t-> Package already installed", pkgFile)
+			p.Unchanged()
+			return nil
+		}
+	}
+
+	return InstallPkg(p.Name, pkgFile, "/", p.AllowUntrusted)
 }
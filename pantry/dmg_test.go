@@ -0,0 +1,223 @@
+package pantry
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const testInfoPlist = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>CFBundleIdentifier</key>
+	<string>com.bakery.test</string>
+	<key>CFBundleDocumentTypes</key>
+	<array>
+		<dict>
+			<key>CFBundleShortVersionString</key>
+			<string>nested</string>
+		</dict>
+	</array>
+	<key>CFBundleShortVersionString</key>
+	<string>%s</string>
+</dict>
+</plist>
+`
+
+// writeTestApp creates an app bundle with an Info.plist at the version
+func writeTestApp(t *testing.T, app, version string) {
+	if err := os.MkdirAll(filepath.Join(app, "Contents"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	err := ioutil.WriteFile(filepath.Join(app, "Contents", "Info.plist"), []byte(fmt.Sprintf(testInfoPlist, version)), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// fakeHdiutil populates the mountpoint when attaching, as if the DMG
+// contained the files created by populate
+func fakeHdiutil(populate func(mountpoint string)) fakeBinary {
+	return func(args []string) (string, int) {
+		if args[0] == "attach" {
+			populate(args[len(args)-1])
+			return "/dev/disk4\tGUID_partition_scheme", 0
+		}
+		if args[0] == "detach" {
+			os.RemoveAll(args[1])
+			os.MkdirAll(args[1], 0755)
+			return "\"disk4\" ejected.", 0
+		}
+		return "", 1
+	}
+}
+
+func TestReadPlistString(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bakery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeTestApp(t, filepath.Join(dir, "Test.app"), "1.2.3")
+	version, err := ReadPlistString(filepath.Join(dir, "Test.app", "Contents", "Info.plist"), "CFBundleShortVersionString")
+	if err != nil || version != "1.2.3" {
+		t.Errorf("want 1.2.3 but got %q, %v", version, err)
+	}
+
+	_, err = ReadPlistString(filepath.Join(dir, "Test.app", "Contents", "Info.plist"), "CFBundleVersion")
+	if err == nil {
+		t.Errorf("want error for missing key but got none")
+	}
+}
+
+var testDmgBake = []struct {
+	Name      string
+	Dmg       Dmg
+	Installed string
+	Populate  func(t *testing.T, mountpoint string)
+	Binaries  map[string]fakeBinary
+	Want      []string
+	WantStdin string
+}{
+	{
+		Name:      "installs a newer app",
+		Dmg:       Dmg{AcceptEula: true},
+		Installed: "1.0",
+		Populate: func(t *testing.T, mountpoint string) {
+			writeTestApp(t, filepath.Join(mountpoint, "Test.app"), "2.0")
+		},
+		Binaries:  map[string]fakeBinary{"ditto": succeed},
+		Want:      []string{"attach", "ditto", "detach"},
+		WantStdin: "Y\n",
+	},
+	{
+		Name:      "skips an installed app",
+		Installed: "2.0",
+		Populate: func(t *testing.T, mountpoint string) {
+			writeTestApp(t, filepath.Join(mountpoint, "Test.app"), "2.0")
+		},
+		Want: []string{"attach", "detach"},
+	},
+	{
+		Name:      "skips before download with version",
+		Dmg:       Dmg{Version: &[]string{"1.5"}[0]},
+		Installed: "2.0",
+		Want:      nil,
+	},
+	{
+		Name:      "installs with force",
+		Dmg:       Dmg{Force: true, App: &[]string{"Other"}[0]},
+		Installed: "2.0",
+		Populate: func(t *testing.T, mountpoint string) {
+			writeTestApp(t, filepath.Join(mountpoint, "Other.app"), "2.0")
+		},
+		Binaries: map[string]fakeBinary{"ditto": succeed},
+		Want:     []string{"attach", "ditto", "detach"},
+	},
+	{
+		Name: "unmounts after a failed copy",
+		Populate: func(t *testing.T, mountpoint string) {
+			writeTestApp(t, filepath.Join(mountpoint, "Test.app"), "2.0")
+		},
+		Binaries: map[string]fakeBinary{"ditto": func(args []string) (string, int) {
+			return "ditto: Cannot copy", 1
+		}},
+		Want: []string{"attach", "ditto", "detach"},
+	},
+	{
+		Name: "unmounts when nothing is found",
+		Populate: func(t *testing.T, mountpoint string) {
+			ioutil.WriteFile(filepath.Join(mountpoint, "README.txt"), []byte("readme"), 0644)
+		},
+		Want: []string{"attach", "detach"},
+	},
+	{
+		Name: "installs a package",
+		Dmg:  Dmg{AllowUntrusted: true},
+		Populate: func(t *testing.T, mountpoint string) {
+			b, err := ioutil.ReadFile("../testing/fixtures/pkg/component.pkg")
+			if err != nil {
+				t.Fatal(err)
+			}
+			ioutil.WriteFile(filepath.Join(mountpoint, "Install Test.pkg"), b, 0644)
+		},
+		Binaries: map[string]fakeBinary{
+			"pkgutil": func(args []string) (string, int) {
+				return "No receipt for '" + args[1] + "' found at '/'.", 1
+			},
+			"installer": succeed,
+		},
+		Want: []string{"attach", "pkgutil", "installer", "detach"},
+	},
+}
+
+func TestDmgBake(t *testing.T) {
+	for _, test := range testDmgBake {
+		dir, err := ioutil.TempDir("", "bakery")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		source := filepath.Join(dir, "Test Image.dmg")
+		ioutil.WriteFile(source, []byte("dmg"), 0644)
+
+		destination := filepath.Join(dir, "Applications")
+		if len(test.Installed) > 0 {
+			writeTestApp(t, filepath.Join(destination, "Test.app"), test.Installed)
+			writeTestApp(t, filepath.Join(destination, "Other.app"), test.Installed)
+		}
+
+		binaries := map[string]fakeBinary{
+			"hdiutil": fakeHdiutil(func(mountpoint string) {
+				test.Populate(t, mountpoint)
+			}),
+		}
+		for k, v := range test.Binaries {
+			binaries[k] = v
+		}
+		runner, restore := useFakeRunner(binaries)
+
+		p := test.Dmg
+		p.Name = "Test"
+		p.Source = source
+		p.Destination = &destination
+		p.Bake()
+		restore()
+
+		var ran []string
+		for _, c := range runner.Commands {
+			name := filepath.Base(c.Args[0])
+			if name == "hdiutil" {
+				name = c.Args[1]
+			}
+			ran = append(ran, name)
+		}
+
+		if !reflect.DeepEqual(ran, test.Want) {
+			t.Errorf("%s: want %#v but got %#v", test.Name, test.Want, runner.Ran())
+		}
+
+		if len(runner.Commands) > 0 {
+			attach := runner.Commands[0]
+			if attach.Args[2] != source {
+				t.Errorf("%s: want source %q to be passed as is but got %q", test.Name, source, attach.Args[2])
+			}
+
+			var stdin []byte
+			if attach.Stdin != nil {
+				stdin, _ = ioutil.ReadAll(attach.Stdin)
+			}
+			if string(stdin) != test.WantStdin {
+				t.Errorf("%s: want stdin %q but got %q", test.Name, test.WantStdin, stdin)
+			}
+		}
+
+		os.RemoveAll(dir)
+	}
+}
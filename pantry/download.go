@@ -10,26 +10,22 @@ import (
 	"strconv"
 
 	"github.com/mikemackintosh/bakery/cli"
+	"github.com/mikemackintosh/bakery/config"
 )
 
 // DownloadFile will download the source file (remote) to the dest (local) path
 func DownloadFile(source, destination string, checksum interface{}) error {
+	var want = checksumString(checksum)
 	if FileExists(destination) {
 		cli.Debug(cli.INFO, fmt.Sprintf("\t-> Destination file %s already exists", destination), nil)
-		if checksum != nil {
-			f, err := os.Open(destination)
+		if len(want) > 0 {
+			fileHash, err := FileChecksum(destination)
 			if err != nil {
 				return err
 			}
-			defer f.Close()
-			hash := sha256.New()
-			if _, err := io.Copy(hash, f); err != nil {
-				return err
-			}
 
-			fileHash := hex.EncodeToString(hash.Sum(nil))
-			if fileHash != checksum.(string) {
-				cli.Debug(cli.INFO, fmt.Sprintf("\t-> File with hash %s detected, but want %s, removing...", fileHash, checksum.(string)), nil)
+			if fileHash != want {
+				cli.Debug(cli.INFO, fmt.Sprintf("\t-> File with hash %s detected, but want %s, removing...", fileHash, want), nil)
 				err := os.RemoveAll(destination)
 				if err != nil {
 					return fmt.Errorf("Error removing invalidated file: %s", err)
@@ -42,9 +38,16 @@ func DownloadFile(source, destination string, checksum interface{}) error {
 	}
 
 	out, err := os.Create(destination)
+	if err != nil {
+		return fmt.Errorf("Error creating %s: %s", destination, err)
+	}
 	defer out.Close()
 
-	// Make GET request
+	// Make GET request, from a mirror of the source when there is one
+	if mirror := config.Registry.Mirror(source); mirror != source {
+		cli.Debug(cli.INFO, "\t-> Downloading from mirror", mirror)
+		source = mirror
+	}
 	resp, err := http.Get(source)
 	if err != nil {
 		return fmt.Errorf("Invalid download request, %s", err)
@@ -74,11 +77,42 @@ func DownloadFile(source, destination string, checksum interface{}) error {
 	counter.Finish()
 
 	fileChecksum := hex.EncodeToString(hash.Sum(nil))
-	if len(checksum.(string)) > 0 {
-		if checksum.(string) != fileChecksum {
-			return fmt.Errorf("Failed to validate file. Want %s but have %s", checksum.(string), fileChecksum)
+	if len(want) > 0 {
+		if want != fileChecksum {
+			return fmt.Errorf("Failed to validate file. Want %s but have %s", want, fileChecksum)
 		}
 	}
 
 	return nil
 }
+
+// FileChecksum returns the hex encoded sha256 checksum of the file
+func FileChecksum(name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// checksumString returns the checksum, which may be provided as a string
+// or an optional string
+func checksumString(checksum interface{}) string {
+	switch c := checksum.(type) {
+	case string:
+		return c
+	case *string:
+		if c != nil {
+			return *c
+		}
+	}
+
+	return ""
+}
@@ -23,14 +23,14 @@ func TestDownloadFile(t *testing.T) {
 	var filename = "/test_download.txt"
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == "GET" && r.URL.Path == filename {
-			b, _ := ioutil.ReadFile("../../testing/fixtures/www/test_download.txt")
+			b, _ := ioutil.ReadFile("../testing/fixtures/www/test_download.txt")
 			_, _ = w.Write(b)
 			return
 		}
 	}))
 
 	for _, test := range testDownloadFile {
-		err := DownloadFile(ts.Listener.Addr().String()+test.Source, test.Destination, test.Checksum)
+		err := DownloadFile(ts.URL+test.Source, test.Destination, test.Checksum)
 		if err != nil {
 			t.Errorf("DownloadFile failed with %s", err)
 		}
@@ -1,6 +1,9 @@
 package pantry
 
-import "os"
+import (
+	"os"
+	"path/filepath"
+)
 
 // FileExists detects if a file exists on the filesystem or not
 func FileExists(name string) bool {
@@ -11,3 +14,28 @@ func FileExists(name string) bool {
 	}
 	return true
 }
+
+// MkdirAllOwned creates the directory and any missing parents, like
+// os.MkdirAll, and gives every directory it created to the uid and gid, so
+// directories made in a user's home are not left owned by root
+func MkdirAllOwned(dir string, perm os.FileMode, uid, gid int) error {
+	var missing []string
+	for d := filepath.Clean(dir); !FileExists(d); d = filepath.Dir(d) {
+		missing = append(missing, d)
+		if d == filepath.Dir(d) {
+			break
+		}
+	}
+
+	if err := os.MkdirAll(dir, perm); err != nil {
+		return err
+	}
+
+	for _, d := range missing {
+		if err := os.Chown(d, uid, gid); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
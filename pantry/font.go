@@ -3,23 +3,47 @@
 package pantry
 
 import (
+	"archive/zip"
+	"crypto/sha256"
 	"fmt"
-	"net/url"
+	"io"
+	"io/ioutil"
+	"os"
 	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
 
 	"github.com/hashicorp/hcl2/hcl"
 	"github.com/hashicorp/hcl2/hcldec"
 	"github.com/mikemackintosh/bakery/cli"
-	"github.com/mikemackintosh/bakery/config"
 	"github.com/zclconf/go-cty/cty"
 )
 
-// Zip is a zip object
+var fcCacheBin = "fc-cache"
+
+// fontOS is the platform used to find the font directories
+var fontOS = runtime.GOOS
+
+// fontExtensions are the font files which are installed from an archive
+var fontExtensions = []string{".ttf", ".otf", ".ttc", ".woff2"}
+
+// fontDirectories are the system and per-user font directories for each
+// platform, the per-user directories being relative to the user's home
+var fontDirectories = map[string][2]string{
+	"darwin": {"/Library/Fonts", "Library/Fonts"},
+	"linux":  {"/usr/local/share/fonts", ".local/share/fonts"},
+}
+
+// Font is a font object
 type Font struct {
 	PantryItem
 	Source      string  `json:"source"`
 	Checksum    *string `json:"checksum"`
-	Destination string  `json:"destination"`
+	Destination *string `json:"destination"`
+	Glob        *string `json:"glob"`
+	Action      *string `json:"action"`
 }
 
 // Identifies the font spec
@@ -34,8 +58,29 @@ var fontSpec = NewPantrySpec(&hcldec.ObjectSpec{
 		Required: false,
 		Type:     cty.String,
 	},
+	"destination": &hcldec.AttrSpec{
+		Name:     "destination",
+		Required: false,
+		Type:     cty.String,
+	},
+	"glob": &hcldec.AttrSpec{
+		Name:     "glob",
+		Required: false,
+		Type:     cty.String,
+	},
+	"action": &hcldec.AttrSpec{
+		Name:     "action",
+		Required: false,
+		Type:     cty.String,
+	},
 })
 
+// fontFile is a font within the source
+type fontFile struct {
+	Name string
+	Open func() (io.ReadCloser, error)
+}
+
 // Parse the confgiuration with the provided spec
 func (p *Font) Parse(evalContext *hcl.EvalContext) error {
 	cli.Debug(cli.INFO, "Preparing font", p.Name)
@@ -52,34 +97,225 @@ func (p *Font) Parse(evalContext *hcl.EvalContext) error {
 		return err
 	}
 
+	switch p.GetAction() {
+	case "install", "remove":
+	default:
+		return fmt.Errorf("font %q has an invalid action %q", p.Name, p.GetAction())
+	}
+
+	if p.Glob != nil {
+		if _, err := path.Match(*p.Glob, ""); err != nil {
+			return fmt.Errorf("font %q has an invalid glob %q", p.Name, *p.Glob)
+		}
+	}
+
 	return nil
 }
 
+// GetAction returns the font action, defaulting to install
+func (p *Font) GetAction() string {
+	if p.Action != nil {
+		return *p.Action
+	}
+
+	return "install"
+}
+
+// GetDestination returns the configured destination, or the font directory
+// for the platform, which is in the user's home when a user is set
+func (p *Font) GetDestination() (string, error) {
+	if p.Destination != nil {
+		return *p.Destination, nil
+	}
+
+	dirs, ok := fontDirectories[fontOS]
+	if !ok {
+		return "", fmt.Errorf("no font directory for %s, set one with destination", fontOS)
+	}
+
+	if p.User == nil {
+		return dirs[0], nil
+	}
+
+	home, err := GetUserHome(*p.User)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(home, dirs[1]), nil
+}
+
 // Bake will action the configuration
 func (p *Font) Bake() {
-	u, err := url.Parse(p.Source)
+	source, err := FetchSource(p.Source, p.Checksum)
+	if err != nil {
+		cli.Debug(cli.ERROR, "\t-> Error fetching source:", err)
+		p.Fail(err)
+		return
+	}
+
+	destination, err := p.GetDestination()
 	if err != nil {
-		cli.Debug(cli.ERROR, fmt.Sprintf("Error finding source %s", p.Source), err)
+		cli.Debug(cli.ERROR, "\t-> Error finding font directory:", err)
+		p.Fail(err)
+		return
+	}
+
+	fonts, closer, err := p.fonts(source)
+	if err != nil {
+		cli.Debug(cli.ERROR, "\t-> Error reading fonts:", err)
+		p.Fail(err)
+		return
+	}
+	defer closer.Close()
+
+	var changed bool
+	for _, font := range fonts {
+		var target = filepath.Join(destination, font.Name)
+
+		if p.GetAction() == "remove" {
+			if !FileExists(target) {
+				continue
+			}
+
+			cli.Debug(cli.INFO, "\t-> Removing", target)
+			if err := os.Remove(target); err != nil {
+				cli.Debug(cli.ERROR, "\t-> Error removing font:", err)
+				p.Fail(err)
+				continue
+			}
+			changed = true
+			continue
+		}
+
+		installed, err := p.installFont(font, target)
+		if err != nil {
+			cli.Debug(cli.ERROR, fmt.Sprintf("\t-> Error installing %s:", font.Name), err)
+			p.Fail(fmt.Errorf("Error installing %s: %s", font.Name, err))
+			continue
+		}
+		changed = changed || installed
 	}
 
-	var tmpFile string
-	if u.Scheme == ProtocolHTTP || u.Scheme == ProtocolHTTPS {
-		cli.Debug(cli.DEBUG, "\t-> Using HTTP(s) source for download", nil)
+	if !changed {
+		p.Unchanged()
+		return
+	}
+
+	if fontOS == "linux" {
+		// The cache of a per-user font directory belongs to the user
+		var c = &Command{Args: []string{fcCacheBin, "-f", destination}}
+		if p.User != nil {
+			uid, gid, err := GetUIDAndGID(*p.User)
+			if err != nil {
+				p.Fail(err)
+				return
+			}
+			c.Credential = &syscall.Credential{Uid: uid, Gid: gid}
+		}
 
-		urlParse, urlErr := url.Parse(p.Source)
+		o, err := Runner.Run(c)
 		if err != nil {
-			cli.Debug(cli.INFO, fmt.Sprintf("Error finding source %s", p.Source), urlErr)
+			cli.Debug(cli.ERROR, "\t-> Error refreshing font cache:", o.String())
+			p.Fail(fmt.Errorf("Error refreshing font cache: %s", err))
+		}
+	}
+}
+
+// installFont writes the font to the target, unless a font with identical
+// content is already installed
+func (p *Font) installFont(font fontFile, target string) (bool, error) {
+	rc, err := font.Open()
+	if err != nil {
+		return false, err
+	}
+	defer rc.Close()
+
+	b, err := ioutil.ReadAll(rc)
+	if err != nil {
+		return false, err
+	}
+
+	if FileExists(target) {
+		if existing, err := FileChecksum(target); err == nil && existing == fmt.Sprintf("%x", sha256.Sum256(b)) {
+			cli.Debug(cli.DEBUG, "\t-> Font already installed", target)
+			return false, nil
 		}
+	}
 
-		tmpFile = config.Registry.TempDir + "/" + path.Base(urlParse.Path)
-		urlErr = DownloadFile(p.Source, tmpFile, p.Checksum)
-		if urlErr != nil {
-			cli.Debug(cli.INFO, fmt.Sprintf("Error downloading file %s", p.Source), urlErr)
+	if p.User == nil {
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return false, err
 		}
+
+		cli.Debug(cli.INFO, "\t-> Installing", target)
+		return true, ioutil.WriteFile(target, b, 0644)
+	}
+
+	// A per-user install gives the font, and any directories made for it,
+	// to the user
+	uid, gid, err := GetUIDAndGID(*p.User)
+	if err != nil {
+		return false, err
+	}
+	if err := MkdirAllOwned(filepath.Dir(target), 0755, int(uid), int(gid)); err != nil {
+		return false, err
 	}
 
-	_, err = Unzip(tmpFile, "/Library/Fonts/")
+	cli.Debug(cli.INFO, "\t-> Installing", target)
+	if err := ioutil.WriteFile(target, b, 0644); err != nil {
+		return false, err
+	}
+
+	return true, os.Chown(target, int(uid), int(gid))
+}
+
+// fonts returns the font files in the source, which is either an archive or
+// a single font
+func (p *Font) fonts(source string) ([]fontFile, io.Closer, error) {
+	if p.isFont(filepath.Base(source)) {
+		return []fontFile{{
+			Name: filepath.Base(source),
+			Open: func() (io.ReadCloser, error) {
+				return os.Open(source)
+			},
+		}}, ioutil.NopCloser(nil), nil
+	}
+
+	r, err := zip.OpenReader(source)
 	if err != nil {
-		cli.Debug(cli.ERROR, "\t-> Error unzipping file:", err)
+		return nil, nil, err
+	}
+
+	var fonts []fontFile
+	for _, f := range r.File {
+		name := path.Base(f.Name)
+		// Skip the resource forks from archives created on macOS
+		if strings.HasPrefix(f.Name, "__MACOSX/") || strings.HasPrefix(name, "._") {
+			continue
+		}
+
+		if f.FileInfo().IsDir() || !p.isFont(name) {
+			continue
+		}
+
+		fonts = append(fonts, fontFile{Name: name, Open: f.Open})
 	}
+
+	return fonts, r, nil
+}
+
+// isFont returns true for font files which match the glob, when set
+func (p *Font) isFont(name string) bool {
+	var ext = strings.ToLower(path.Ext(name))
+	var matched bool
+	for _, e := range fontExtensions {
+		matched = matched || ext == e
+	}
+
+	if matched && p.Glob != nil {
+		matched, _ = path.Match(*p.Glob, name)
+	}
+
+	return matched
 }
@@ -0,0 +1,201 @@
+package pantry
+
+import (
+	"archive/zip"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"syscall"
+	"testing"
+)
+
+// writeTestFontArchive creates a font archive with the named files
+func writeTestFontArchive(t *testing.T, name string, files map[string]string) {
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	w := zip.NewWriter(f)
+	for name, content := range files {
+		fw, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write([]byte(content))
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// installedFonts lists the files in the font directory
+func installedFonts(dir string) []string {
+	var names []string
+	files, _ := ioutil.ReadDir(dir)
+	for _, f := range files {
+		names = append(names, f.Name())
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+var testFontArchive = map[string]string{
+	"ubuntu-font-family/Ubuntu-R.ttf":            "regular",
+	"ubuntu-font-family/Ubuntu-B.TTF":            "bold",
+	"ubuntu-font-family/UbuntuMono-R.otf":        "mono",
+	"ubuntu-font-family/Ubuntu.woff2":            "web",
+	"ubuntu-font-family/README.txt":              "readme",
+	"ubuntu-font-family/LICENCE.txt":             "licence",
+	"__MACOSX/ubuntu-font-family/._Ubuntu-R.ttf": "fork",
+}
+
+func TestFontBake(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bakery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	source := filepath.Join(dir, "ubuntu.zip")
+	writeTestFontArchive(t, source, testFontArchive)
+
+	previousOS := fontOS
+	fontOS = "linux"
+	defer func() { fontOS = previousOS }()
+
+	destination := filepath.Join(dir, "fonts")
+	var tests = []struct {
+		Name      string
+		Font      Font
+		WantFonts []string
+		WantCache bool
+	}{
+		{
+			Name:      "installs only fonts",
+			WantFonts: []string{"Ubuntu-B.TTF", "Ubuntu-R.ttf", "Ubuntu.woff2", "UbuntuMono-R.otf"},
+			WantCache: true,
+		},
+		{
+			Name:      "skips identical fonts",
+			WantFonts: []string{"Ubuntu-B.TTF", "Ubuntu-R.ttf", "Ubuntu.woff2", "UbuntuMono-R.otf"},
+		},
+		{
+			Name:      "removes matching fonts",
+			Font:      Font{Action: &[]string{"remove"}[0], Glob: &[]string{"UbuntuMono-*"}[0]},
+			WantFonts: []string{"Ubuntu-B.TTF", "Ubuntu-R.ttf", "Ubuntu.woff2"},
+			WantCache: true,
+		},
+		{
+			Name:      "installs matching fonts",
+			Font:      Font{Glob: &[]string{"*Mono*"}[0]},
+			WantFonts: []string{"Ubuntu-B.TTF", "Ubuntu-R.ttf", "Ubuntu.woff2", "UbuntuMono-R.otf"},
+			WantCache: true,
+		},
+	}
+
+	for _, test := range tests {
+		runner, restore := useFakeRunner(map[string]fakeBinary{"fc-cache": succeed})
+
+		p := test.Font
+		p.Name = "ubuntu"
+		p.Source = source
+		p.Destination = &destination
+		p.Bake()
+		restore()
+
+		if got := installedFonts(destination); !reflect.DeepEqual(got, test.WantFonts) {
+			t.Errorf("%s: want %#v but got %#v", test.Name, test.WantFonts, got)
+		}
+
+		var wantRan []string
+		if test.WantCache {
+			wantRan = []string{"fc-cache -f " + destination}
+		}
+		if !reflect.DeepEqual(runner.Ran(), wantRan) {
+			t.Errorf("%s: want %#v but got %#v", test.Name, wantRan, runner.Ran())
+		}
+	}
+}
+
+func TestFontGetDestination(t *testing.T) {
+	previousOS := fontOS
+	defer func() { fontOS = previousOS }()
+
+	var tests = []struct {
+		OS   string
+		Want string
+	}{
+		{"darwin", "/Library/Fonts"},
+		{"linux", "/usr/local/share/fonts"},
+		{"windows", ""},
+	}
+
+	for _, test := range tests {
+		fontOS = test.OS
+		p := &Font{}
+		got, err := p.GetDestination()
+		if got != test.Want || (len(test.Want) == 0) != (err != nil) {
+			t.Errorf("want %q for %s but got %q, %v", test.Want, test.OS, got, err)
+		}
+	}
+}
+
+func TestFontBakeUser(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bakery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	source := filepath.Join(dir, "Ubuntu-R.ttf")
+	if err := ioutil.WriteFile(source, []byte("regular"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	previousOS := fontOS
+	fontOS = "linux"
+	defer func() { fontOS = previousOS }()
+
+	// The fonts are given to another user when running as root
+	var uid, gid = os.Getuid(), os.Getgid()
+	if uid == 0 {
+		uid, gid = 1, 1
+	}
+	defer os.Setenv("SUDO_UID", os.Getenv("SUDO_UID"))
+	defer os.Setenv("SUDO_GID", os.Getenv("SUDO_GID"))
+	os.Setenv("SUDO_UID", fmt.Sprint(uid))
+	os.Setenv("SUDO_GID", fmt.Sprint(gid))
+
+	runner, restore := useFakeRunner(map[string]fakeBinary{"fc-cache": succeed})
+	defer restore()
+
+	var destination = filepath.Join(dir, ".local", "share", "fonts")
+	p := &Font{Source: source, Destination: &destination}
+	p.Name = "ubuntu"
+	p.User = &[]string{"self"}[0]
+	p.Bake()
+	if err := p.Failed(); err != nil {
+		t.Fatalf("want no error but got %s", err)
+	}
+
+	for _, name := range []string{filepath.Join(dir, ".local"), filepath.Join(dir, ".local", "share"), destination, filepath.Join(destination, "Ubuntu-R.ttf")} {
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if st := info.Sys().(*syscall.Stat_t); int(st.Uid) != uid || int(st.Gid) != gid {
+			t.Errorf("want %s to be owned by %d:%d but got %d:%d", name, uid, gid, st.Uid, st.Gid)
+		}
+	}
+
+	if len(runner.Commands) != 1 || runner.Commands[0].Credential == nil || runner.Commands[0].Credential.Uid != uint32(uid) {
+		t.Errorf("want the font cache to be refreshed as the user but got %#v", runner.Commands)
+	}
+}
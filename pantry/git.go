@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"path"
 	"strings"
+	"syscall"
 
 	"github.com/hashicorp/hcl2/hcl"
 	"github.com/hashicorp/hcl2/hcldec"
@@ -84,11 +85,14 @@ func (p *Git) Bake() {
 	destination, err := homedir.Expand(destination)
 	if err != nil {
 		cli.Debug(cli.ERROR, "\t-> Error cloning: ", err)
+		p.Fail(err)
+		return
 	}
 
 	// If the target directory already exists, we can't install the repo
 	if FileExists(destination) {
 		cli.Debug(cli.INFO, "\t-> Directory already exists", nil)
+		p.Unchanged()
 		return
 	}
 
@@ -112,27 +116,22 @@ func (p *Git) Bake() {
 	if p.Recursive {
 		gitCmd = append(gitCmd, "--recursive")
 	}
-	var o *CommandResponse
+	var cmd = &Command{Args: gitCmd, Stream: p.Name}
 	if p.User != nil {
-
 		uid, gid, err := GetUIDAndGID(*p.User)
 		if err != nil {
 			cli.Debug(cli.ERROR, fmt.Sprintf("Error getting user data, %s", err), err)
+			p.Fail(err)
+			return
 		}
+		cmd.Credential = &syscall.Credential{Uid: uid, Gid: gid}
+	}
 
-		o, err = RunCommandAsUser(gitCmd, uid, gid)
-		if err != nil {
-			cli.Debug(cli.ERROR, fmt.Sprintf("Error running %s", err), nil)
-		}
-
-	} else {
-
-		o, err = RunCommand(gitCmd)
-		if err != nil {
-			cli.Debug(cli.ERROR, fmt.Sprintf("Error running %s", err), nil)
-		}
+	_, err = Runner.Run(cmd)
+	if err != nil {
+		cli.Debug(cli.ERROR, fmt.Sprintf("Error running %s", err), nil)
+		p.Fail(err)
 	}
-	fmt.Printf("%+v", o)
 	/*
 		// Set the default options
 		var options = &git.CloneOptions{
@@ -0,0 +1,62 @@
+package pantry
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ParseINI parses an INI file into a map of its sections, each a map of its
+// keys and values. Keys before the first section are kept at the top level.
+func ParseINI(r io.Reader) (map[string]interface{}, error) {
+	var doc = map[string]interface{}{}
+	var section = doc
+
+	s := bufio.NewScanner(r)
+	var n int
+	for s.Scan() {
+		n++
+		line := strings.TrimSpace(s.Text())
+		if len(line) == 0 || strings.HasPrefix(line, ";") || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") {
+				return nil, fmt.Errorf("line %d: unterminated section %s", n, line)
+			}
+
+			name := strings.TrimSpace(line[1 : len(line)-1])
+			if existing, ok := doc[name].(map[string]interface{}); ok {
+				section = existing
+				continue
+			}
+			if _, ok := doc[name]; ok {
+				return nil, fmt.Errorf("line %d: section %s is also a key", n, name)
+			}
+
+			section = map[string]interface{}{}
+			doc[name] = section
+			continue
+		}
+
+		i := strings.IndexAny(line, "=:")
+		if i < 0 {
+			return nil, fmt.Errorf("line %d: expected key = value but got %s", n, line)
+		}
+
+		key := strings.TrimSpace(line[:i])
+		value := strings.TrimSpace(line[i+1:])
+		if len(value) > 1 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+
+		if _, ok := section[key].(map[string]interface{}); ok {
+			return nil, fmt.Errorf("line %d: key %s is also a section", n, key)
+		}
+		section[key] = value
+	}
+
+	return doc, s.Err()
+}
@@ -0,0 +1,441 @@
+package pantry
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"syscall"
+
+	"github.com/hashicorp/hcl2/hcl"
+	"github.com/hashicorp/hcl2/hcldec"
+	"github.com/mikemackintosh/bakery/cli"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// LangPackage is a package installed with a language package manager
+type LangPackage struct {
+	PantryItem
+	Packages StringList `json:"name"`
+	Version  *string    `json:"version"`
+	Path     *string    `json:"path"`
+	Binary   *string    `json:"binary"`
+}
+
+// PipPackage is a python package installed with pip
+type PipPackage struct {
+	LangPackage
+}
+
+// NpmPackage is a node package installed globally with npm
+type NpmPackage struct {
+	LangPackage
+}
+
+// GemPackage is a ruby gem
+type GemPackage struct {
+	LangPackage
+}
+
+// GoInstall is a go binary installed with go install
+type GoInstall struct {
+	LangPackage
+}
+
+// CargoInstall is a rust binary installed with cargo install
+type CargoInstall struct {
+	LangPackage
+}
+
+// Identifies the language package spec
+var langPackageSpec = NewPantrySpec(&hcldec.ObjectSpec{
+	"name": &hcldec.AttrSpec{
+		Name:     "name",
+		Required: false,
+		Type:     cty.DynamicPseudoType,
+	},
+	"version": &hcldec.AttrSpec{
+		Name:     "version",
+		Required: false,
+		Type:     cty.String,
+	},
+	"path": &hcldec.AttrSpec{
+		Name:     "path",
+		Required: false,
+		Type:     cty.String,
+	},
+})
+
+// Identifies the go install spec, which can also name the installed binary
+var goInstallSpec = NewPantrySpec(&hcldec.ObjectSpec{
+	"name": &hcldec.AttrSpec{
+		Name:     "name",
+		Required: false,
+		Type:     cty.DynamicPseudoType,
+	},
+	"version": &hcldec.AttrSpec{
+		Name:     "version",
+		Required: false,
+		Type:     cty.String,
+	},
+	"path": &hcldec.AttrSpec{
+		Name:     "path",
+		Required: false,
+		Type:     cty.String,
+	},
+	"binary": &hcldec.AttrSpec{
+		Name:     "binary",
+		Required: false,
+		Type:     cty.String,
+	},
+})
+
+// langManager describes how to query and install packages with a language
+// package manager
+type langManager struct {
+	Name    string
+	Binary  []string
+	Query   func(p *LangPackage, bin []string, pkg string) (string, bool)
+	Install func(p *LangPackage, bin []string, pkg string) []string
+}
+
+// Parse the confgiuration with the provided spec
+func (p *PipPackage) Parse(evalContext *hcl.EvalContext) error {
+	return p.parse(evalContext, pipManager.Name, langPackageSpec)
+}
+
+// Bake will install the packages with pip
+func (p *PipPackage) Bake() {
+	p.bake(pipManager)
+}
+
+// Parse the confgiuration with the provided spec
+func (p *NpmPackage) Parse(evalContext *hcl.EvalContext) error {
+	return p.parse(evalContext, npmManager.Name, langPackageSpec)
+}
+
+// Bake will install the packages with npm
+func (p *NpmPackage) Bake() {
+	p.bake(npmManager)
+}
+
+// Parse the confgiuration with the provided spec
+func (p *GemPackage) Parse(evalContext *hcl.EvalContext) error {
+	return p.parse(evalContext, gemManager.Name, langPackageSpec)
+}
+
+// Bake will install the gems
+func (p *GemPackage) Bake() {
+	p.bake(gemManager)
+}
+
+// Parse the confgiuration with the provided spec
+func (p *GoInstall) Parse(evalContext *hcl.EvalContext) error {
+	return p.parse(evalContext, goManager.Name, goInstallSpec)
+}
+
+// Bake will install the binaries with go install
+func (p *GoInstall) Bake() {
+	p.bake(goManager)
+}
+
+// Parse the confgiuration with the provided spec
+func (p *CargoInstall) Parse(evalContext *hcl.EvalContext) error {
+	return p.parse(evalContext, cargoManager.Name, langPackageSpec)
+}
+
+// Bake will install the binaries with cargo install
+func (p *CargoInstall) Bake() {
+	p.bake(cargoManager)
+}
+
+// parse decodes the configuration with the provided spec
+func (p *LangPackage) parse(evalContext *hcl.EvalContext, kind string, spec *hcldec.ObjectSpec) error {
+	cli.Debug(cli.INFO, "Preparing "+kind, p.Name)
+	cfg, diags := hcldec.Decode(p.Config, spec, evalContext)
+	if len(diags) != 0 {
+		for _, diag := range diags {
+			cli.Debug(cli.INFO, "\t#", diag)
+		}
+		return fmt.Errorf("%s", diags.Errs()[0])
+	}
+
+	return p.Populate(cfg, p)
+}
+
+// GetPackages returns the packages to install, defaulting to the block name
+func (p *LangPackage) GetPackages() []string {
+	if len(p.Packages) > 0 {
+		return p.Packages
+	}
+
+	return []string{p.Name}
+}
+
+// bake will install each of the packages which are not already installed
+// at the wanted version
+func (p *LangPackage) bake(m *langManager) {
+	bin := m.Binary
+	if p.Path != nil {
+		bin = append([]string{*p.Path}, bin[1:]...)
+	}
+	// Limit the capacity so each command appended to bin is a copy
+	bin = bin[:len(bin):len(bin)]
+
+	var installing bool
+	for _, pkg := range p.GetPackages() {
+		version, installed := m.Query(p, bin, pkg)
+		if installed && (p.Version == nil || versionMatches(version, *p.Version)) {
+			cli.Debug(cli.INFO, fmt.Sprintf("\t-> %s %s is already installed", pkg, version), nil)
+			continue
+		}
+
+		installing = true
+		cmd := m.Install(p, bin, pkg)
+		cli.Debug(cli.INFO, fmt.Sprintf("\t-> Running %s", strings.Join(cmd, " ")), nil)
+		_, err := p.stream(cmd)
+		if err != nil {
+			cli.Debug(cli.ERROR, fmt.Sprintf("\t-> Error installing %s", pkg), err)
+			p.Fail(fmt.Errorf("Error installing %s: %s", pkg, err))
+			continue
+		}
+	}
+
+	if !installing {
+		p.Unchanged()
+	}
+}
+
+// run will run the command, as the configured user when set
+func (p *LangPackage) run(cmd []string) (*CommandResponse, error) {
+	return p.command(&Command{Args: cmd})
+}
+
+// stream will run the command like run, showing its output as it runs
+func (p *LangPackage) stream(cmd []string) (*CommandResponse, error) {
+	return p.command(&Command{Args: cmd, Stream: p.Name})
+}
+
+func (p *LangPackage) command(c *Command) (*CommandResponse, error) {
+	if p.User != nil {
+		uid, gid, err := GetUIDAndGID(*p.User)
+		if err != nil {
+			return &CommandResponse{}, fmt.Errorf("Error getting user data, %s", err)
+		}
+
+		home, err := GetUserHome(*p.User)
+		if err != nil {
+			return &CommandResponse{}, fmt.Errorf("Error getting user home, %s", err)
+		}
+
+		c.Credential = &syscall.Credential{Uid: uid, Gid: gid}
+		c.Env = []string{"HOME=" + home}
+	}
+
+	return Runner.Run(c)
+}
+
+// versionMatches compares versions, ignoring any v prefix
+func versionMatches(installed, want string) bool {
+	if want == "latest" {
+		return len(installed) > 0
+	}
+
+	return strings.TrimPrefix(installed, "v") == strings.TrimPrefix(want, "v")
+}
+
+var pipManager = &langManager{
+	Name:   "pip_package",
+	Binary: []string{"python3", "-m", "pip"},
+	Query: func(p *LangPackage, bin []string, pkg string) (string, bool) {
+		o, err := p.run(append(bin, "show", pkg))
+		if err != nil {
+			return "", false
+		}
+
+		for _, line := range o.ByLine() {
+			if strings.HasPrefix(line, "Version:") {
+				return strings.TrimSpace(strings.TrimPrefix(line, "Version:")), true
+			}
+		}
+		return "", false
+	},
+	Install: func(p *LangPackage, bin []string, pkg string) []string {
+		cmd := append(bin, "install", "--disable-pip-version-check")
+		if p.User != nil {
+			cmd = append(cmd, "--user")
+		}
+		if p.Version != nil {
+			pkg = pkg + "==" + *p.Version
+		}
+		return append(cmd, pkg)
+	},
+}
+
+var npmManager = &langManager{
+	Name:   "npm_package",
+	Binary: []string{"npm"},
+	Query: func(p *LangPackage, bin []string, pkg string) (string, bool) {
+		// npm exits non-zero when the package is missing, but still
+		// returns the json document
+		o, _ := p.run(append(append(bin, "ls", "--global", "--depth=0", "--json"), append(npmPrefix(p), pkg)...))
+
+		var ls struct {
+			Dependencies map[string]struct {
+				Version string `json:"version"`
+			} `json:"dependencies"`
+		}
+		if err := json.Unmarshal([]byte(o.String()), &ls); err != nil {
+			return "", false
+		}
+
+		dep, ok := ls.Dependencies[pkg]
+		return dep.Version, ok
+	},
+	Install: func(p *LangPackage, bin []string, pkg string) []string {
+		if p.Version != nil {
+			pkg = pkg + "@" + *p.Version
+		}
+		return append(append(bin, "install", "--global"), append(npmPrefix(p), pkg)...)
+	},
+}
+
+// npmPrefix installs global packages in the user's home when a user is set
+func npmPrefix(p *LangPackage) []string {
+	if p.User == nil {
+		return nil
+	}
+
+	home, err := GetUserHome(*p.User)
+	if err != nil {
+		return nil
+	}
+
+	return []string{"--prefix", home + "/.local"}
+}
+
+// gemListPattern matches the output of gem list, eg: rake (13.0.1, 12.3.3)
+var gemListPattern = regexp.MustCompile(`^(\S+) \((.*)\)$`)
+
+var gemManager = &langManager{
+	Name:   "gem_package",
+	Binary: []string{"gem"},
+	Query: func(p *LangPackage, bin []string, pkg string) (string, bool) {
+		o, err := p.run(append(bin, "list", "--local", "--exact", pkg))
+		if err != nil {
+			return "", false
+		}
+
+		for _, line := range o.ByLine() {
+			match := gemListPattern.FindStringSubmatch(line)
+			if match == nil || match[1] != pkg {
+				continue
+			}
+
+			versions := strings.Split(match[2], ", ")
+			for _, v := range versions {
+				v = strings.TrimPrefix(v, "default: ")
+				if p.Version != nil && versionMatches(v, *p.Version) {
+					return v, true
+				}
+			}
+			return strings.TrimPrefix(versions[0], "default: "), true
+		}
+		return "", false
+	},
+	Install: func(p *LangPackage, bin []string, pkg string) []string {
+		cmd := append(bin, "install", pkg, "--no-document")
+		if p.Version != nil {
+			cmd = append(cmd, "--version", *p.Version)
+		}
+		if p.User != nil {
+			cmd = append(cmd, "--user-install")
+		}
+		return cmd
+	},
+}
+
+// goMajorVersion matches the major version suffix of a module path
+var goMajorVersion = regexp.MustCompile(`^v[0-9]+$`)
+
+var goManager = &langManager{
+	Name:   "go_install",
+	Binary: []string{"go"},
+	Query: func(p *LangPackage, bin []string, pkg string) (string, bool) {
+		o, err := p.run(append(bin, "env", "-json", "GOBIN", "GOPATH"))
+		if err != nil {
+			return "", false
+		}
+
+		var env struct {
+			GOBIN  string
+			GOPATH string
+		}
+		if err := json.Unmarshal([]byte(o.String()), &env); err != nil {
+			return "", false
+		}
+
+		binDir := env.GOBIN
+		if len(binDir) == 0 {
+			binDir = path.Join(strings.Split(env.GOPATH, ":")[0], "bin")
+		}
+
+		binary := path.Base(pkg)
+		if goMajorVersion.MatchString(binary) {
+			binary = path.Base(path.Dir(pkg))
+		}
+		if p.Binary != nil {
+			binary = *p.Binary
+		}
+
+		o, err = p.run(append(bin, "version", "-m", path.Join(binDir, binary)))
+		if err != nil {
+			return "", false
+		}
+
+		for _, line := range o.ByLine() {
+			fields := strings.Fields(line)
+			if len(fields) >= 3 && fields[0] == "mod" {
+				return fields[2], true
+			}
+		}
+		return "", false
+	},
+	Install: func(p *LangPackage, bin []string, pkg string) []string {
+		version := "latest"
+		if p.Version != nil {
+			version = *p.Version
+		}
+		return append(bin, "install", pkg+"@"+version)
+	},
+}
+
+// cargoListPattern matches the output of cargo install --list, eg: ripgrep v13.0.0:
+var cargoListPattern = regexp.MustCompile(`^(\S+) v(\S+?)(?: \(.*\))?:$`)
+
+var cargoManager = &langManager{
+	Name:   "cargo_install",
+	Binary: []string{"cargo"},
+	Query: func(p *LangPackage, bin []string, pkg string) (string, bool) {
+		o, err := p.run(append(bin, "install", "--list"))
+		if err != nil {
+			return "", false
+		}
+
+		for _, line := range o.ByLine() {
+			match := cargoListPattern.FindStringSubmatch(line)
+			if match != nil && match[1] == pkg {
+				return match[2], true
+			}
+		}
+		return "", false
+	},
+	Install: func(p *LangPackage, bin []string, pkg string) []string {
+		cmd := append(bin, "install", pkg)
+		if p.Version != nil {
+			cmd = append(cmd, "--version", *p.Version)
+		}
+		return cmd
+	},
+}
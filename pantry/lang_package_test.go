@@ -0,0 +1,210 @@
+package pantry
+
+import (
+	"fmt"
+	"os/user"
+	"reflect"
+	"testing"
+)
+
+var testLangPackageBake = []struct {
+	Item     PantryInterface
+	Config   string
+	Binaries map[string]fakeBinary
+	Want     []string
+}{
+	{
+		Item:   &PipPackage{},
+		Config: `name = ["black", "httpie"]`,
+		Binaries: map[string]fakeBinary{
+			"python3": func(args []string) (string, int) {
+				if args[2] == "show" && args[3] == "black" {
+					return "Name: black\nVersion: 21.5b1", 0
+				}
+				if args[2] == "show" {
+					return "WARNING: Package(s) not found: " + args[3], 1
+				}
+				return "", 0
+			},
+		},
+		Want: []string{
+			"python3 -m pip show black",
+			"python3 -m pip show httpie",
+			"python3 -m pip install --disable-pip-version-check httpie",
+		},
+	},
+	{
+		Item: &PipPackage{},
+		Config: `
+name = "black"
+version = "21.6b0"
+path = "/usr/local/bin/python3.9"
+`,
+		Binaries: map[string]fakeBinary{
+			"python3.9": func(args []string) (string, int) {
+				if args[2] == "show" {
+					return "Name: black\nVersion: 21.5b1", 0
+				}
+				return "", 0
+			},
+		},
+		Want: []string{
+			"/usr/local/bin/python3.9 -m pip show black",
+			"/usr/local/bin/python3.9 -m pip install --disable-pip-version-check black==21.6b0",
+		},
+	},
+	{
+		Item: &NpmPackage{},
+		Config: `
+name = ["typescript", "prettier"]
+version = "4.3.2"
+`,
+		Binaries: map[string]fakeBinary{
+			"npm": func(args []string) (string, int) {
+				if args[0] == "ls" && args[len(args)-1] == "typescript" {
+					return `{"dependencies": {"typescript": {"version": "4.3.2"}}}`, 0
+				}
+				if args[0] == "ls" {
+					return `{}`, 1
+				}
+				return "", 0
+			},
+		},
+		Want: []string{
+			"npm ls --global --depth=0 --json typescript",
+			"npm ls --global --depth=0 --json prettier",
+			"npm install --global prettier@4.3.2",
+		},
+	},
+	{
+		Item:   &GemPackage{},
+		Config: `version = "13.0.1"`,
+		Binaries: map[string]fakeBinary{
+			"gem": func(args []string) (string, int) {
+				if args[0] == "list" {
+					return "test (13.0.3, default: 13.0.1)", 0
+				}
+				return "", 0
+			},
+		},
+		Want: []string{
+			"gem list --local --exact test",
+		},
+	},
+	{
+		Item: &GoInstall{},
+		Config: `
+name = "golang.org/x/tools/gopls"
+version = "v0.7.0"
+`,
+		Binaries: map[string]fakeBinary{
+			"go": func(args []string) (string, int) {
+				switch args[0] {
+				case "env":
+					return `{"GOBIN": "", "GOPATH": "/home/test/go"}`, 0
+				case "version":
+					if args[2] != "/home/test/go/bin/gopls" {
+						return "not found", 1
+					}
+					return "/home/test/go/bin/gopls: go1.16\n\tpath\tgolang.org/x/tools/gopls\n\tmod\tgolang.org/x/tools/gopls\tv0.6.11\th1:abc", 0
+				}
+				return "", 0
+			},
+		},
+		Want: []string{
+			"go env -json GOBIN GOPATH",
+			"go version -m /home/test/go/bin/gopls",
+			"go install golang.org/x/tools/gopls@v0.7.0",
+		},
+	},
+	{
+		Item:   &CargoInstall{},
+		Config: `name = ["ripgrep", "fd-find"]`,
+		Binaries: map[string]fakeBinary{
+			"cargo": func(args []string) (string, int) {
+				if args[0] == "install" && args[1] == "--list" {
+					return "ripgrep v13.0.0:\n    rg\nbat v0.18.1 (/src/bat):\n    bat", 0
+				}
+				return "", 0
+			},
+		},
+		Want: []string{
+			"cargo install --list",
+			"cargo install --list",
+			"cargo install fd-find",
+		},
+	},
+}
+
+func TestLangPackageBake(t *testing.T) {
+	for _, test := range testLangPackageBake {
+		runner, restore := useFakeRunner(test.Binaries)
+
+		lang := langPackageOf(test.Item)
+		lang.Name = "test"
+		lang.Config = testBody(t, test.Config)
+		if err := test.Item.Parse(nil); err != nil {
+			restore()
+			t.Fatalf("failed to parse %T: %s", test.Item, err)
+		}
+		test.Item.Bake()
+		restore()
+
+		if !reflect.DeepEqual(runner.Ran(), test.Want) {
+			t.Errorf("want %#v but got %#v", test.Want, runner.Ran())
+		}
+	}
+}
+
+func TestLangPackageUser(t *testing.T) {
+	current, err := user.Current()
+	if err != nil {
+		t.Skipf("unable to find current user: %s", err)
+	}
+
+	runner, restore := useFakeRunner(map[string]fakeBinary{
+		"python3": func(args []string) (string, int) {
+			return "", 1
+		},
+	})
+	defer restore()
+
+	p := &PipPackage{}
+	p.Name = "black"
+	p.User = &current.Username
+	p.Bake()
+
+	want := []string{
+		"python3 -m pip show black",
+		"python3 -m pip install --disable-pip-version-check --user black",
+	}
+	if !reflect.DeepEqual(runner.Ran(), want) {
+		t.Fatalf("want %#v but got %#v", want, runner.Ran())
+	}
+
+	for _, c := range runner.Commands {
+		if c.Credential == nil || fmt.Sprint(c.Credential.Uid) != current.Uid {
+			t.Errorf("want command to run as %s but got %#v", current.Username, c)
+		}
+		if !reflect.DeepEqual(c.Env, []string{"HOME=" + current.HomeDir}) {
+			t.Errorf("want HOME=%s but got %#v", current.HomeDir, c.Env)
+		}
+	}
+}
+
+// langPackageOf returns the embedded language package
+func langPackageOf(item PantryInterface) *LangPackage {
+	switch p := item.(type) {
+	case *PipPackage:
+		return &p.LangPackage
+	case *NpmPackage:
+		return &p.LangPackage
+	case *GemPackage:
+		return &p.LangPackage
+	case *GoInstall:
+		return &p.LangPackage
+	case *CargoInstall:
+		return &p.LangPackage
+	}
+	return nil
+}
@@ -0,0 +1,327 @@
+package pantry
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/hcl2/hcl"
+	"github.com/hashicorp/hcl2/hcldec"
+	"github.com/mikemackintosh/bakery/cli"
+	"github.com/mikemackintosh/bakery/facts"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// Package actions
+const (
+	PackageInstall = "install"
+	PackageUpgrade = "upgrade"
+	PackageRemove  = "remove"
+	PackagePurge   = "purge"
+)
+
+// Package is a system package object, managed by the platform's package
+// manager
+type Package struct {
+	PantryItem
+	Packages StringList `json:"name"`
+	Version  *string    `json:"version"`
+	Action   *string    `json:"action"`
+	Provider *string    `json:"provider"`
+}
+
+// Identifies the package spec
+var packageSpec = NewPantrySpec(&hcldec.ObjectSpec{
+	"name": &hcldec.AttrSpec{
+		Name:     "name",
+		Required: false,
+		Type:     cty.DynamicPseudoType,
+	},
+	"version": &hcldec.AttrSpec{
+		Name:     "version",
+		Required: false,
+		Type:     cty.String,
+	},
+	"action": &hcldec.AttrSpec{
+		Name:     "action",
+		Required: false,
+		Type:     cty.String,
+	},
+	"provider": &hcldec.AttrSpec{
+		Name:     "provider",
+		Required: false,
+		Type:     cty.String,
+	},
+})
+
+// packageProvider describes how to query and action packages with a
+// package manager
+type packageProvider struct {
+	Query   func(name string) (string, bool)
+	Install []string
+	Upgrade []string
+	Remove  []string
+	Purge   []string
+	Pin     string
+	Env     []string
+}
+
+// packageProviders are the supported package managers
+var packageProviders = map[string]*packageProvider{
+	"apt": {
+		Query:   dpkgQuery,
+		Install: []string{"apt-get", "install", "-y", "-q"},
+		Upgrade: []string{"apt-get", "install", "-y", "-q", "--only-upgrade"},
+		Remove:  []string{"apt-get", "remove", "-y", "-q"},
+		Purge:   []string{"apt-get", "purge", "-y", "-q"},
+		Pin:     "%s=%s",
+		Env:     []string{"DEBIAN_FRONTEND=noninteractive"},
+	},
+	"dnf": {
+		Query:   rpmQuery,
+		Install: []string{"dnf", "install", "-y"},
+		Upgrade: []string{"dnf", "upgrade", "-y"},
+		Remove:  []string{"dnf", "remove", "-y"},
+		Purge:   []string{"dnf", "remove", "-y"},
+		Pin:     "%s-%s",
+	},
+	"yum": {
+		Query:   rpmQuery,
+		Install: []string{"yum", "install", "-y"},
+		Upgrade: []string{"yum", "update", "-y"},
+		Remove:  []string{"yum", "remove", "-y"},
+		Purge:   []string{"yum", "remove", "-y"},
+		Pin:     "%s-%s",
+	},
+	"zypper": {
+		Query:   rpmQuery,
+		Install: []string{"zypper", "--non-interactive", "install"},
+		Upgrade: []string{"zypper", "--non-interactive", "update"},
+		Remove:  []string{"zypper", "--non-interactive", "remove"},
+		Purge:   []string{"zypper", "--non-interactive", "remove", "--clean-deps"},
+		Pin:     "%s=%s",
+	},
+	"apk": {
+		Query:   apkQuery,
+		Install: []string{"apk", "add"},
+		Upgrade: []string{"apk", "upgrade"},
+		Remove:  []string{"apk", "del"},
+		Purge:   []string{"apk", "del", "--purge"},
+		Pin:     "%s=%s",
+	},
+}
+
+// Parse the confgiuration with the provided spec
+func (p *Package) Parse(evalContext *hcl.EvalContext) error {
+	cli.Debug(cli.INFO, "Preparing package", p.Name)
+	cfg, diags := hcldec.Decode(p.Config, packageSpec, evalContext)
+	if len(diags) != 0 {
+		for _, diag := range diags {
+			cli.Debug(cli.INFO, "\t#", diag)
+		}
+		return fmt.Errorf("%s", diags.Errs()[0])
+	}
+
+	err := p.Populate(cfg, p)
+	if err != nil {
+		return err
+	}
+
+	switch p.GetAction() {
+	case PackageInstall, PackageUpgrade, PackageRemove, PackagePurge:
+	default:
+		return fmt.Errorf("package %q has an invalid action %q", p.Name, p.GetAction())
+	}
+
+	if p.Provider != nil {
+		if _, ok := packageProviders[*p.Provider]; !ok {
+			return fmt.Errorf("package %q has an unknown provider %q", p.Name, *p.Provider)
+		}
+	}
+
+	return nil
+}
+
+// GetAction returns the package action, defaulting to install
+func (p *Package) GetAction() string {
+	if p.Action != nil {
+		return *p.Action
+	}
+
+	return PackageInstall
+}
+
+// GetPackages returns the packages to action, defaulting to the block name
+func (p *Package) GetPackages() []string {
+	if len(p.Packages) > 0 {
+		return p.Packages
+	}
+
+	return []string{p.Name}
+}
+
+// GetProvider returns the configured provider, or the provider for the host
+func (p *Package) GetProvider(f *facts.Facts) (string, error) {
+	if p.Provider != nil {
+		return *p.Provider, nil
+	}
+
+	return PackageProviderFor(f)
+}
+
+// PackageProviderFor returns the package provider for the host's platform
+func PackageProviderFor(f *facts.Facts) (string, error) {
+	switch f.PlatformFamily {
+	case facts.FamilyDebian:
+		return "apt", nil
+	case facts.FamilyFedora:
+		return "dnf", nil
+	case facts.FamilyRHEL:
+		major, _ := strconv.Atoi(strings.SplitN(f.PlatformVersion, ".", 2)[0])
+		if f.Platform == "amzn" || major >= 8 {
+			return "dnf", nil
+		}
+		return "yum", nil
+	case facts.FamilySUSE:
+		return "zypper", nil
+	case facts.FamilyAlpine:
+		return "apk", nil
+	}
+
+	return "", fmt.Errorf("no package provider for platform %q, set one with provider", f.Platform)
+}
+
+// versionSatisfies returns true when the installed version is the pinned
+// version or a release of it, such as 1.6-2.el7 or 1.6.1 for a pin of 1.6
+// but not 1.60. The epoch of the installed version is ignored unless the pin
+// has one.
+func versionSatisfies(installed, pinned string) bool {
+	if i := strings.Index(installed, ":"); i >= 0 && !strings.Contains(pinned, ":") {
+		installed = installed[i+1:]
+	}
+	if !strings.HasPrefix(installed, pinned) {
+		return false
+	}
+
+	rest := installed[len(pinned):]
+	return rest == "" || strings.ContainsAny(rest[:1], ".-+~_")
+}
+
+// Bake will query the installed state of each package and batch the
+// packages which need actioning into a single command
+func (p *Package) Bake() {
+	name, err := p.GetProvider(facts.Get())
+	if err != nil {
+		cli.Debug(cli.ERROR, "\t-> Error finding package provider", err)
+		p.Fail(err)
+		return
+	}
+	provider := packageProviders[name]
+
+	var install, upgrade, remove []string
+	for _, pkg := range p.GetPackages() {
+		version, installed := provider.Query(pkg)
+
+		target := pkg
+		if p.Version != nil {
+			target = fmt.Sprintf(provider.Pin, pkg, *p.Version)
+		}
+
+		switch p.GetAction() {
+		case PackageInstall:
+			if installed && (p.Version == nil || versionSatisfies(version, *p.Version)) {
+				cli.Debug(cli.INFO, fmt.Sprintf("\t-> %s %s is already installed", pkg, version), nil)
+				continue
+			}
+			install = append(install, target)
+		case PackageUpgrade:
+			if !installed {
+				install = append(install, target)
+				continue
+			}
+			upgrade = append(upgrade, target)
+		case PackageRemove, PackagePurge:
+			if !installed {
+				cli.Debug(cli.INFO, fmt.Sprintf("\t-> %s is not installed", pkg), nil)
+				continue
+			}
+			remove = append(remove, pkg)
+		}
+	}
+
+	if len(install)+len(upgrade)+len(remove) == 0 {
+		p.Unchanged()
+		return
+	}
+
+	removeCmd := provider.Remove
+	if p.GetAction() == PackagePurge {
+		removeCmd = provider.Purge
+	}
+
+	for _, batch := range []struct {
+		cmd  []string
+		pkgs []string
+	}{
+		{provider.Install, install},
+		{provider.Upgrade, upgrade},
+		{removeCmd, remove},
+	} {
+		if len(batch.pkgs) == 0 {
+			continue
+		}
+
+		cmd := append(append([]string{}, batch.cmd...), batch.pkgs...)
+		cli.Debug(cli.INFO, fmt.Sprintf("\t-> Running %s", strings.Join(cmd, " ")), nil)
+		_, err := Runner.Run(&Command{Args: cmd, Env: provider.Env, Stream: p.Name})
+		if err != nil {
+			cli.Debug(cli.ERROR, fmt.Sprintf("\t-> Error running %s", cmd[0]), err)
+			p.Fail(fmt.Errorf("Error running %s: %s", strings.Join(cmd, " "), err))
+			return
+		}
+	}
+}
+
+// dpkgQuery returns the installed version of a debian package
+func dpkgQuery(name string) (string, bool) {
+	o, err := RunCommand([]string{"dpkg-query", "-W", "-f=${Status} ${Version}", name})
+	if err != nil || !strings.HasPrefix(o.String(), "install ok installed") {
+		return "", false
+	}
+
+	return strings.TrimSpace(strings.TrimPrefix(o.String(), "install ok installed")), true
+}
+
+// rpmQuery returns the installed version of an rpm package
+func rpmQuery(name string) (string, bool) {
+	o, err := RunCommand([]string{"rpm", "-q", "--qf", "%{VERSION}-%{RELEASE}", name})
+	if err != nil || o.ExitCode != 0 {
+		return "", false
+	}
+
+	return o.String(), true
+}
+
+// apkQuery returns the installed version of an alpine package
+func apkQuery(name string) (string, bool) {
+	o, err := RunCommand([]string{"apk", "list", "--installed", name})
+	if err != nil {
+		return "", false
+	}
+
+	for _, line := range o.ByLine() {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || !strings.HasPrefix(fields[0], name+"-") {
+			continue
+		}
+
+		// Package names can contain dashes, so ensure the remainder is
+		// only the version and release
+		version := strings.TrimPrefix(fields[0], name+"-")
+		if len(version) > 0 && version[0] >= '0' && version[0] <= '9' {
+			return version, true
+		}
+	}
+
+	return "", false
+}
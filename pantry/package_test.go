@@ -0,0 +1,234 @@
+package pantry
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/hcl2/hcl"
+	"github.com/hashicorp/hcl2/hclparse"
+	"github.com/mikemackintosh/bakery/facts"
+)
+
+// testBody parses the HCL source into a block body
+func testBody(t *testing.T, src string) hcl.Body {
+	file, diags := hclparse.NewParser().ParseHCL([]byte(src), "test.yum")
+	if len(diags) != 0 {
+		t.Fatalf("invalid test HCL: %s", diags)
+	}
+
+	return file.Body
+}
+
+var testPackageProviderFor = []struct {
+	Facts    facts.Facts
+	Provider string
+}{
+	{facts.Facts{Platform: "ubuntu", PlatformFamily: facts.FamilyDebian}, "apt"},
+	{facts.Facts{Platform: "fedora", PlatformFamily: facts.FamilyFedora}, "dnf"},
+	{facts.Facts{Platform: "centos", PlatformFamily: facts.FamilyRHEL, PlatformVersion: "7"}, "yum"},
+	{facts.Facts{Platform: "rocky", PlatformFamily: facts.FamilyRHEL, PlatformVersion: "8.5"}, "dnf"},
+	{facts.Facts{Platform: "opensuse-leap", PlatformFamily: facts.FamilySUSE}, "zypper"},
+	{facts.Facts{Platform: "alpine", PlatformFamily: facts.FamilyAlpine}, "apk"},
+	{facts.Facts{Platform: "mac_os_x", PlatformFamily: facts.FamilyDarwin}, ""},
+}
+
+func TestPackageProviderFor(t *testing.T) {
+	for _, test := range testPackageProviderFor {
+		provider, err := PackageProviderFor(&test.Facts)
+		if provider != test.Provider {
+			t.Errorf("want %q for %s but got %q", test.Provider, test.Facts.Platform, provider)
+		}
+		if len(test.Provider) == 0 && err == nil {
+			t.Errorf("want error for %s but got none", test.Facts.Platform)
+		}
+	}
+}
+
+// installed returns a fake binary which reports the packages in the
+// installed map, as dpkg-query, rpm or apk would
+func installed(format string, packages map[string]string) fakeBinary {
+	return func(args []string) (string, int) {
+		name := args[len(args)-1]
+		version, ok := packages[name]
+		switch format {
+		case "dpkg":
+			if !ok {
+				return "dpkg-query: no packages found matching " + name, 1
+			}
+			return "install ok installed " + version, 0
+		case "rpm":
+			if !ok {
+				return "package " + name + " is not installed", 1
+			}
+			return version, 0
+		case "apk":
+			if !ok {
+				return "", 0
+			}
+			return name + "-" + version + " x86_64 {" + name + "} (MIT) [installed]", 0
+		}
+		return "", 1
+	}
+}
+
+var testPackageBake = []struct {
+	Config    string
+	Binaries  map[string]fakeBinary
+	Want      []string
+	WantQuery int
+}{
+	{
+		Config: `
+name = ["jq", "curl", "git"]
+provider = "apt"
+`,
+		Binaries: map[string]fakeBinary{
+			"dpkg-query": installed("dpkg", map[string]string{"git": "1:2.25.1-1"}),
+			"apt-get":    succeed,
+		},
+		Want:      []string{"apt-get install -y -q jq curl"},
+		WantQuery: 3,
+	},
+	{
+		Config: `
+name = "jq"
+version = "1.6"
+provider = "dnf"
+`,
+		Binaries: map[string]fakeBinary{
+			"rpm": installed("rpm", map[string]string{"jq": "1.5-12.el8"}),
+			"dnf": succeed,
+		},
+		Want:      []string{"dnf install -y jq-1.6"},
+		WantQuery: 1,
+	},
+	{
+		Config: `
+name = "jq"
+version = "1.6"
+provider = "yum"
+`,
+		Binaries: map[string]fakeBinary{
+			"rpm": installed("rpm", map[string]string{"jq": "1.6-2.el7"}),
+		},
+		WantQuery: 1,
+	},
+	{
+		Config: `
+name = "go"
+version = "1.2"
+provider = "apk"
+`,
+		Binaries: map[string]fakeBinary{
+			"apk": installed("apk", map[string]string{"go": "1.20-r0"}),
+		},
+		Want:      []string{"apk add go=1.2"},
+		WantQuery: 1,
+	},
+	{
+		Config: `
+name = ["jq", "curl"]
+action = "purge"
+provider = "apt"
+`,
+		Binaries: map[string]fakeBinary{
+			"dpkg-query": installed("dpkg", map[string]string{"jq": "1.6-1"}),
+			"apt-get":    succeed,
+		},
+		Want:      []string{"apt-get purge -y -q jq"},
+		WantQuery: 2,
+	},
+	{
+		Config: `
+name = ["jq", "curl"]
+action = "upgrade"
+provider = "apk"
+`,
+		Binaries: map[string]fakeBinary{
+			"apk": installed("apk", map[string]string{"jq": "1.6-r1"}),
+		},
+		Want:      []string{"apk add curl", "apk upgrade jq"},
+		WantQuery: 2,
+	},
+	{
+		Config: `
+action = "remove"
+provider = "zypper"
+`,
+		Binaries: map[string]fakeBinary{
+			"rpm":    installed("rpm", map[string]string{"test": "1.0-1"}),
+			"zypper": succeed,
+		},
+		Want:      []string{"zypper --non-interactive remove test"},
+		WantQuery: 1,
+	},
+}
+
+func TestPackageBake(t *testing.T) {
+	for _, test := range testPackageBake {
+		runner, restore := useFakeRunner(test.Binaries)
+
+		p := &Package{PantryItem: PantryItem{Name: "test", Config: testBody(t, test.Config)}}
+		if err := p.Parse(nil); err != nil {
+			restore()
+			t.Fatalf("failed to parse package: %s", err)
+		}
+		p.Bake()
+		restore()
+
+		ran := runner.Ran()
+		if len(ran) != test.WantQuery+len(test.Want) {
+			t.Errorf("want %d commands but got %#v", test.WantQuery+len(test.Want), ran)
+			continue
+		}
+
+		if len(test.Want) > 0 && !reflect.DeepEqual(ran[test.WantQuery:], test.Want) {
+			t.Errorf("want %#v but got %#v", test.Want, ran[test.WantQuery:])
+		}
+
+		for _, c := range runner.Commands {
+			if strings.HasPrefix(c.Args[0], "apt-get") && !reflect.DeepEqual(c.Env, []string{"DEBIAN_FRONTEND=noninteractive"}) {
+				t.Errorf("want apt-get to run noninteractive but got %#v", c.Env)
+			}
+		}
+	}
+}
+
+var testVersionSatisfies = []struct {
+	Installed string
+	Pinned    string
+	Expected  bool
+}{
+	{"1.6", "1.6", true},
+	{"1.6-2.el7", "1.6", true},
+	{"1.6.1", "1.6", true},
+	{"1:2.25.1-1", "2.25.1", true},
+	{"1:2.25.1-1", "1:2.25", true},
+	{"1.20", "1.2", false},
+	{"10.1", "1", false},
+	{"1.5-12.el8", "1.6", false},
+	{"2:1.6", "1:1.6", false},
+}
+
+func TestVersionSatisfies(t *testing.T) {
+	for _, test := range testVersionSatisfies {
+		if got := versionSatisfies(test.Installed, test.Pinned); got != test.Expected {
+			t.Errorf("want %t for %s pinned to %s but got %t", test.Expected, test.Installed, test.Pinned, got)
+		}
+	}
+}
+
+var testPackageParseErrors = []string{
+	`action = "reinstall"`,
+	`provider = "pacman"`,
+}
+
+func TestPackageParseErrors(t *testing.T) {
+	for _, test := range testPackageParseErrors {
+		p := &Package{PantryItem: PantryItem{Name: "test", Config: testBody(t, test)}}
+		if err := p.Parse(nil); err == nil {
+			t.Errorf("want error for %q but got none", test)
+		}
+	}
+}
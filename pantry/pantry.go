@@ -27,6 +27,12 @@ type PantryInterface interface {
 	ValidateOnlyIf() bool
 }
 
+// OutputInterface is implemented by pantry items with values which can be
+// referenced by other blocks in the recipe
+type OutputInterface interface {
+	Outputs() cty.Value
+}
+
 var dependsOn = &hcldec.AttrSpec{
 	Name:     "depends_on",
 	Required: false,
@@ -77,12 +83,41 @@ type PantryItem struct {
 	User      *string `json:"user"`
 	IsPrepped bool
 	IsBaked   bool
+
+	// err stopped the item from being baked, and unchanged is set when
+	// baking found nothing to change
+	err       error
+	unchanged bool
 }
 
 func (p *PantryItem) Baked() {
 	p.IsBaked = true
 }
 
+// Fail records the error which stopped the item from being baked, keeping
+// the first when there are several
+func (p *PantryItem) Fail(err error) {
+	if p.err == nil {
+		p.err = err
+	}
+}
+
+// Failed returns the error which stopped the item from being baked
+func (p *PantryItem) Failed() error {
+	return p.err
+}
+
+// Unchanged records that baking the item found nothing to change, such as a
+// package which is already installed
+func (p *PantryItem) Unchanged() {
+	p.unchanged = true
+}
+
+// Changed returns true unless baking the item found nothing to change
+func (p *PantryItem) Changed() bool {
+	return !p.unchanged
+}
+
 func (p *PantryItem) Ready() bool {
 	return p.IsBaked
 }
@@ -140,7 +175,7 @@ func (p *PantryItem) ValidateOnlyIf() bool {
 	if p.OnlyIf != nil {
 		o, err := RunCommand([]string{"sh", "-c", *p.OnlyIf})
 		if err != nil {
-			cli.Debug(cli.ERROR, fmt.Sprintf("\t-> Error running only_if %s, response: %s", *p.NotIf, o.FormattedString()), err)
+			cli.Debug(cli.ERROR, fmt.Sprintf("\t-> Error running only_if %s, response: %s", *p.OnlyIf, o.FormattedString()), err)
 			return true
 		}
 
@@ -152,3 +187,28 @@ func (p *PantryItem) ValidateOnlyIf() bool {
 
 	return false
 }
+
+// StringList is a list of strings which can be configured as either a
+// single string, or a list of strings
+type StringList []string
+
+// UnmarshalJSON accepts either a string, or a list of strings
+func (s *StringList) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		return nil
+	}
+
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*s = StringList{single}
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(b, &list); err != nil {
+		return err
+	}
+
+	*s = list
+	return nil
+}
@@ -3,7 +3,9 @@
 package pantry
 
 import (
+	"encoding/xml"
 	"fmt"
+	"strings"
 
 	"github.com/hashicorp/hcl2/hcl"
 	"github.com/hashicorp/hcl2/hcldec"
@@ -11,9 +13,17 @@ import (
 	"github.com/zclconf/go-cty/cty"
 )
 
+var installerBin = "/usr/sbin/installer"
+var pkgutilBin = "/usr/sbin/pkgutil"
+
 // Pkg is a pkg object
 type Pkg struct {
 	PantryItem
+	Source         string  `json:"source"`
+	Checksum       *string `json:"checksum"`
+	Target         *string `json:"target"`
+	AllowUntrusted bool    `json:"allow_untrusted"`
+	Force          bool    `json:"force"`
 }
 
 // Identifies the pkg spec
@@ -23,8 +33,34 @@ var pkgSpec = NewPantrySpec(&hcldec.ObjectSpec{
 		Required: true,
 		Type:     cty.String,
 	},
+	"checksum": &hcldec.AttrSpec{
+		Name:     "checksum",
+		Required: false,
+		Type:     cty.String,
+	},
+	"target": &hcldec.AttrSpec{
+		Name:     "target",
+		Required: false,
+		Type:     cty.String,
+	},
+	"allow_untrusted": &hcldec.AttrSpec{
+		Name:     "allow_untrusted",
+		Required: false,
+		Type:     cty.Bool,
+	},
+	"force": &hcldec.AttrSpec{
+		Name:     "force",
+		Required: false,
+		Type:     cty.Bool,
+	},
 })
 
+// PkgRef is a package identifier and version contained in a flat package
+type PkgRef struct {
+	Identifier string
+	Version    string
+}
+
 // Parse the confgiuration with the provided spec
 func (p *Pkg) Parse(evalContext *hcl.EvalContext) error {
 	cli.Debug(cli.INFO, "Preparing pkg", p.Name)
@@ -44,6 +80,165 @@ func (p *Pkg) Parse(evalContext *hcl.EvalContext) error {
 	return nil
 }
 
-// Bake will action the configuration
+// GetTarget returns the install target, defaulting to the boot volume
+func (p *Pkg) GetTarget() string {
+	if p.Target != nil {
+		return *p.Target
+	}
+
+	return "/"
+}
+
+// Bake will install the package unless the same or a newer version of each
+// package it contains is already installed
 func (p *Pkg) Bake() {
+	pkgFile, err := FetchSource(p.Source, p.Checksum)
+	if err != nil {
+		cli.Debug(cli.ERROR, "\t-> Error fetching source:", err)
+		p.Fail(err)
+		return
+	}
+
+	if !p.Force {
+		refs, err := ReadPkgRefs(pkgFile)
+		if err != nil {
+			cli.Debug(cli.ERROR, "\t-> Error reading package:", err)
+			p.Fail(err)
+			return
+		}
+
+		if PkgRefsInstalled(refs, PkgReceipts(refs)) {
+			cli.Debug(cli.INFO, "\t-> Package already installed", pkgFile)
+			p.Unchanged()
+			return
+		}
+	}
+
+	err = InstallPkg(p.Name, pkgFile, p.GetTarget(), p.AllowUntrusted)
+	if err != nil {
+		cli.Debug(cli.ERROR, fmt.Sprintf("\t-> Error installing %s", pkgFile), err)
+		p.Fail(fmt.Errorf("Error installing %s: %s", pkgFile, err))
+	}
+}
+
+// InstallPkg runs the installer for the package file, streaming its output
+// prefixed with the name of the resource
+func InstallPkg(name, pkgFile, target string, allowUntrusted bool) error {
+	var installCmd = []string{installerBin, "-pkg", pkgFile, "-target", target}
+	if allowUntrusted {
+		installCmd = append(installCmd, "-allowUntrusted")
+	}
+
+	cli.Debug(cli.INFO, fmt.Sprintf("Installing %s to %s", pkgFile, target), nil)
+	_, err := Runner.Run(&Command{Args: installCmd, Stream: name})
+	return err
+}
+
+// ReadPkgRefs reads the package identifiers and versions from a flat package
+func ReadPkgRefs(pkgFile string) ([]PkgRef, error) {
+	x, err := OpenXar(pkgFile)
+	if err != nil {
+		return nil, err
+	}
+	defer x.Close()
+
+	return XarPkgRefs(x)
+}
+
+// XarPkgRefs reads the package identifiers and versions from the PackageInfo
+// of each component package, falling back to the Distribution of a product
+// archive when there are none
+func XarPkgRefs(x *Xar) ([]PkgRef, error) {
+	var refs []PkgRef
+	for _, f := range x.Files {
+		if f.Path != "PackageInfo" && !strings.HasSuffix(f.Path, "/PackageInfo") {
+			continue
+		}
+
+		b, err := x.ReadFile(f.Path)
+		if err != nil {
+			return nil, err
+		}
+
+		var info struct {
+			Identifier string `xml:"identifier,attr"`
+			Version    string `xml:"version,attr"`
+		}
+		if err := xml.Unmarshal(b, &info); err != nil {
+			return nil, fmt.Errorf("Error parsing %s: %s", f.Path, err)
+		}
+		refs = append(refs, PkgRef{Identifier: info.Identifier, Version: info.Version})
+	}
+
+	if len(refs) > 0 {
+		return refs, nil
+	}
+
+	b, err := x.ReadFile("Distribution")
+	if err != nil {
+		return nil, fmt.Errorf("No PackageInfo or Distribution found in package")
+	}
+
+	var dist struct {
+		PkgRefs []struct {
+			ID      string `xml:"id,attr"`
+			Version string `xml:"version,attr"`
+		} `xml:"pkg-ref"`
+	}
+	if err := xml.Unmarshal(b, &dist); err != nil {
+		return nil, fmt.Errorf("Error parsing Distribution: %s", err)
+	}
+
+	// A pkg-ref is often listed more than once, with only one carrying the
+	// version
+	var seen = map[string]bool{}
+	for _, ref := range dist.PkgRefs {
+		if len(ref.Version) == 0 || seen[ref.ID] {
+			continue
+		}
+		seen[ref.ID] = true
+		refs = append(refs, PkgRef{Identifier: ref.ID, Version: ref.Version})
+	}
+
+	if len(refs) == 0 {
+		return nil, fmt.Errorf("No package references found in Distribution")
+	}
+
+	return refs, nil
+}
+
+// PkgReceipts returns the installed version of each package from its receipt
+func PkgReceipts(refs []PkgRef) map[string]string {
+	var receipts = map[string]string{}
+	for _, ref := range refs {
+		o, err := Runner.Run(&Command{Args: []string{pkgutilBin, "--pkg-info", ref.Identifier}})
+		if err != nil {
+			continue
+		}
+
+		for _, line := range o.ByLine() {
+			if strings.HasPrefix(line, "version:") {
+				receipts[ref.Identifier] = strings.TrimSpace(strings.TrimPrefix(line, "version:"))
+			}
+		}
+	}
+
+	return receipts
+}
+
+// PkgRefsInstalled returns true when each package has a receipt with the same
+// or a newer version
+func PkgRefsInstalled(refs []PkgRef, receipts map[string]string) bool {
+	if len(refs) == 0 {
+		return false
+	}
+
+	for _, ref := range refs {
+		installed, ok := receipts[ref.Identifier]
+		if !ok || CompareVersions(installed, ref.Version) < 0 {
+			return false
+		}
+	}
+
+	return true
 }
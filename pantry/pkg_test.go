@@ -0,0 +1,156 @@
+package pantry
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+var testXarPkgRefs = []struct {
+	Fixture string
+	Want    []PkgRef
+}{
+	{
+		Fixture: "../testing/fixtures/pkg/component.pkg",
+		Want:    []PkgRef{{"com.bakery.test.component", "1.2.3"}},
+	},
+	{
+		Fixture: "../testing/fixtures/pkg/product.pkg",
+		Want:    []PkgRef{{"com.bakery.test.app", "2.0.1"}, {"com.bakery.test.helper", "1.0"}},
+	},
+	{
+		Fixture: "../testing/fixtures/pkg/distribution.pkg",
+		Want:    []PkgRef{{"com.bakery.test.app", "2.0.1"}, {"com.bakery.test.helper", "1.0"}},
+	},
+}
+
+func TestReadPkgRefs(t *testing.T) {
+	for _, test := range testXarPkgRefs {
+		refs, err := ReadPkgRefs(test.Fixture)
+		if err != nil {
+			t.Fatalf("ReadPkgRefs failed for %s with %s", test.Fixture, err)
+		}
+
+		if !reflect.DeepEqual(refs, test.Want) {
+			t.Errorf("want %#v but got %#v", test.Want, refs)
+		}
+	}
+}
+
+func TestOpenXar(t *testing.T) {
+	x, err := OpenXar("../testing/fixtures/pkg/product.pkg")
+	if err != nil {
+		t.Fatalf("OpenXar failed with %s", err)
+	}
+	defer x.Close()
+
+	var paths []string
+	for _, f := range x.Files {
+		paths = append(paths, f.Path)
+	}
+
+	want := []string{"Distribution", "app.pkg", "app.pkg/PackageInfo", "app.pkg/Payload", "helper.pkg", "helper.pkg/PackageInfo", "helper.pkg/Payload"}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("want %#v but got %#v", want, paths)
+	}
+
+	b, err := x.ReadFile("app.pkg/Payload")
+	if err != nil || string(b) != "payload" {
+		t.Errorf("want payload but got %q, %v", b, err)
+	}
+
+	if _, err := OpenXar("../testing/fixtures/www/test_download.txt"); err == nil {
+		t.Errorf("want error opening a file which is not a xar archive")
+	}
+}
+
+func TestNewXarInvalid(t *testing.T) {
+	b, err := ioutil.ReadFile("../testing/fixtures/pkg/product.pkg")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A truncated archive, and one claiming a huge table of contents
+	huge := append([]byte{}, b...)
+	binary.BigEndian.PutUint64(huge[8:16], 1<<62)
+
+	for _, archive := range [][]byte{b[:100], huge} {
+		if _, err := NewXar(bytes.NewReader(archive), int64(len(archive))); err == nil {
+			t.Errorf("want error for an invalid archive")
+		}
+	}
+}
+
+func TestXarReadFileLarger(t *testing.T) {
+	var data bytes.Buffer
+	zw := zlib.NewWriter(&data)
+	zw.Write(bytes.Repeat([]byte("a"), 1<<20))
+	zw.Close()
+
+	// The data expands beyond the size the table of contents declares
+	x := &Xar{
+		r:     bytes.NewReader(data.Bytes()),
+		size:  int64(data.Len()),
+		Files: []*XarFile{{Path: "Payload", Type: "file", Length: int64(data.Len()), Size: 10, Encoding: "application/x-gzip"}},
+	}
+	if b, err := x.ReadFile("Payload"); err == nil {
+		t.Errorf("want an error for data larger than its size but got %d bytes", len(b))
+	}
+
+	x.Files[0].Size = 1 << 20
+	if b, err := x.ReadFile("Payload"); err != nil || len(b) != 1<<20 {
+		t.Errorf("want %d bytes but got %d, %v", 1<<20, len(b), err)
+	}
+}
+
+var testPkgRefsInstalled = []struct {
+	Receipts map[string]string
+	Want     bool
+}{
+	{map[string]string{}, false},
+	{map[string]string{"com.bakery.test.app": "2.0.1"}, false},
+	{map[string]string{"com.bakery.test.app": "2.0.1", "com.bakery.test.helper": "1.0"}, true},
+	{map[string]string{"com.bakery.test.app": "2.0.0", "com.bakery.test.helper": "1.0"}, false},
+	{map[string]string{"com.bakery.test.app": "2.1", "com.bakery.test.helper": "1.0.0"}, true},
+}
+
+func TestPkgRefsInstalled(t *testing.T) {
+	refs := []PkgRef{{"com.bakery.test.app", "2.0.1"}, {"com.bakery.test.helper", "1.0"}}
+	for _, test := range testPkgRefsInstalled {
+		if got := PkgRefsInstalled(refs, test.Receipts); got != test.Want {
+			t.Errorf("want %t for %#v but got %t", test.Want, test.Receipts, got)
+		}
+	}
+}
+
+func TestPkgBake(t *testing.T) {
+	runner, restore := useFakeRunner(map[string]fakeBinary{
+		"pkgutil": func(args []string) (string, int) {
+			if args[1] == "com.bakery.test.app" {
+				return "package-id: com.bakery.test.app\nversion: 2.0.0\nvolume: /\nlocation: \ninstall-time: 1580688000", 0
+			}
+			return "No receipt for '" + args[1] + "' found at '/'.", 1
+		},
+		"installer": succeed,
+	})
+	defer restore()
+
+	p := &Pkg{
+		PantryItem:     PantryItem{Name: "test"},
+		Source:         "../testing/fixtures/pkg/product.pkg",
+		AllowUntrusted: true,
+	}
+	p.Bake()
+
+	want := []string{
+		"/usr/sbin/pkgutil --pkg-info com.bakery.test.app",
+		"/usr/sbin/pkgutil --pkg-info com.bakery.test.helper",
+		"/usr/sbin/installer -pkg ../testing/fixtures/pkg/product.pkg -target / -allowUntrusted",
+	}
+	if !reflect.DeepEqual(runner.Ran(), want) {
+		t.Errorf("want %#v but got %#v", want, runner.Ran())
+	}
+}
@@ -0,0 +1,194 @@
+package pantry
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+var plutilBin = "/usr/bin/plutil"
+
+// ReadPlistString returns the string value of a top level key from a
+// property list, converting binary property lists to XML with plutil
+func ReadPlistString(name, key string) (string, error) {
+	b, err := readPlistXML(name)
+	if err != nil {
+		return "", err
+	}
+
+	return plistString(bytes.NewReader(b), key)
+}
+
+// ReadPlist decodes a property list into maps, lists, strings, numbers and
+// booleans, converting binary property lists to XML with plutil
+func ReadPlist(name string) (interface{}, error) {
+	b, err := readPlistXML(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodePlist(bytes.NewReader(b))
+}
+
+// readPlistXML reads a property list as XML
+func readPlistXML(name string) ([]byte, error) {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.HasPrefix(b, []byte("bplist")) {
+		o, err := Runner.Run(&Command{Args: []string{plutilBin, "-convert", "xml1", "-o", "-", name}})
+		if err != nil {
+			return nil, fmt.Errorf("Error converting %s: %s", name, err)
+		}
+		b = []byte(o.String())
+	}
+
+	return b, nil
+}
+
+// decodePlist decodes the top level value of an XML property list
+func decodePlist(r io.Reader) (interface{}, error) {
+	d := xml.NewDecoder(r)
+	for {
+		t, err := d.Token()
+		if err == io.EOF {
+			return nil, fmt.Errorf("No value found in property list")
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if start, ok := t.(xml.StartElement); ok && start.Name.Local != "plist" {
+			return plistValue(d, start)
+		}
+	}
+}
+
+// plistValue decodes the value of the element which was started
+func plistValue(d *xml.Decoder, start xml.StartElement) (interface{}, error) {
+	switch start.Name.Local {
+	case "dict":
+		var dict = map[string]interface{}{}
+		var key *string
+		for {
+			t, err := d.Token()
+			if err != nil {
+				return nil, err
+			}
+
+			switch t := t.(type) {
+			case xml.StartElement:
+				if t.Name.Local == "key" {
+					var k string
+					if err := d.DecodeElement(&k, &t); err != nil {
+						return nil, err
+					}
+					key = &k
+					continue
+				}
+
+				if key == nil {
+					return nil, fmt.Errorf("%s without a key in dict", t.Name.Local)
+				}
+
+				v, err := plistValue(d, t)
+				if err != nil {
+					return nil, err
+				}
+				dict[*key] = v
+				key = nil
+			case xml.EndElement:
+				return dict, nil
+			}
+		}
+	case "array":
+		var array = []interface{}{}
+		for {
+			t, err := d.Token()
+			if err != nil {
+				return nil, err
+			}
+
+			switch t := t.(type) {
+			case xml.StartElement:
+				v, err := plistValue(d, t)
+				if err != nil {
+					return nil, err
+				}
+				array = append(array, v)
+			case xml.EndElement:
+				return array, nil
+			}
+		}
+	case "true", "false":
+		return start.Name.Local == "true", d.Skip()
+	}
+
+	var text string
+	if err := d.DecodeElement(&text, &start); err != nil {
+		return nil, err
+	}
+
+	switch start.Name.Local {
+	case "integer":
+		return strconv.ParseInt(strings.TrimSpace(text), 10, 64)
+	case "real":
+		return strconv.ParseFloat(strings.TrimSpace(text), 64)
+	case "data":
+		// data is base64, which is kept as is without the line breaks
+		return strings.Join(strings.Fields(text), ""), nil
+	case "string", "date":
+		return text, nil
+	}
+
+	return nil, fmt.Errorf("Unknown property list element %s", start.Name.Local)
+}
+
+// plistString finds the string value of a key in the top level dict of an
+// XML property list
+func plistString(r io.Reader, key string) (string, error) {
+	var depth int
+	var inKey, found bool
+	var text strings.Builder
+
+	d := xml.NewDecoder(r)
+	for {
+		t, err := d.Token()
+		if err == io.EOF {
+			return "", fmt.Errorf("%s not found in property list", key)
+		}
+		if err != nil {
+			return "", err
+		}
+
+		switch t := t.(type) {
+		case xml.StartElement:
+			depth++
+			text.Reset()
+			// Only the children of the top level dict are considered,
+			// the plist element being depth 1 and the dict depth 2
+			if depth == 3 {
+				inKey = t.Name.Local == "key"
+				if found && t.Name.Local != "string" {
+					return "", fmt.Errorf("%s is not a string", key)
+				}
+			}
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			if depth == 3 {
+				if found {
+					return strings.TrimSpace(text.String()), nil
+				}
+				found = inKey && text.String() == key
+			}
+			depth--
+		}
+	}
+}
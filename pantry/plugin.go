@@ -0,0 +1,284 @@
+package pantry
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/hcl2/hcl"
+	"github.com/hashicorp/hcl2/hcldec"
+	"github.com/mikemackintosh/bakery/cli"
+	"github.com/mikemackintosh/bakery/sdk"
+	"github.com/zclconf/go-cty/cty"
+	ctyjson "github.com/zclconf/go-cty/cty/json"
+)
+
+// pluginPrefix is the prefix of the executables which provide resource types
+const pluginPrefix = "bakery-resource-"
+
+// pluginSchemaTimeout is how long a plugin has to describe its schema, and
+// pluginTimeout how long it has to check or apply a block which does not
+// set its own timeout
+var (
+	pluginSchemaTimeout = 30 * time.Second
+	pluginTimeout       = 10 * time.Minute
+)
+
+// pluginTimeoutSpec is the timeout attribute every plugin block has
+var pluginTimeoutSpec = &hcldec.AttrSpec{
+	Name:     "timeout",
+	Required: false,
+	Type:     cty.String,
+}
+
+// pluginTypes are the attribute types of the plugin schema
+var pluginTypes = map[string]cty.Type{
+	sdk.TypeString: cty.String,
+	sdk.TypeNumber: cty.Number,
+	sdk.TypeBool:   cty.Bool,
+	sdk.TypeList:   cty.List(cty.String),
+	sdk.TypeMap:    cty.Map(cty.String),
+	sdk.TypeAny:    cty.DynamicPseudoType,
+}
+
+// FailureInterface is implemented by pantry items which report the error
+// which stopped them from being baked
+type FailureInterface interface {
+	Failed() error
+}
+
+// ChangeInterface is implemented by pantry items which report whether baking
+// them changed anything, as every PantryItem does. Items which do not are
+// assumed to have changed.
+type ChangeInterface interface {
+	Changed() bool
+}
+
+// Plugin is a resource provided by a plugin executable
+type Plugin struct {
+	PantryItem
+
+	blockType  string
+	path       string
+	attributes []string
+	spec       *hcldec.ObjectSpec
+	config     cty.Value
+	timeout    time.Duration
+	response   *sdk.Response
+	err        error
+}
+
+// LoadPlugins registers a resource type for each bakery-resource-<type>
+// executable in the directories of the path, which is separated like PATH.
+// When a type is provided by more than one directory, the first is used.
+func LoadPlugins(path string) error {
+	for _, dir := range filepath.SplitList(path) {
+		names, err := filepath.Glob(filepath.Join(dir, pluginPrefix+"*"))
+		if err != nil {
+			return err
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			info, err := os.Stat(name)
+			if err != nil || info.IsDir() || info.Mode()&0111 == 0 {
+				continue
+			}
+
+			blockType := strings.TrimPrefix(filepath.Base(name), pluginPrefix)
+			if IsResourceType(blockType) {
+				cli.Debug(cli.DEBUG, "\t-> Skipping plugin, the type is already registered", name)
+				continue
+			}
+
+			if err := loadPlugin(blockType, name); err != nil {
+				return fmt.Errorf("Error loading plugin %s: %s", name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// loadPlugin asks the plugin for its schema, and registers its block type
+func loadPlugin(blockType, path string) error {
+	o, err := Runner.Run(&Command{Args: []string{path, sdk.PhaseSchema}, Timeout: pluginSchemaTimeout})
+	if err != nil && o == nil {
+		return err
+	}
+	if o.ExitCode != 0 {
+		return fmt.Errorf("exited with %d: %s", o.ExitCode, o.Stderr)
+	}
+
+	var schema sdk.Schema
+	if err := json.Unmarshal([]byte(o.Stdout), &schema); err != nil {
+		return fmt.Errorf("invalid schema: %s", err)
+	}
+
+	var attributes []string
+	var spec = &hcldec.ObjectSpec{}
+	for name, attr := range schema.Attributes {
+		if _, ok := (*defaultSpec)[name]; ok || name == pluginTimeoutSpec.Name {
+			return fmt.Errorf("the attribute %q is reserved", name)
+		}
+
+		t, ok := pluginTypes[attr.Type]
+		if !ok {
+			return fmt.Errorf("the attribute %q has an unknown type %q", name, attr.Type)
+		}
+
+		(*spec)[name] = &hcldec.AttrSpec{Name: name, Required: attr.Required, Type: t}
+		attributes = append(attributes, name)
+	}
+	sort.Strings(attributes)
+	(*spec)[pluginTimeoutSpec.Name] = pluginTimeoutSpec
+	spec = NewPantrySpec(spec)
+
+	Register(blockType, func(name string, config hcl.Body) PantryInterface {
+		return &Plugin{
+			PantryItem: PantryItem{Name: name, Config: config},
+			blockType:  blockType,
+			path:       path,
+			attributes: attributes,
+			spec:       spec,
+		}
+	})
+
+	cli.Debug(cli.DEBUG, "Loaded plugin", path)
+	return nil
+}
+
+// Parse will parse the configuration for this block type
+func (p *Plugin) Parse(evalContext *hcl.EvalContext) error {
+	cli.Debug(cli.INFO, "Preparing "+p.blockType, p.Name)
+	cfg, diags := hcldec.Decode(p.Config, p.spec, evalContext)
+	if len(diags) != 0 {
+		for _, diag := range diags {
+			cli.Debug(cli.INFO, "\t#", diag)
+		}
+		return fmt.Errorf("%s", diags.Errs()[0])
+	}
+
+	var defaults = map[string]cty.Value{}
+	for name := range *defaultSpec {
+		defaults[name] = cfg.GetAttr(name)
+	}
+	if err := p.Populate(cty.ObjectVal(defaults), &p.PantryItem); err != nil {
+		return err
+	}
+
+	p.timeout = pluginTimeout
+	if v := cfg.GetAttr(pluginTimeoutSpec.Name); !v.IsNull() {
+		var timeout = v.AsString()
+		d, err := parseTimeout(&timeout)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("%s %q has an invalid timeout %q", p.blockType, p.Name, timeout)
+		}
+		p.timeout = d
+	}
+
+	// Only the attributes which are set are sent to the plugin
+	var attrs = map[string]cty.Value{}
+	for _, name := range p.attributes {
+		if v := cfg.GetAttr(name); !v.IsNull() {
+			attrs[name] = v
+		}
+	}
+	p.config = cty.ObjectVal(attrs)
+
+	return nil
+}
+
+// Bake will check the resource with the plugin, and apply it when it needs
+// to be changed
+func (p *Plugin) Bake() {
+	res, err := p.call(sdk.PhaseCheck)
+	if err == nil && res.Changed {
+		res, err = p.call(sdk.PhaseApply)
+	} else if err == nil {
+		cli.Debug(cli.INFO, "\t-> Skipping, nothing to change", nil)
+	}
+
+	if err != nil {
+		p.err = err
+		cli.Debug(cli.ERROR, fmt.Sprintf("\t-> Error running %s", p.Name), err)
+		return
+	}
+
+	p.response = res
+}
+
+// Failed returns the error from the plugin, if it failed
+func (p *Plugin) Failed() error {
+	return p.err
+}
+
+// Changed returns true when the plugin applied a change
+func (p *Plugin) Changed() bool {
+	return p.response != nil && p.response.Changed
+}
+
+// Outputs returns the outputs of the plugin, and whether it changed the
+// resource, which is null until it has run
+func (p *Plugin) Outputs() cty.Value {
+	if p.response == nil {
+		return cty.ObjectVal(map[string]cty.Value{"changed": cty.NullVal(cty.Bool)})
+	}
+
+	var out = map[string]cty.Value{}
+	if len(p.response.Outputs) > 0 {
+		if b, err := json.Marshal(p.response.Outputs); err == nil {
+			if t, err := ctyjson.ImpliedType(b); err == nil {
+				if v, err := ctyjson.Unmarshal(b, t); err == nil {
+					out = v.AsValueMap()
+				}
+			}
+		}
+	}
+	out["changed"] = cty.BoolVal(p.response.Changed)
+
+	return cty.ObjectVal(out)
+}
+
+// call runs a phase of the plugin with the block as the request
+func (p *Plugin) call(phase string) (*sdk.Response, error) {
+	config, err := json.Marshal(ctyjson.SimpleJSONValue{Value: p.config})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := json.Marshal(&sdk.Request{Type: p.blockType, Name: p.Name, Config: config})
+	if err != nil {
+		return nil, err
+	}
+
+	cli.Debug(cli.DEBUG, "\t-> Running plugin "+phase, p.path)
+	o, err := Runner.Run(&Command{Args: []string{p.path, phase}, Stdin: bytes.NewReader(req), Timeout: p.timeout})
+	if _, ok := err.(*TimeoutError); ok {
+		return nil, fmt.Errorf("%s %s: %s", p.blockType, phase, err)
+	}
+	if err != nil && o == nil {
+		return nil, err
+	}
+	if len(o.Stderr) > 0 {
+		cli.Debug(cli.INFO, "\t-> "+p.blockType+" "+phase, o.Stderr)
+	}
+	if o.ExitCode != 0 {
+		return nil, fmt.Errorf("%s %s exited with %d: %s", p.blockType, phase, o.ExitCode, o.Stderr)
+	}
+
+	var res sdk.Response
+	if err := json.Unmarshal([]byte(o.Stdout), &res); err != nil {
+		return nil, fmt.Errorf("%s %s returned an invalid response: %s", p.blockType, phase, err)
+	}
+	if res.Error != "" {
+		return nil, fmt.Errorf("%s %s failed: %s", p.blockType, phase, res.Error)
+	}
+
+	return &res, nil
+}
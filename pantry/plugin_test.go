@@ -0,0 +1,189 @@
+package pantry
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/hcl2/hcl"
+)
+
+// testPlugin describes a path attribute, reports a change unless the path is
+// /done, and records the request it is applied with
+const testPlugin = `#!/bin/sh
+case "$1" in
+schema)
+  echo '{"attributes": {"path": {"type": "string", "required": true}, "mode": {"type": "number"}}}'
+  ;;
+check)
+  if grep -q '"/done"'; then echo '{"changed": false}'; else echo '{"changed": true}'; fi
+  ;;
+apply)
+  cat > "$(dirname "$0")/request.json"
+  echo '{"changed": true, "outputs": {"id": "abc"}}'
+  ;;
+esac
+`
+
+func TestPlugin(t *testing.T) {
+	defer useExec()()
+
+	dir, err := ioutil.TempDir("", "plugins")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "bakery-resource-test_plugin"), []byte(testPlugin), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "bakery-resource-not_executable"), []byte(testPlugin), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := LoadPlugins(dir + string(os.PathListSeparator) + dir); err != nil {
+		t.Fatalf("want no error but got %s", err)
+	}
+	if IsResourceType("not_executable") {
+		t.Errorf("want files which are not executable to be ignored")
+	}
+
+	r, err := NewResource("test_plugin", "profile", testBody(t, `path = "/todo"`))
+	if err != nil {
+		t.Fatalf("want no error but got %s", err)
+	}
+	if err := r.Parse(&hcl.EvalContext{}); err != nil {
+		t.Fatalf("want no error but got %s", err)
+	}
+	r.Bake()
+
+	p := r.(*Plugin)
+	if err := p.Failed(); err != nil {
+		t.Fatalf("want no error but got %s", err)
+	}
+	if got := p.Outputs().GetAttr("id").AsString(); got != "abc" {
+		t.Errorf("want abc but got %s", got)
+	}
+	if !p.Outputs().GetAttr("changed").True() {
+		t.Errorf("want the plugin to report a change")
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, "request.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"type":"test_plugin","name":"profile","config":{"path":"/todo"}}`; strings.TrimSpace(string(b)) != want {
+		t.Errorf("want %s but got %s", want, b)
+	}
+
+	r, _ = NewResource("test_plugin", "done", testBody(t, `path = "/done"`))
+	if err := r.Parse(&hcl.EvalContext{}); err != nil {
+		t.Fatalf("want no error but got %s", err)
+	}
+	r.Bake()
+	if r.(*Plugin).Outputs().GetAttr("changed").True() {
+		t.Errorf("want no change when check reports nothing to change")
+	}
+
+	r, _ = NewResource("test_plugin", "missing", testBody(t, `mode = 1`))
+	if err := r.Parse(&hcl.EvalContext{}); err == nil || !strings.Contains(err.Error(), `"path" is required`) {
+		t.Errorf("want a missing path error but got %v", err)
+	}
+}
+
+func TestPluginErrors(t *testing.T) {
+	runner, restore := useFakeRunner(map[string]fakeBinary{
+		"bakery-resource-reserved": func(args []string) (string, int) {
+			return `{"attributes": {"not_if": {"type": "string"}}}`, 0
+		},
+		"bakery-resource-timeout": func(args []string) (string, int) {
+			return `{"attributes": {"timeout": {"type": "string"}}}`, 0
+		},
+		"bakery-resource-unknown": func(args []string) (string, int) {
+			return `{"attributes": {"size": {"type": "float"}}}`, 0
+		},
+		"bakery-resource-failing": func(args []string) (string, int) {
+			if args[0] == "schema" {
+				return `{"attributes": {}}`, 0
+			}
+			return `{"changed": false, "error": "no certificate authority"}`, 0
+		},
+	})
+	defer restore()
+
+	for name, want := range map[string]string{
+		"reserved": `the attribute "not_if" is reserved`,
+		"timeout":  `the attribute "timeout" is reserved`,
+		"unknown":  `unknown type "float"`,
+	} {
+		if err := loadPlugin("plugin_"+name, "bakery-resource-"+name); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: want %s but got %v", name, want, err)
+		}
+	}
+
+	if err := loadPlugin("plugin_failing", "bakery-resource-failing"); err != nil {
+		t.Fatalf("want no error but got %s", err)
+	}
+	r, _ := NewResource("plugin_failing", "a", testBody(t, ``))
+	if err := r.Parse(&hcl.EvalContext{}); err != nil {
+		t.Fatalf("want no error but got %s", err)
+	}
+	r.Bake()
+	if err := r.(FailureInterface).Failed(); err == nil || !strings.Contains(err.Error(), "no certificate authority") {
+		t.Errorf("want the plugin error but got %v", err)
+	}
+	if last := runner.Commands[len(runner.Commands)-1]; last.Timeout != pluginTimeout {
+		t.Errorf("want check to have the default timeout but got %s", last.Timeout)
+	}
+
+	r, _ = NewResource("plugin_failing", "b", testBody(t, `timeout = "soon"`))
+	if err := r.Parse(&hcl.EvalContext{}); err == nil || !strings.Contains(err.Error(), "invalid timeout") {
+		t.Errorf("want an invalid timeout error but got %v", err)
+	}
+}
+
+// testSleepingPlugin hangs when checking a block
+const testSleepingPlugin = `#!/bin/sh
+case "$1" in
+schema)
+  echo '{"attributes": {}}'
+  ;;
+check)
+  sleep 30
+  ;;
+esac
+`
+
+func TestPluginTimeout(t *testing.T) {
+	defer useExec()()
+
+	dir, err := ioutil.TempDir("", "plugins")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "bakery-resource-sleeping"), []byte(testSleepingPlugin), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadPlugins(dir); err != nil {
+		t.Fatalf("want no error but got %s", err)
+	}
+
+	r, _ := NewResource("sleeping", "a", testBody(t, `timeout = "100ms"`))
+	if err := r.Parse(&hcl.EvalContext{}); err != nil {
+		t.Fatalf("want no error but got %s", err)
+	}
+
+	var start = time.Now()
+	r.Bake()
+	if err := r.(FailureInterface).Failed(); err == nil || !strings.Contains(err.Error(), "sleeping check: Command timed out after 100ms") {
+		t.Errorf("want the check to time out but got %v", err)
+	}
+	if time.Since(start) > 10*time.Second {
+		t.Errorf("want the plugin to be stopped but took %s", time.Since(start))
+	}
+}
@@ -0,0 +1,115 @@
+package pantry
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/hashicorp/hcl2/hcl"
+)
+
+// Factory creates a resource from the name and body of its block
+type Factory func(name string, config hcl.Body) PantryInterface
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Factory{}
+)
+
+func init() {
+	Register("brew", func(name string, config hcl.Body) PantryInterface {
+		return &Brew{PantryItem: PantryItem{Name: name, Config: config}}
+	})
+	Register("brewfile", func(name string, config hcl.Body) PantryInterface {
+		return &Brewfile{PantryItem: PantryItem{Name: name, Config: config}}
+	})
+	Register("dmg", func(name string, config hcl.Body) PantryInterface {
+		return &Dmg{PantryItem: PantryItem{Name: name, Config: config}}
+	})
+	Register("font", func(name string, config hcl.Body) PantryInterface {
+		return &Font{PantryItem: PantryItem{Name: name, Config: config}}
+	})
+	Register("git", func(name string, config hcl.Body) PantryInterface {
+		return &Git{PantryItem: PantryItem{Name: name, Config: config}}
+	})
+	Register("package", func(name string, config hcl.Body) PantryInterface {
+		return &Package{PantryItem: PantryItem{Name: name, Config: config}}
+	})
+	Register("pkg", func(name string, config hcl.Body) PantryInterface {
+		return &Pkg{PantryItem: PantryItem{Name: name, Config: config}}
+	})
+	Register("shell", func(name string, config hcl.Body) PantryInterface {
+		return &Shell{PantryItem: PantryItem{Name: name, Config: config}}
+	})
+	Register("zip", func(name string, config hcl.Body) PantryInterface {
+		return &Zip{PantryItem: PantryItem{Name: name, Config: config}}
+	})
+	Register("pip_package", func(name string, config hcl.Body) PantryInterface {
+		return &PipPackage{LangPackage{PantryItem: PantryItem{Name: name, Config: config}}}
+	})
+	Register("npm_package", func(name string, config hcl.Body) PantryInterface {
+		return &NpmPackage{LangPackage{PantryItem: PantryItem{Name: name, Config: config}}}
+	})
+	Register("gem_package", func(name string, config hcl.Body) PantryInterface {
+		return &GemPackage{LangPackage{PantryItem: PantryItem{Name: name, Config: config}}}
+	})
+	Register("go_install", func(name string, config hcl.Body) PantryInterface {
+		return &GoInstall{LangPackage{PantryItem: PantryItem{Name: name, Config: config}}}
+	})
+	Register("cargo_install", func(name string, config hcl.Body) PantryInterface {
+		return &CargoInstall{LangPackage{PantryItem: PantryItem{Name: name, Config: config}}}
+	})
+}
+
+// Register adds a resource block type, created by the factory. Resource types
+// compiled into the binary register themselves from an init function, and
+// registering a block type twice panics.
+func Register(blockType string, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if factory == nil {
+		panic(fmt.Sprintf("pantry: the factory for %q is nil", blockType))
+	}
+	if _, ok := registry[blockType]; ok {
+		panic(fmt.Sprintf("pantry: the block type %q is already registered", blockType))
+	}
+
+	registry[blockType] = factory
+}
+
+// ResourceTypes returns the registered resource block types, sorted
+func ResourceTypes() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	var types []string
+	for t := range registry {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+
+	return types
+}
+
+// IsResourceType returns true when the block type is registered
+func IsResourceType(blockType string) bool {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	_, ok := registry[blockType]
+	return ok
+}
+
+// NewResource creates a resource of a registered block type
+func NewResource(blockType, name string, config hcl.Body) (PantryInterface, error) {
+	registryMu.RLock()
+	factory, ok := registry[blockType]
+	registryMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("%q is not a resource type", blockType)
+	}
+
+	return factory(name, config), nil
+}
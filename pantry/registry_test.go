@@ -0,0 +1,33 @@
+package pantry
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/hcl2/hcl"
+)
+
+func TestRegistry(t *testing.T) {
+	r, err := NewResource("shell", "greet", nil)
+	if err != nil {
+		t.Fatalf("want no error but got %s", err)
+	}
+	if s, ok := r.(*Shell); !ok || s.Name != "greet" {
+		t.Errorf("want a shell named greet but got %#v", r)
+	}
+
+	if _, err := NewResource("nope", "a", nil); err == nil || !strings.Contains(err.Error(), `"nope" is not a resource type`) {
+		t.Errorf("want an error but got %v", err)
+	}
+
+	if got := strings.Join(ResourceTypes(), " "); !strings.HasPrefix(got, "brew brewfile cargo_install") {
+		t.Errorf("want the sorted resource types but got %s", got)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("want registering shell twice to panic")
+		}
+	}()
+	Register("shell", func(name string, config hcl.Body) PantryInterface { return nil })
+}
@@ -0,0 +1,190 @@
+package pantry
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/mikemackintosh/bakery/cli"
+)
+
+// killGrace is how long a timed out process group has to exit after being
+// terminated, before it is killed
+var killGrace = 5 * time.Second
+
+// Command describes a command to be run by a CommandRunner
+type Command struct {
+	Args []string
+
+	// Credential runs the command as another user when set
+	Credential *syscall.Credential
+
+	// Env is appended to the current environment
+	Env   []string
+	Dir   string
+	Stdin io.Reader
+
+	// Timeout terminates the command, and any processes it started, when
+	// it runs for longer than the duration
+	Timeout time.Duration
+
+	// Umask sets the file mode creation mask of the command when set
+	Umask *os.FileMode
+
+	// Stream shows the output of the command as it runs, each line prefixed
+	// with the value, and captures it in the run log
+	Stream string
+}
+
+// CommandRunner runs commands on behalf of pantry items
+type CommandRunner interface {
+	Run(*Command) (*CommandResponse, error)
+}
+
+// Runner is used by pantry items to run commands, and can be replaced so
+// items can be tested without touching the host
+var Runner CommandRunner = &ExecRunner{}
+
+// ExecRunner runs commands on the host
+type ExecRunner struct{}
+
+// Run will run the command and wait for it to complete
+func (r *ExecRunner) Run(c *Command) (*CommandResponse, error) {
+	var exitCode int
+
+	if os.Getenv("GO_WANT_HELPER_PROCESS") == "1" {
+		return &CommandResponse{
+			Command: nil,
+			Raw:     TestRunCommandOutput,
+			Stdout:  TestRunCommandOutput,
+		}, nil
+	}
+
+	var args = c.Args
+	if c.Umask != nil {
+		args = append([]string{"/bin/sh", "-c", fmt.Sprintf(`umask %04o && exec "$@"`, *c.Umask), "sh"}, args...)
+	}
+
+	cmd := exec.Command(args[0], args[1:]...)
+	// A command with a timeout runs in its own process group, so it can be
+	// terminated along with any children it starts. Others stay in the
+	// foreground group, so they can still prompt on the terminal.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: c.Timeout > 0}
+	if c.Credential != nil {
+		cmd.SysProcAttr.Credential = c.Credential
+	}
+	if len(c.Env) > 0 {
+		cmd.Env = append(os.Environ(), c.Env...)
+	}
+	cmd.Dir = c.Dir
+	cmd.Stdin = c.Stdin
+
+	// stdout and stderr are captured separately, as well as together, so
+	// they are copied concurrently and share a lock
+	var res, stdout, stderr bytes.Buffer
+	var outputs = []io.Writer{&res, &stdout}
+	var errors = []io.Writer{&res, &stderr}
+
+	var stream *cli.Stream
+	if len(c.Stream) > 0 {
+		stream = cli.NewStream(c.Stream, c.Args)
+		outputs = append(outputs, stream.Stdout())
+		errors = append(errors, stream.Stderr())
+	}
+
+	var mu sync.Mutex
+	cmd.Stdout = &lockedWriter{mu: &mu, w: io.MultiWriter(outputs...)}
+	cmd.Stderr = &lockedWriter{mu: &mu, w: io.MultiWriter(errors...)}
+
+	err := cmd.Start()
+	if err == nil {
+		err = wait(cmd, c.Timeout)
+	}
+
+	if stream != nil {
+		stream.Close(err != nil)
+	}
+
+	if err != nil {
+		// try to get the exit code
+		if exitError, ok := err.(*exec.ExitError); ok {
+			ws := exitError.Sys().(syscall.WaitStatus)
+			exitCode = ws.ExitStatus()
+		} else if _, ok := err.(*TimeoutError); ok {
+			exitCode = -1
+		} else {
+			// the command could not be started, which a shell reports as
+			// not found
+			exitCode = 127
+		}
+	} else {
+		// success, exitCode should be 0 if go is ok
+		ws := cmd.ProcessState.Sys().(syscall.WaitStatus)
+		exitCode = ws.ExitStatus()
+	}
+
+	return &CommandResponse{
+		Command:  cmd,
+		Raw:      strings.TrimSpace(res.String()),
+		Stdout:   strings.TrimSpace(stdout.String()),
+		Stderr:   strings.TrimSpace(stderr.String()),
+		ExitCode: exitCode,
+	}, err
+}
+
+// wait waits for the command to exit, terminating its process group if it
+// runs beyond the timeout
+func wait(cmd *exec.Cmd, timeout time.Duration) error {
+	if timeout <= 0 {
+		return cmd.Wait()
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(timeout):
+	}
+
+	pgid := -cmd.Process.Pid
+	syscall.Kill(pgid, syscall.SIGTERM)
+	select {
+	case <-done:
+	case <-time.After(killGrace):
+		syscall.Kill(pgid, syscall.SIGKILL)
+		<-done
+	}
+
+	return &TimeoutError{Timeout: timeout}
+}
+
+// TimeoutError is returned when a command runs for longer than its timeout
+type TimeoutError struct {
+	Timeout time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("Command timed out after %s", e.Timeout)
+}
+
+// lockedWriter serialises writes to a writer shared between goroutines
+type lockedWriter struct {
+	mu *sync.Mutex
+	w  io.Writer
+}
+
+func (l *lockedWriter) Write(b []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(b)
+}
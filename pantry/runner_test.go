@@ -0,0 +1,168 @@
+package pantry
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/mikemackintosh/bakery/cli"
+)
+
+// fakeBinary answers a command with its output and exit code
+type fakeBinary func(args []string) (string, int)
+
+// fakeRunner stands in for the binaries on the host, recording each
+// command it is asked to run
+type fakeRunner struct {
+	Binaries map[string]fakeBinary
+	Commands []*Command
+}
+
+// useFakeRunner replaces the Runner and returns a func to restore it
+func useFakeRunner(binaries map[string]fakeBinary) (*fakeRunner, func()) {
+	previous := Runner
+	r := &fakeRunner{Binaries: binaries}
+	Runner = r
+	return r, func() {
+		Runner = previous
+	}
+}
+
+// Run will answer the command using the fake binary of the same name, or
+// fail as if the binary was not found
+func (r *fakeRunner) Run(c *Command) (*CommandResponse, error) {
+	r.Commands = append(r.Commands, c)
+
+	bin, ok := r.Binaries[path.Base(c.Args[0])]
+	if !ok {
+		return &CommandResponse{ExitCode: 127}, fmt.Errorf("exec: %q: executable file not found in $PATH", c.Args[0])
+	}
+
+	out, exitCode := bin(c.Args[1:])
+	res := &CommandResponse{Raw: strings.TrimSpace(out), Stdout: strings.TrimSpace(out), ExitCode: exitCode}
+	if exitCode != 0 {
+		return res, fmt.Errorf("exit status %d", exitCode)
+	}
+
+	return res, nil
+}
+
+// Ran returns the commands which were run, joined as strings
+func (r *fakeRunner) Ran() []string {
+	var ran []string
+	for _, c := range r.Commands {
+		ran = append(ran, strings.Join(c.Args, " "))
+	}
+
+	return ran
+}
+
+// succeed is a fake binary which always succeeds without output
+func succeed(args []string) (string, int) {
+	return "", 0
+}
+
+// useExec runs commands on the host for the duration of a test
+func useExec() func() {
+	os.Unsetenv("GO_WANT_HELPER_PROCESS")
+	return func() {
+		os.Setenv("GO_WANT_HELPER_PROCESS", "1")
+	}
+}
+
+func TestExecRunnerTimeout(t *testing.T) {
+	defer useExec()()
+
+	// The child keeps the output open, so the run only ends once the whole
+	// process group is killed
+	var start = time.Now()
+	o, err := (&ExecRunner{}).Run(&Command{
+		Args:    []string{"/bin/sh", "-c", "sleep 30 & wait"},
+		Timeout: 100 * time.Millisecond,
+	})
+	if _, ok := err.(*TimeoutError); !ok {
+		t.Fatalf("want a timeout but got %v", err)
+	}
+	if o.ExitCode != -1 {
+		t.Errorf("want -1 but got %d", o.ExitCode)
+	}
+	if time.Since(start) > 10*time.Second {
+		t.Errorf("want the process group killed but took %s", time.Since(start))
+	}
+}
+
+var processGroupTests = []struct {
+	Timeout  time.Duration
+	Expected bool
+}{
+	{0, true},
+	{time.Minute, false},
+}
+
+func TestExecRunnerProcessGroup(t *testing.T) {
+	defer useExec()()
+
+	for _, test := range processGroupTests {
+		o, err := (&ExecRunner{}).Run(&Command{
+			Args:    []string{"/bin/sh", "-c", "ps -o pgid= -p $$"},
+			Timeout: test.Timeout,
+		})
+		if err != nil {
+			t.Fatalf("want no error but got %s", err)
+		}
+		var same = strings.TrimSpace(o.Stdout) == fmt.Sprint(syscall.Getpgrp())
+		if same != test.Expected {
+			t.Errorf("want the command with a timeout of %s in the process group of bakery to be %t but got group %s", test.Timeout, test.Expected, o.Stdout)
+		}
+	}
+}
+
+func TestExecRunnerStream(t *testing.T) {
+	defer useExec()()
+
+	var out, log bytes.Buffer
+	output, runLog := cli.Output, cli.RunLog
+	cli.Output, cli.RunLog = &out, &log
+	defer func() {
+		cli.Output, cli.RunLog = output, runLog
+	}()
+
+	o, err := (&ExecRunner{}).Run(&Command{
+		Args:   []string{"/bin/sh", "-c", "echo out; echo err >&2"},
+		Stream: "test",
+	})
+	if err != nil {
+		t.Fatalf("want no error but got %s", err)
+	}
+
+	// Both streams are captured, though their order is not guaranteed
+	for _, line := range []string{"out", "err"} {
+		if !strings.Contains(o.String(), line) {
+			t.Errorf("want %s in the response but got %s", line, o.String())
+		}
+		if !strings.Contains(out.String(), line) || !strings.Contains(log.String(), "[test] "+line) {
+			t.Errorf("want %s streamed but got %q and %q", line, out.String(), log.String())
+		}
+	}
+}
+
+func TestExecRunnerUmask(t *testing.T) {
+	defer useExec()()
+
+	var umask os.FileMode = 027
+	o, err := (&ExecRunner{}).Run(&Command{
+		Args:  []string{"/bin/sh", "-c", "umask"},
+		Umask: &umask,
+	})
+	if err != nil {
+		t.Fatalf("want no error but got %s", err)
+	}
+	if o.String() != "0027" {
+		t.Errorf("want 0027 but got %s", o.String())
+	}
+}
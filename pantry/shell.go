@@ -1,18 +1,18 @@
 package pantry
 
 import (
-	"crypto/sha256"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"regexp"
 	"runtime"
+	"sort"
+	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
-	"github.com/fatih/color"
 	"github.com/hashicorp/hcl2/hcl"
 	"github.com/hashicorp/hcl2/hcldec"
 	"github.com/mikemackintosh/bakery/cli"
@@ -20,18 +20,78 @@ import (
 	"github.com/zclconf/go-cty/cty"
 )
 
+// shellInterpreters are the interpreters which can be named, any other
+// interpreter is given as a path or an argument list
+var shellInterpreters = map[string][]string{
+	"sh":      {"/bin/sh"},
+	"bash":    {"/bin/bash"},
+	"zsh":     {"/bin/zsh"},
+	"python3": {"/usr/bin/env", "python3"},
+}
+
+// Shell is a script object
 type Shell struct {
 	PantryItem
-	Script string `json:"script"`
+	Script      string            `json:"script"`
+	Interpreter StringList        `json:"interpreter"`
+	Env         map[string]string `json:"env"`
+	Cwd         *string           `json:"cwd"`
+	Timeout     *string           `json:"timeout"`
+	Umask       *string           `json:"umask"`
+	Creates     *string           `json:"creates"`
+	Removes     *string           `json:"removes"`
+	Returns     []int             `json:"returns"`
+
+	response *CommandResponse
 }
 
-// identifies the DMG spec
+// identifies the shell spec
 var shellSpec = NewPantrySpec(&hcldec.ObjectSpec{
 	"script": &hcldec.AttrSpec{
 		Name:     "script",
 		Required: true,
 		Type:     cty.String,
 	},
+	"interpreter": &hcldec.AttrSpec{
+		Name:     "interpreter",
+		Required: false,
+		Type:     cty.DynamicPseudoType,
+	},
+	"env": &hcldec.AttrSpec{
+		Name:     "env",
+		Required: false,
+		Type:     cty.Map(cty.String),
+	},
+	"cwd": &hcldec.AttrSpec{
+		Name:     "cwd",
+		Required: false,
+		Type:     cty.String,
+	},
+	"timeout": &hcldec.AttrSpec{
+		Name:     "timeout",
+		Required: false,
+		Type:     cty.String,
+	},
+	"umask": &hcldec.AttrSpec{
+		Name:     "umask",
+		Required: false,
+		Type:     cty.String,
+	},
+	"creates": &hcldec.AttrSpec{
+		Name:     "creates",
+		Required: false,
+		Type:     cty.String,
+	},
+	"removes": &hcldec.AttrSpec{
+		Name:     "removes",
+		Required: false,
+		Type:     cty.String,
+	},
+	"returns": &hcldec.AttrSpec{
+		Name:     "returns",
+		Required: false,
+		Type:     cty.List(cty.Number),
+	},
 })
 
 // Parse will parse the configuration for this block type
@@ -50,190 +110,250 @@ func (p *Shell) Parse(evalContext *hcl.EvalContext) error {
 		return err
 	}
 
+	if _, err := p.GetTimeout(); err != nil {
+		return fmt.Errorf("shell %q has an invalid timeout %q", p.Name, *p.Timeout)
+	}
+
+	if _, err := p.GetUmask(); err != nil {
+		return fmt.Errorf("shell %q has an invalid umask %q", p.Name, *p.Umask)
+	}
+
 	return nil
 }
 
-func (p *Shell) Bake() {
-	var tmpFile = config.Registry.TempDir + fmt.Sprintf("/%x.sh", sha256.Sum256([]byte(p.Script)))[:14]
-	err := ioutil.WriteFile(tmpFile, []byte(p.Script), 0744)
-	if err != nil {
-		cli.Debug(cli.ERROR, fmt.Sprintf("Error writing script to %s", tmpFile), err)
+// GetInterpreter returns the command the script is passed to, defaulting
+// to bash
+func (p *Shell) GetInterpreter() []string {
+	if len(p.Interpreter) == 0 {
+		return shellInterpreters["bash"]
 	}
 
-	cli.Debug(cli.INFO, fmt.Sprintf("\t-> Running script %s", tmpFile), err)
+	if len(p.Interpreter) == 1 {
+		if interpreter, ok := shellInterpreters[p.Interpreter[0]]; ok {
+			return interpreter
+		}
+	}
 
-	var cmd = []string{
-		"/bin/bash",
-		"-c",
-		tmpFile}
+	return p.Interpreter
+}
 
-	var o *CommandResponse
-	if p.User != nil {
+// GetTimeout returns the timeout, given either as a duration such as "5m"
+// or a number of seconds
+func (p *Shell) GetTimeout() (time.Duration, error) {
+	return parseTimeout(p.Timeout)
+}
 
-		uid, gid, err := GetUIDAndGID(*p.User)
-		if err != nil {
-			cli.Debug(cli.ERROR, fmt.Sprintf("Error getting user data, %s", err), err)
-		}
+// parseTimeout parses a duration such as "5m", or a number of seconds
+func parseTimeout(timeout *string) (time.Duration, error) {
+	if timeout == nil {
+		return 0, nil
+	}
 
-		o, err = RunCommandAsUser(cmd, uid, gid)
-		if err != nil {
-			cli.Debug(cli.ERROR, fmt.Sprintf("Error running %s", tmpFile), err)
-		}
+	if seconds, err := strconv.Atoi(*timeout); err == nil {
+		return time.Duration(seconds) * time.Second, nil
+	}
 
-	} else {
+	return time.ParseDuration(*timeout)
+}
 
-		o, err = RunCommand(cmd)
-		if err != nil {
-			cli.Debug(cli.ERROR, fmt.Sprintf("Error running %s", tmpFile), err)
-		}
+// GetUmask returns the octal umask, if set
+func (p *Shell) GetUmask() (*os.FileMode, error) {
+	if p.Umask == nil {
+		return nil, nil
 	}
 
-	if len(o.String()) == 0 {
-		cli.Debug(cli.DEBUG, "\t-> ", o.ExitCode)
-		return
+	umask, err := strconv.ParseUint(*p.Umask, 8, 32)
+	if err != nil || umask > 0777 {
+		return nil, fmt.Errorf("invalid umask %q", *p.Umask)
 	}
-	cli.Debug(cli.DEBUG, "\t-> ", o.String())
+
+	var mode = os.FileMode(umask)
+	return &mode, nil
 }
 
-type CommandResponse struct {
-	Command  *exec.Cmd
-	Raw      string
-	Error    string
-	ExitCode int
+// GetReturns returns the exit codes which are considered successful,
+// defaulting to 0
+func (p *Shell) GetReturns() []int {
+	if len(p.Returns) == 0 {
+		return []int{0}
+	}
+
+	return p.Returns
 }
 
-// StreamCommand will stream the output of the command to the specified buffer with
-// or without a prefix
-func StreamCommand(prefix string, cmdArgs []string) error {
-	cmd := exec.Command(cmdArgs[0], cmdArgs[1:len(cmdArgs)]...)
-	var errStdout, errStderr error
-	stdoutIn, _ := cmd.StdoutPipe()
-	stderrIn, _ := cmd.StderrPipe()
-	cmd.Start()
+// Guarded returns the reason the script should not be run, when the path it
+// creates already exists or the path it removes is already gone
+func (p *Shell) Guarded() (string, bool) {
+	if p.Creates != nil && FileExists(*p.Creates) {
+		return fmt.Sprintf("%s already exists", *p.Creates), true
+	}
 
-	prefix = "\t"
+	if p.Removes != nil && !FileExists(*p.Removes) {
+		return fmt.Sprintf("%s does not exist", *p.Removes), true
+	}
 
-	go func() {
-		_, errStdout = copyOutputAndCapture(color.Output, stdoutIn, prefix, color.FgGreen)
-	}()
+	return "", false
+}
 
-	go func() {
-		_, errStderr = copyOutputAndCapture(color.Output, stderrIn, prefix, color.FgRed)
-	}()
+// Bake will run the script with the interpreter
+func (p *Shell) Bake() {
+	if reason, ok := p.Guarded(); ok {
+		cli.Debug(cli.INFO, "\t-> Skipping,", reason)
+		p.Unchanged()
+		return
+	}
 
-	err := cmd.Wait()
+	o, err := p.run()
+	p.response = o
 	if err != nil {
-		return fmt.Errorf("Failed to run command with error %s\n", err)
-	}
-	if errStdout != nil || errStderr != nil {
-		return fmt.Errorf("No output received\n")
+		cli.Debug(cli.ERROR, fmt.Sprintf("\t-> Error running %s", p.Name), err)
+		p.Fail(err)
+		return
 	}
 
-	fmt.Println()
-	return nil
+	cli.Debug(cli.DEBUG, "\t-> Exit code", o.ExitCode)
 }
 
-func copyOutputAndCapture(w io.Writer, r io.Reader, prefix string, c color.Attribute) ([]byte, error) {
-	var out []byte
-	buf := make([]byte, 1024, 1024)
-	for {
-		n, err := r.Read(buf[:])
-		if n > 0 {
-			d := buf[:n]
-			comp := color.New(color.FgBlue)
-			comp.Fprintf(w, "%s", prefix)
-
-			output := color.New(c)
-
-			out = append(out, d...)
-			_, err := output.Fprint(w, strings.TrimSpace(string(d)))
-			if err != nil {
-				return out, err
-			}
-		}
-		if err != nil {
-			// Read returns io.EOF at the end of file, which is not an error for us
-			if err == io.EOF {
-				err = nil
-			}
-			return out, err
-		}
-	}
+// Outputs returns the stdout, stderr and exit code of the script, which are
+// null until it has run
+func (p *Shell) Outputs() cty.Value {
+	return commandOutputs(p.response)
 }
 
-// TestRunCommandOutput used to evaluate a successful test
-var TestRunCommandOutput = `Result
-FOO:BAR`
+// commandOutputs returns the output of a command as values for the recipe
+func commandOutputs(o *CommandResponse) cty.Value {
+	if o == nil {
+		return cty.ObjectVal(map[string]cty.Value{
+			"stdout":    cty.NullVal(cty.String),
+			"stderr":    cty.NullVal(cty.String),
+			"exit_code": cty.NullVal(cty.Number),
+		})
+	}
 
-// RunCommandAsUser returns true if the audit passed, or command was successful
-func RunCommandAsUser(cmdArgs []string, uid, gid uint32) (*CommandResponse, error) {
-	var exitCode int
+	return cty.ObjectVal(map[string]cty.Value{
+		"stdout":    cty.StringVal(o.Stdout),
+		"stderr":    cty.StringVal(o.Stderr),
+		"exit_code": cty.NumberIntVal(int64(o.ExitCode)),
+	})
+}
 
-	if os.Getenv("GO_WANT_HELPER_PROCESS") == "1" {
-		return &CommandResponse{
-			Command: nil,
-			Raw:     TestRunCommandOutput,
-		}, nil
+// run writes the script to a temporary file only readable by the user
+// running it, and runs it, removing the file afterwards
+func (p *Shell) run() (*CommandResponse, error) {
+	timeout, err := p.GetTimeout()
+	if err != nil {
+		return nil, err
 	}
 
-	cmd := exec.Command(cmdArgs[0], cmdArgs[1:len(cmdArgs)]...)
-	cmd.SysProcAttr = &syscall.SysProcAttr{}
-	cmd.SysProcAttr.Credential = &syscall.Credential{Uid: uid, Gid: gid}
+	umask, err := p.GetUmask()
+	if err != nil {
+		return nil, err
+	}
 
-	res, err := cmd.CombinedOutput()
+	f, err := ioutil.TempFile(config.Registry.TempDir, "shell")
 	if err != nil {
-		// try to get the exit code
-		if exitError, ok := err.(*exec.ExitError); ok {
-			ws := exitError.Sys().(syscall.WaitStatus)
-			exitCode = ws.ExitStatus()
-		} else {
-			exitCode = 1
+		return nil, fmt.Errorf("Error creating script: %s", err)
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.WriteString(p.Script)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error writing script to %s: %s", f.Name(), err)
+	}
+
+	var interpreter = p.GetInterpreter()
+	var cmd = &Command{
+		Args:    append(interpreter[:len(interpreter):len(interpreter)], f.Name()),
+		Timeout: timeout,
+		Umask:   umask,
+		Stream:  p.Name,
+	}
+
+	if p.Cwd != nil {
+		cmd.Dir = *p.Cwd
+	}
+
+	cmd.Env = envList(p.Env)
+
+	if p.User != nil {
+		uid, gid, err := GetUIDAndGID(*p.User)
+		if err != nil {
+			return nil, fmt.Errorf("Error getting user data, %s", err)
 		}
-	} else {
-		// success, exitCode should be 0 if go is ok
-		ws := cmd.ProcessState.Sys().(syscall.WaitStatus)
-		exitCode = ws.ExitStatus()
+
+		if err := os.Chown(f.Name(), int(uid), int(gid)); err != nil {
+			return nil, err
+		}
+		cmd.Credential = &syscall.Credential{Uid: uid, Gid: gid}
 	}
 
-	return &CommandResponse{
-		Command:  cmd,
-		Raw:      strings.TrimSpace(string(res)),
-		ExitCode: exitCode,
-	}, err
+	cli.Debug(cli.INFO, fmt.Sprintf("\t-> Running script %s", f.Name()), nil)
+	o, err := Runner.Run(cmd)
+	if o == nil {
+		return o, err
+	}
+
+	if _, ok := err.(*TimeoutError); ok {
+		return o, err
+	}
+
+	for _, code := range p.GetReturns() {
+		if o.ExitCode == code {
+			return o, nil
+		}
+	}
+
+	if err == nil {
+		err = fmt.Errorf("exit status %d", o.ExitCode)
+	}
+
+	return o, err
 }
 
-// RunCommand returns true if the audit passed, or command was successful
-func RunCommand(cmdArgs []string) (*CommandResponse, error) {
-	var exitCode int
+type CommandResponse struct {
+	Command  *exec.Cmd
+	Raw      string
+	Stdout   string
+	Stderr   string
+	Error    string
+	ExitCode int
+}
 
-	if os.Getenv("GO_WANT_HELPER_PROCESS") == "1" {
-		return &CommandResponse{
-			Command: nil,
-			Raw:     TestRunCommandOutput,
-		}, nil
+// envList returns the environment as a list of KEY=value, sorted so the
+// command is the same on every run
+func envList(env map[string]string) []string {
+	var keys []string
+	for k := range env {
+		keys = append(keys, k)
 	}
+	sort.Strings(keys)
 
-	cmd := exec.Command(cmdArgs[0], cmdArgs[1:len(cmdArgs)]...)
-	res, err := cmd.CombinedOutput()
-	if err != nil {
-		// try to get the exit code
-		if exitError, ok := err.(*exec.ExitError); ok {
-			ws := exitError.Sys().(syscall.WaitStatus)
-			exitCode = ws.ExitStatus()
-		} else {
-			exitCode = 1
-		}
-	} else {
-		// success, exitCode should be 0 if go is ok
-		ws := cmd.ProcessState.Sys().(syscall.WaitStatus)
-		exitCode = ws.ExitStatus()
+	var list []string
+	for _, k := range keys {
+		list = append(list, k+"="+env[k])
 	}
 
-	return &CommandResponse{
-		Command:  cmd,
-		Raw:      strings.TrimSpace(string(res)),
-		ExitCode: exitCode,
-	}, err
+	return list
+}
+
+// TestRunCommandOutput used to evaluate a successful test
+var TestRunCommandOutput = `Result
+FOO:BAR`
+
+// RunCommandAsUser returns true if the audit passed, or command was successful
+func RunCommandAsUser(cmdArgs []string, uid, gid uint32) (*CommandResponse, error) {
+	return Runner.Run(&Command{
+		Args:       cmdArgs,
+		Credential: &syscall.Credential{Uid: uid, Gid: gid},
+	})
+}
+
+// RunCommand returns true if the audit passed, or command was successful
+func RunCommand(cmdArgs []string) (*CommandResponse, error) {
+	return Runner.Run(&Command{Args: cmdArgs})
 }
 
 func (r *CommandResponse) String() string {
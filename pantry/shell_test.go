@@ -1,8 +1,13 @@
 package pantry
 
 import (
+	"io/ioutil"
 	"os"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/mikemackintosh/bakery/config"
 )
 
 func init() {
@@ -84,3 +89,144 @@ func TestCommandResponseSplitColon(t *testing.T) {
 		t.Fatalf("want %s but got %s", testCommandResponseSplitColo.Expected, grep)
 	}
 }
+
+// shellFromHCL parses a shell block body for the tests
+func shellFromHCL(t *testing.T, src string) *Shell {
+	p := &Shell{}
+	p.Name = "test"
+	p.Config = testBody(t, src)
+	if err := p.Parse(nil); err != nil {
+		t.Fatalf("want no error but got %s", err)
+	}
+
+	return p
+}
+
+var testShellInterpreters = []struct {
+	Src      string
+	Expected string
+}{
+	{`script = "true"`, "/bin/bash"},
+	{`script = "true"
+	  interpreter = "sh"`, "/bin/sh"},
+	{`script = "print(1)"
+	  interpreter = "python3"`, "/usr/bin/env python3"},
+	{`script = "puts 1"
+	  interpreter = ["/usr/bin/ruby", "-w"]`, "/usr/bin/ruby -w"},
+	{`script = "true"
+	  interpreter = "/usr/local/bin/fish"`, "/usr/local/bin/fish"},
+}
+
+func TestShellInterpreter(t *testing.T) {
+	for _, test := range testShellInterpreters {
+		p := shellFromHCL(t, test.Src)
+		if got := strings.Join(p.GetInterpreter(), " "); got != test.Expected {
+			t.Errorf("want %s but got %s", test.Expected, got)
+		}
+	}
+}
+
+func TestShellParseInvalid(t *testing.T) {
+	for _, src := range []string{
+		`script = "true"
+		 timeout = "soon"`,
+		`script = "true"
+		 umask = "999"`,
+	} {
+		p := &Shell{}
+		p.Name = "test"
+		p.Config = testBody(t, src)
+		if err := p.Parse(nil); err == nil {
+			t.Errorf("want an error for %s", src)
+		}
+	}
+}
+
+func TestShellRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "shell")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	config.Registry.TempDir = dir
+
+	var script string
+	r, restore := useFakeRunner(map[string]fakeBinary{
+		"zsh": func(args []string) (string, int) {
+			b, _ := ioutil.ReadFile(args[0])
+			script = string(b)
+			return "", 3
+		},
+	})
+	defer restore()
+
+	p := shellFromHCL(t, `
+script = "exit 3"
+interpreter = "zsh"
+env = {
+  B = "2"
+  A = "1"
+}
+cwd = "/tmp"
+timeout = 90
+umask = "027"
+returns = [0, 3]
+`)
+
+	if _, err := p.run(); err != nil {
+		t.Fatalf("want no error but got %s", err)
+	}
+
+	if script != "exit 3" {
+		t.Errorf("want exit 3 but got %s", script)
+	}
+
+	c := r.Commands[0]
+	if c.Args[0] != "/bin/zsh" || !strings.HasPrefix(c.Args[1], dir) {
+		t.Errorf("want /bin/zsh <script> but got %s", c.Args)
+	}
+	if FileExists(c.Args[1]) {
+		t.Errorf("want %s removed", c.Args[1])
+	}
+	if strings.Join(c.Env, " ") != "A=1 B=2" {
+		t.Errorf("want A=1 B=2 but got %s", c.Env)
+	}
+	if c.Dir != "/tmp" || c.Timeout != 90*time.Second || *c.Umask != 027 {
+		t.Errorf("want /tmp, 1m30s and 027 but got %s, %s and %o", c.Dir, c.Timeout, *c.Umask)
+	}
+
+	p.Returns = nil
+	if _, err := p.run(); err == nil {
+		t.Errorf("want an error for exit status 3")
+	}
+}
+
+func TestShellGuarded(t *testing.T) {
+	f, err := ioutil.TempFile("", "shell")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	var name = f.Name()
+	var missing = name + ".missing"
+	var tests = []struct {
+		Creates *string
+		Removes *string
+		Guarded bool
+	}{
+		{nil, nil, false},
+		{&missing, nil, false},
+		{&name, nil, true},
+		{nil, &name, false},
+		{nil, &missing, true},
+	}
+
+	for _, test := range tests {
+		p := &Shell{Creates: test.Creates, Removes: test.Removes}
+		if _, guarded := p.Guarded(); guarded != test.Guarded {
+			t.Errorf("want %t but got %t", test.Guarded, guarded)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package pantry
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+
+	"github.com/mikemackintosh/bakery/cli"
+	"github.com/mikemackintosh/bakery/config"
+	homedir "github.com/mitchellh/go-homedir"
+)
+
+// FetchSource returns a local path for the source, downloading it to the temp
+// directory when it is remote. The checksum is verified when provided.
+func FetchSource(source string, checksum *string) (string, error) {
+	u, err := url.Parse(source)
+	if err != nil {
+		return "", fmt.Errorf("Error finding source %s: %s", source, err)
+	}
+
+	switch u.Scheme {
+	case ProtocolHTTP, ProtocolHTTPS:
+		cli.Debug(cli.DEBUG, "\t-> Using HTTP(s) source for download", nil)
+
+		tmpFile := config.Registry.TempDir + "/" + path.Base(u.Path)
+		if err := DownloadFile(source, tmpFile, checksum); err != nil {
+			return "", fmt.Errorf("Error downloading file %s: %s", source, err)
+		}
+		return tmpFile, nil
+	case "file":
+		source = u.Path
+	case "":
+	default:
+		return "", fmt.Errorf("Unsupported source %s", source)
+	}
+
+	local, err := homedir.Expand(source)
+	if err != nil {
+		return "", err
+	}
+
+	if want := checksumString(checksum); len(want) > 0 {
+		have, err := FileChecksum(local)
+		if err != nil {
+			return "", err
+		}
+
+		if have != want {
+			return "", fmt.Errorf("Failed to validate file. Want %s but have %s", want, have)
+		}
+	}
+
+	return local, nil
+}
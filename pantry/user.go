@@ -44,7 +44,7 @@ func GetUIDAndGID(name string) (uint32, uint32, error) {
 
 	if name == "self" {
 		rawUID = os.Getenv("SUDO_UID")
-		rawGID = os.Getenv("SUDO_UID")
+		rawGID = os.Getenv("SUDO_GID")
 	} else {
 		var u *user.User
 		u, err := user.Lookup(name)
@@ -60,3 +60,20 @@ func GetUIDAndGID(name string) (uint32, uint32, error) {
 	parsedGID, _ := strconv.ParseUint(rawGID, 10, 64)
 	return uint32(parsedUID), uint32(parsedGID), nil
 }
+
+// GetUserHome returns the home directory of the passed user
+func GetUserHome(name string) (string, error) {
+	if name == "self" {
+		name = os.Getenv("SUDO_USER")
+		if len(name) == 0 {
+			return os.UserHomeDir()
+		}
+	}
+
+	u, err := user.Lookup(name)
+	if err != nil {
+		return "", err
+	}
+
+	return u.HomeDir, nil
+}
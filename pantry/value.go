@@ -0,0 +1,79 @@
+package pantry
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/zclconf/go-cty/cty"
+)
+
+// ToValue converts a decoded document, such as JSON, YAML or a property
+// list, into a value which can be used in the recipe. Maps become objects
+// and lists become tuples, so their elements can be of different types.
+func ToValue(v interface{}) (cty.Value, error) {
+	switch v := v.(type) {
+	case nil:
+		return cty.NullVal(cty.DynamicPseudoType), nil
+	case string:
+		return cty.StringVal(v), nil
+	case bool:
+		return cty.BoolVal(v), nil
+	case int:
+		return cty.NumberIntVal(int64(v)), nil
+	case int64:
+		return cty.NumberIntVal(v), nil
+	case uint64:
+		return cty.NumberUIntVal(v), nil
+	case float64:
+		return cty.NumberFloatVal(v), nil
+	case json.Number:
+		return cty.ParseNumberVal(v.String())
+	case time.Time:
+		return cty.StringVal(v.Format(time.RFC3339)), nil
+	case []byte:
+		return cty.StringVal(string(v)), nil
+	case []interface{}:
+		var list []cty.Value
+		for _, e := range v {
+			val, err := ToValue(e)
+			if err != nil {
+				return cty.NilVal, err
+			}
+			list = append(list, val)
+		}
+		if len(list) == 0 {
+			return cty.EmptyTupleVal, nil
+		}
+		return cty.TupleVal(list), nil
+	case map[string]interface{}:
+		var obj = map[string]cty.Value{}
+		for k, e := range v {
+			val, err := ToValue(e)
+			if err != nil {
+				return cty.NilVal, err
+			}
+			obj[k] = val
+		}
+		return cty.ObjectVal(obj), nil
+	case map[interface{}]interface{}:
+		// YAML keys can be of any type, but object attributes are strings
+		var m = map[string]interface{}{}
+		for k, e := range v {
+			key := fmt.Sprint(k)
+			if _, ok := m[key]; ok {
+				return cty.NilVal, fmt.Errorf("duplicate key %q", key)
+			}
+			m[key] = e
+		}
+		return ToValue(m)
+	case map[string]string:
+		var obj = map[string]cty.Value{}
+		for k, e := range v {
+			obj[k] = cty.StringVal(e)
+		}
+		return cty.ObjectVal(obj), nil
+	}
+
+	return cty.NilVal, fmt.Errorf("unsupported value of type %T", v)
+}
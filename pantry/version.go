@@ -0,0 +1,78 @@
+package pantry
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// CompareVersions compares two version strings, returning -1 if a is older
+// than b, 1 if a is newer than b, and 0 if they are the same. Numeric parts
+// are compared numerically, and any other parts alphabetically.
+func CompareVersions(a, b string) int {
+	partsA := versionParts(a)
+	partsB := versionParts(b)
+
+	for i := 0; i < len(partsA) || i < len(partsB); i++ {
+		var partA, partB = "0", "0"
+		if i < len(partsA) {
+			partA = partsA[i]
+		}
+		if i < len(partsB) {
+			partB = partsB[i]
+		}
+
+		numA, errA := strconv.ParseUint(partA, 10, 64)
+		numB, errB := strconv.ParseUint(partB, 10, 64)
+		switch {
+		case errA == nil && errB == nil:
+			if numA != numB {
+				if numA < numB {
+					return -1
+				}
+				return 1
+			}
+		case errA == nil:
+			// Numbers are newer than pre-release labels, eg: 1.0 > 1.0b1
+			return 1
+		case errB == nil:
+			return -1
+		default:
+			if c := strings.Compare(partA, partB); c != 0 {
+				return c
+			}
+		}
+	}
+
+	return 0
+}
+
+// versionParts splits a version into its numeric and alphabetic parts,
+// eg: v1.2.0b3 becomes 1, 2, 0, b, 3
+func versionParts(version string) []string {
+	version = strings.TrimPrefix(strings.TrimSpace(version), "v")
+
+	var parts []string
+	var current []rune
+	for _, r := range version {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			if len(current) > 0 {
+				parts = append(parts, string(current))
+			}
+			current = nil
+			continue
+		}
+
+		if len(current) > 0 && unicode.IsDigit(r) != unicode.IsDigit(current[0]) {
+			parts = append(parts, string(current))
+			current = nil
+		}
+		current = append(current, r)
+	}
+
+	if len(current) > 0 {
+		parts = append(parts, string(current))
+	}
+
+	return parts
+}
@@ -0,0 +1,26 @@
+package pantry
+
+import "testing"
+
+var testCompareVersions = []struct {
+	A, B string
+	Want int
+}{
+	{"1.0", "1.0", 0},
+	{"1.0", "1.0.0", 0},
+	{"v1.2.3", "1.2.3", 0},
+	{"1.2", "1.10", -1},
+	{"2.0.1", "2.0", 1},
+	{"1.0b1", "1.0", -1},
+	{"1.0b2", "1.0b1", 1},
+	{"80.0.3987.149", "80.0.3987.132", 1},
+	{"1.5-12.el8", "1.6", -1},
+}
+
+func TestCompareVersions(t *testing.T) {
+	for _, test := range testCompareVersions {
+		if got := CompareVersions(test.A, test.B); got != test.Want {
+			t.Errorf("want %d comparing %s to %s but got %d", test.Want, test.A, test.B, got)
+		}
+	}
+}
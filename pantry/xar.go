@@ -0,0 +1,272 @@
+package pantry
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/zlib"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+)
+
+// xarMagic is the magic number at the start of every xar archive, "xar!"
+const xarMagic = 0x78617221
+
+// maxXarTOCSize limits the size of the table of contents which is read,
+// before and after it is decompressed
+const maxXarTOCSize = 16 << 20
+
+// xar checksum algorithms
+const (
+	xarChecksumNone = 0
+	xarChecksumSHA1 = 1
+	xarChecksumMD5  = 2
+)
+
+// xarHeader is the fixed size header of a xar archive
+type xarHeader struct {
+	Magic                 uint32
+	HeaderSize            uint16
+	Version               uint16
+	TOCLengthCompressed   uint64
+	TOCLengthUncompressed uint64
+	ChecksumAlgorithm     uint32
+}
+
+// xarTOC is the table of contents of a xar archive
+type xarTOC struct {
+	Checksum struct {
+		Offset int64 `xml:"offset"`
+		Size   int64 `xml:"size"`
+	} `xml:"toc>checksum"`
+	Files []xarTOCFile `xml:"toc>file"`
+}
+
+// xarTOCFile is a file or directory in the table of contents
+type xarTOCFile struct {
+	Name string `xml:"name"`
+	Type string `xml:"type"`
+	Data *struct {
+		Length   int64 `xml:"length"`
+		Offset   int64 `xml:"offset"`
+		Size     int64 `xml:"size"`
+		Encoding struct {
+			Style string `xml:"style,attr"`
+		} `xml:"encoding"`
+	} `xml:"data"`
+	Files []xarTOCFile `xml:"file"`
+}
+
+// XarFile is a file within a xar archive
+type XarFile struct {
+	Path     string
+	Type     string
+	Offset   int64
+	Length   int64
+	Size     int64
+	Encoding string
+}
+
+// Xar is a xar archive, the container format used by flat packages
+type Xar struct {
+	r          io.ReaderAt
+	closer     io.Closer
+	size       int64
+	heapOffset int64
+	Files      []*XarFile
+}
+
+// OpenXar opens the xar archive at the path
+func OpenXar(name string) (*Xar, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	x, err := NewXar(f, info.Size())
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	x.closer = f
+
+	return x, nil
+}
+
+// NewXar reads the header and table of contents of a xar archive of the
+// size in bytes
+func NewXar(r io.ReaderAt, size int64) (*Xar, error) {
+	var header xarHeader
+	err := binary.Read(io.NewSectionReader(r, 0, 28), binary.BigEndian, &header)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading xar header: %s", err)
+	}
+
+	if header.Magic != xarMagic {
+		return nil, fmt.Errorf("Not a xar archive")
+	}
+
+	// The sizes in the header are checked before anything is allocated
+	// from them, so a truncated or crafted archive is an error
+	if header.HeaderSize < 28 {
+		return nil, fmt.Errorf("Invalid xar header size %d", header.HeaderSize)
+	}
+	if header.TOCLengthCompressed > maxXarTOCSize || int64(header.HeaderSize)+int64(header.TOCLengthCompressed) > size {
+		return nil, fmt.Errorf("Invalid xar table of contents length %d", header.TOCLengthCompressed)
+	}
+
+	compressed := make([]byte, header.TOCLengthCompressed)
+	if _, err := r.ReadAt(compressed, int64(header.HeaderSize)); err != nil {
+		return nil, fmt.Errorf("Error reading xar table of contents: %s", err)
+	}
+
+	zr, err := zlib.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, fmt.Errorf("Error decompressing xar table of contents: %s", err)
+	}
+	defer zr.Close()
+
+	var toc xarTOC
+	if err := xml.NewDecoder(io.LimitReader(zr, maxXarTOCSize)).Decode(&toc); err != nil {
+		return nil, fmt.Errorf("Error parsing xar table of contents: %s", err)
+	}
+
+	x := &Xar{
+		r:          r,
+		size:       size,
+		heapOffset: int64(header.HeaderSize) + int64(header.TOCLengthCompressed),
+	}
+
+	if err := x.verifyTOC(header.ChecksumAlgorithm, compressed, toc.Checksum.Offset, toc.Checksum.Size); err != nil {
+		return nil, err
+	}
+
+	x.addFiles("", toc.Files)
+	return x, nil
+}
+
+// verifyTOC compares the checksum of the compressed table of contents with
+// the checksum stored in the heap
+func (x *Xar) verifyTOC(algorithm uint32, compressed []byte, offset, size int64) error {
+	var h hash.Hash
+	switch algorithm {
+	case xarChecksumNone:
+		return nil
+	case xarChecksumSHA1:
+		h = sha1.New()
+	case xarChecksumMD5:
+		h = md5.New()
+	default:
+		return fmt.Errorf("Unsupported xar checksum algorithm %d", algorithm)
+	}
+
+	if size != int64(h.Size()) || offset < 0 || x.heapOffset+offset+size > x.size {
+		return fmt.Errorf("Invalid xar checksum of %d bytes at %d", size, offset)
+	}
+
+	want := make([]byte, size)
+	if _, err := x.r.ReadAt(want, x.heapOffset+offset); err != nil {
+		return fmt.Errorf("Error reading xar checksum: %s", err)
+	}
+
+	h.Write(compressed)
+	if !bytes.Equal(h.Sum(nil), want) {
+		return fmt.Errorf("Invalid xar table of contents checksum")
+	}
+
+	return nil
+}
+
+// addFiles flattens the table of contents into a list of files
+func (x *Xar) addFiles(dir string, files []xarTOCFile) {
+	for _, f := range files {
+		file := &XarFile{
+			Path: path.Join(dir, f.Name),
+			Type: f.Type,
+		}
+
+		if f.Data != nil {
+			file.Offset = f.Data.Offset
+			file.Length = f.Data.Length
+			file.Size = f.Data.Size
+			file.Encoding = f.Data.Encoding.Style
+		}
+
+		x.Files = append(x.Files, file)
+		x.addFiles(file.Path, f.Files)
+	}
+}
+
+// File returns the file at the path within the archive
+func (x *Xar) File(name string) *XarFile {
+	for _, f := range x.Files {
+		if f.Path == name {
+			return f
+		}
+	}
+
+	return nil
+}
+
+// ReadFile returns the decoded contents of the file at the path
+func (x *Xar) ReadFile(name string) ([]byte, error) {
+	f := x.File(name)
+	if f == nil {
+		return nil, fmt.Errorf("%s not found in xar archive", name)
+	}
+
+	if f.Offset < 0 || f.Length < 0 || x.heapOffset+f.Offset+f.Length > x.size {
+		return nil, fmt.Errorf("Invalid xar data of %d bytes at %d for %s", f.Length, f.Offset, name)
+	}
+
+	var r io.Reader = io.NewSectionReader(x.r, x.heapOffset+f.Offset, f.Length)
+	switch f.Encoding {
+	case "", "application/octet-stream":
+		return ioutil.ReadAll(r)
+	case "application/x-gzip":
+		zr, err := zlib.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		r = zr
+	case "application/x-bzip2":
+		r = bzip2.NewReader(r)
+	default:
+		return nil, fmt.Errorf("Unsupported xar encoding %s for %s", f.Encoding, name)
+	}
+
+	// Compressed data is read up to its declared size, so an archive cannot
+	// expand into more than it claims
+	b, err := ioutil.ReadAll(io.LimitReader(r, f.Size+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > f.Size {
+		return nil, fmt.Errorf("Invalid xar data for %s, which is larger than its size of %d bytes", name, f.Size)
+	}
+
+	return b, nil
+}
+
+// Close closes the underlying archive file when opened with OpenXar
+func (x *Xar) Close() error {
+	if x.closer != nil {
+		return x.closer.Close()
+	}
+
+	return nil
+}
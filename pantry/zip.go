@@ -6,16 +6,13 @@ import (
 	"archive/zip"
 	"fmt"
 	"io"
-	"net/url"
 	"os"
-	"path"
 	"path/filepath"
 	"strings"
 
 	"github.com/hashicorp/hcl2/hcl"
 	"github.com/hashicorp/hcl2/hcldec"
 	"github.com/mikemackintosh/bakery/cli"
-	"github.com/mikemackintosh/bakery/config"
 	"github.com/zclconf/go-cty/cty"
 )
 
@@ -67,30 +64,17 @@ func (p *Zip) Parse(evalContext *hcl.EvalContext) error {
 
 // Bake will action the configuration
 func (p *Zip) Bake() {
-	u, err := url.Parse(p.Source)
+	tmpFile, err := FetchSource(p.Source, p.Checksum)
 	if err != nil {
-		cli.Debug(cli.ERROR, fmt.Sprintf("Error finding source %s", p.Source), err)
-	}
-
-	var tmpFile string
-	if u.Scheme == ProtocolHTTP || u.Scheme == ProtocolHTTPS {
-		cli.Debug(cli.DEBUG, "\t-> Using HTTP(s) source for download", nil)
-
-		urlParse, urlErr := url.Parse(p.Source)
-		if err != nil {
-			cli.Debug(cli.INFO, fmt.Sprintf("Error finding source %s", p.Source), urlErr)
-		}
-
-		tmpFile = config.Registry.TempDir + "/" + path.Base(urlParse.Path)
-		urlErr = DownloadFile(p.Source, tmpFile, p.Checksum)
-		if urlErr != nil {
-			cli.Debug(cli.INFO, fmt.Sprintf("Error downloading file %s", p.Source), urlErr)
-		}
+		cli.Debug(cli.ERROR, "\t-> Error fetching source:", err)
+		p.Fail(err)
+		return
 	}
 
 	_, err = Unzip(tmpFile, p.Destination)
 	if err != nil {
 		cli.Debug(cli.ERROR, "\t-> Error unzipping file:", err)
+		p.Fail(err)
 	}
 }
 
@@ -0,0 +1,113 @@
+package recipe
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/hcl2/hcl"
+	"github.com/hashicorp/hcl2/hcl/hclsyntax"
+	"github.com/mikemackintosh/bakery/pantry"
+)
+
+// resourceType is a composite resource type declared with a resource_type
+// block, and the scope it was declared in
+type resourceType struct {
+	block *hcl.Block
+	scope *scope
+}
+
+// resourceTypeSchema finds the resource_type blocks of a body
+var resourceTypeSchema = &hcl.BodySchema{
+	Blocks: []hcl.BlockHeaderSchema{{Type: "resource_type", LabelNames: []string{"name"}}},
+}
+
+// addResourceType declares a composite resource type, which can be used in
+// the scope like any other resource type
+func (r *Recipe) addResourceType(s *scope, block *hcl.Block) hcl.Diagnostics {
+	var name = block.Labels[0]
+	if existing, ok := s.resourceTypes[name]; ok {
+		return hcl.Diagnostics{{
+			Severity: hcl.DiagError,
+			Summary:  "Duplicate resource type",
+			Detail:   fmt.Sprintf("The resource type %q was already declared at %s.", name, existing.block.DefRange),
+			Subject:  block.DefRange.Ptr(),
+		}}
+	}
+
+	var reserved = pantry.IsResourceType(name) || !hclsyntax.ValidIdentifier(name)
+	for _, b := range schema().Blocks {
+		reserved = reserved || b.Type == name
+	}
+	if reserved {
+		return hcl.Diagnostics{{
+			Severity: hcl.DiagError,
+			Summary:  "Invalid resource type",
+			Detail:   fmt.Sprintf("%q cannot be used as the name of a resource type.", name),
+			Subject:  block.LabelRanges[0].Ptr(),
+		}}
+	}
+
+	s.resourceTypes[name] = &resourceType{block: block, scope: s}
+	return nil
+}
+
+// addComposite adds a block of a composite resource type, which is expanded
+// into the blocks of the resource type like a module. The attributes of the
+// block are the values of the resource type's variables, and depends_on
+// applies to each of its blocks.
+func (r *Recipe) addComposite(s *scope, block *hcl.Block, t *resourceType) hcl.Diagnostics {
+	content, inputs, diags := block.Body.PartialContent(&hcl.BodySchema{
+		Attributes: []hcl.AttributeSchema{{Name: "depends_on"}, {Name: "for_each"}, {Name: "count"}},
+	})
+	if diags.HasErrors() {
+		return diags
+	}
+
+	for _, name := range []string{"for_each", "count"} {
+		if attr, ok := content.Attributes[name]; ok {
+			return hcl.Diagnostics{{
+				Severity: hcl.DiagError,
+				Summary:  fmt.Sprintf("Invalid %s argument", name),
+				Detail:   fmt.Sprintf("Blocks of the resource type %q cannot use %s, use it on the blocks within the resource type instead.", block.Type, name),
+				Subject:  attr.NameRange.Ptr(),
+			}}
+		}
+	}
+
+	// A resource type cannot use itself, as it would never stop expanding
+	if r.expanding[t] {
+		return hcl.Diagnostics{{
+			Severity: hcl.DiagError,
+			Summary:  "Recursive resource type",
+			Detail:   fmt.Sprintf("The resource type %q is used within itself.", block.Type),
+			Subject:  block.DefRange.Ptr(),
+		}}
+	}
+	if r.expanding == nil {
+		r.expanding = map[*resourceType]bool{}
+	}
+	r.expanding[t] = true
+	defer delete(r.expanding, t)
+
+	m := &Node{
+		Address:        block.Type + "." + block.Labels[0],
+		Type:           block.Type,
+		Name:           block.Labels[0],
+		Range:          block.DefRange,
+		block:          block,
+		group:          true,
+		groupDependsOn: content.Attributes["depends_on"],
+	}
+
+	// The blocks of the resource type can use the resource types of the
+	// scope it was declared in, and reference its name as self.name
+	scopeFor := func(prefix, dir string) *scope {
+		ms := newScope(prefix, dir)
+		for name, rt := range t.scope.resourceTypes {
+			ms.resourceTypes[name] = rt
+		}
+		ms.self = m.Name
+		return ms
+	}
+
+	return r.addGroup(s, block, m, scopeFor, t.scope.dir, t.block.Body, inputs)
+}
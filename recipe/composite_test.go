@@ -0,0 +1,157 @@
+package recipe
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/mikemackintosh/bakery/config"
+	"github.com/mikemackintosh/bakery/pantry"
+)
+
+var testResourceType = `
+resource_type "mac_app" {
+  variable "source" {
+    type = string
+  }
+
+  variable "checksum" {
+    type = string
+  }
+
+  zip "download" {
+    source      = var.source
+    checksum    = var.checksum
+    destination = "/Applications"
+    not_if      = "test -d '/Applications/${self.name}.app'"
+  }
+
+  shell "register" {
+    script     = "register ${self.name}"
+    depends_on = "download"
+  }
+
+  output "path" {
+    value = "/Applications/${self.name}.app"
+  }
+}
+`
+
+func TestResourceType(t *testing.T) {
+	r, diags := testLoad(testResourceType + `
+shell "before" { script = "before" }
+
+mac_app "Dash" {
+  source     = "https://example.com/Dash.zip"
+  checksum   = "abc"
+  depends_on = "before"
+}
+
+shell "after" { script = mac_app.Dash.path }
+`)
+	if diags.HasErrors() {
+		t.Fatalf("want no error but got %s", diags)
+	}
+
+	want := "shell.before mac_app.Dash.var.source mac_app.Dash.var.checksum mac_app.Dash.zip.download mac_app.Dash.shell.register mac_app.Dash.path shell.after"
+	if got := addresses(r.Runlist); got != want {
+		t.Errorf("want %s but got %s", want, got)
+	}
+
+	for _, n := range r.Runlist {
+		if n.Address == "mac_app.Dash.zip.download" && addresses(n.DependsOn) != "shell.before mac_app.Dash.var.source mac_app.Dash.var.checksum" {
+			t.Errorf("want the zip to depend on its inputs and shell.before but got %s", addresses(n.DependsOn))
+		}
+	}
+}
+
+func TestRunResourceType(t *testing.T) {
+	dir, err := ioutil.TempDir("", "recipe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	config.Registry.TempDir = dir
+
+	previous := pantry.Runner
+	pantry.Runner = scriptRunner{}
+	defer func() {
+		pantry.Runner = previous
+	}()
+
+	r, diags := testLoad(`
+resource_type "greeting" {
+  variable "times" {
+    type    = number
+    default = "2"
+  }
+
+  shell "greet" {
+    script = "hello ${self.name} ${var.times + 1}"
+  }
+
+  output "said" {
+    value = shell.greet.stdout
+  }
+}
+
+greeting "mike" {}
+
+greeting "sam" {
+  times = 5
+}
+
+shell "report" { script = "${greeting.mike.said}, ${greeting.sam.said}" }
+`)
+	if diags.HasErrors() {
+		t.Fatalf("want no error but got %s", diags)
+	}
+	if err := r.Run(); err != nil {
+		t.Fatalf("want no error but got %s", err)
+	}
+
+	got := r.EvalContext().Variables["shell"].GetAttr("report").GetAttr("stdout").AsString()
+	if got != "hello mike 3, hello sam 6" {
+		t.Errorf("want hello mike 3, hello sam 6 but got %s", got)
+	}
+}
+
+var testResourceTypeErrors = []struct {
+	Src      string
+	Expected string
+}{
+	{`resource_type "shell" {}`, `"shell" cannot be used as the name of a resource type`},
+	{`resource_type "a" {}
+	  resource_type "a" {}`, `The resource type "a" was already declared at test.yum:1`},
+	{`resource_type "a" {
+	    a "inner" {}
+	  }
+	  a "outer" {}`, `The resource type "a" is used within itself`},
+	{`resource_type "a" {}
+	  a "x" { count = 2 }`, "cannot use count"},
+	{`resource_type "a" {}
+	  a "x" { nope = 1 }`, `The a "x" has no variable "nope"`},
+	{`resource_type "a" {
+	    variable "port" { type = number }
+	  }
+	  a "x" {}`, `The a "x" requires the variable "port"`},
+	{`resource_type "a" {
+	    variable "port" { type = number }
+	    shell "s" { script = "${var.port}" }
+	  }
+	  a "x" { port = "ssh" }`, "The value of var.port must be number"},
+	{`variable "port" {
+	    type    = number
+	    default = "ssh"
+	  }`, "The value of var.port must be number"},
+}
+
+func TestResourceTypeErrors(t *testing.T) {
+	for _, test := range testResourceTypeErrors {
+		_, diags := testLoad(test.Src)
+		if !diags.HasErrors() || !strings.Contains(diags.Error(), test.Expected) {
+			t.Errorf("want %s but got %s", test.Expected, diags)
+		}
+	}
+}
@@ -0,0 +1,489 @@
+package recipe
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl2/hcl"
+	"github.com/hashicorp/hcl2/hcl/hclsyntax"
+	hcljson "github.com/hashicorp/hcl2/hcl/json"
+	"github.com/hashicorp/hcl2/hclwrite"
+	"github.com/zclconf/go-cty/cty"
+	ctyjson "github.com/zclconf/go-cty/cty/json"
+)
+
+// jsonObject is a JSON object which keeps the order of its properties, and
+// can repeat a property, as recipes can repeat a block type
+type jsonObject []jsonProperty
+
+// jsonProperty is a property of a JSON object
+type jsonProperty struct {
+	Key   string
+	Value interface{}
+}
+
+// HCLToJSON converts a native recipe into a JSON recipe with the same blocks,
+// in the same order. Expressions which are not literal values are kept as
+// JSON templates, such as "${var.name}".
+func HCLToJSON(src []byte, filename string) ([]byte, hcl.Diagnostics) {
+	file, diags := hclsyntax.ParseConfig(src, filename, hcl.Pos{Line: 1, Column: 1})
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	var buf bytes.Buffer
+	writeJSON(&buf, bodyJSON(file.Body.(*hclsyntax.Body), "", src), "")
+	buf.WriteString("\n")
+
+	return buf.Bytes(), nil
+}
+
+// bodyJSON converts the attributes and blocks of a body, in the order they
+// were declared. The type of a variable is kept as its source, which JSON
+// recipes use for types.
+func bodyJSON(body *hclsyntax.Body, blockType string, src []byte) jsonObject {
+	type item struct {
+		start int
+		prop  jsonProperty
+	}
+
+	var items []item
+	for _, attr := range body.Attributes {
+		var value interface{} = exprJSON(attr.Expr, src)
+		if blockType == "variable" && attr.Name == "type" {
+			b, _ := json.Marshal(string(attr.Expr.Range().SliceBytes(src)))
+			value = json.RawMessage(b)
+		}
+		items = append(items, item{attr.SrcRange.Start.Byte, jsonProperty{attr.Name, value}})
+	}
+
+	for _, block := range body.Blocks {
+		var value interface{} = bodyJSON(block.Body, block.Type, src)
+		for i := len(block.Labels) - 1; i >= 0; i-- {
+			value = jsonObject{{block.Labels[i], value}}
+		}
+		items = append(items, item{block.TypeRange.Start.Byte, jsonProperty{block.Type, value}})
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].start < items[j].start
+	})
+
+	var out = jsonObject{}
+	for _, i := range items {
+		out = append(out, i.prop)
+	}
+
+	return out
+}
+
+// exprJSON converts an expression into a JSON value. Literal values are kept
+// as they are, and other expressions become templates of their source.
+func exprJSON(expr hclsyntax.Expression, src []byte) interface{} {
+	source := string(expr.Range().SliceBytes(src))
+
+	switch e := expr.(type) {
+	case *hclsyntax.TemplateWrapExpr:
+		return templateJSON(string(e.Wrapped.Range().SliceBytes(src)))
+	case *hclsyntax.TemplateExpr:
+		if out, ok := rebuildTemplate(e.Parts, src, func(s string) string { return s }); ok {
+			b, _ := json.Marshal(out)
+			return json.RawMessage(b)
+		}
+
+		// A quoted template uses the same escapes as a JSON string
+		if strings.HasPrefix(source, `"`) && json.Valid([]byte(source)) {
+			return json.RawMessage(source)
+		}
+	case *hclsyntax.TupleConsExpr:
+		var out = []interface{}{}
+		for _, v := range e.Exprs {
+			out = append(out, exprJSON(v, src))
+		}
+		return out
+	case *hclsyntax.ObjectConsExpr:
+		var out = jsonObject{}
+		for _, item := range e.Items {
+			key, ok := objectKey(item.KeyExpr)
+			if !ok {
+				return templateJSON(source)
+			}
+			out = append(out, jsonProperty{key, exprJSON(item.ValueExpr, src)})
+		}
+		return out
+	}
+
+	if len(expr.Variables()) == 0 {
+		if val, diags := expr.Value(nil); !diags.HasErrors() && val.IsWhollyKnown() {
+			if b, err := ctyjson.Marshal(val, val.Type()); err == nil {
+				return json.RawMessage(escapeTemplates(string(b)))
+			}
+		}
+	}
+
+	return templateJSON(source)
+}
+
+// rebuildTemplate rebuilds the source of a template from its literal and
+// interpolated parts, quoting the literal parts. It returns false when the
+// template uses directives or strip markers, which are not rebuilt.
+func rebuildTemplate(parts []hclsyntax.Expression, src []byte, quote func(string) string) (string, bool) {
+	var out string
+	for _, part := range parts {
+		if lit, ok := part.(*hclsyntax.LiteralValueExpr); ok && lit.Val.Type() == cty.String {
+			out += quote(escapeTemplates(lit.Val.AsString()))
+			continue
+		}
+
+		r := part.Range()
+		if r.Start.Byte < 2 || r.End.Byte >= len(src) || string(src[r.Start.Byte-2:r.Start.Byte]) != "${" || src[r.End.Byte] != '}' {
+			return "", false
+		}
+		out += "${" + string(r.SliceBytes(src)) + "}"
+	}
+
+	return out, true
+}
+
+// objectKey returns the key of an object item which is a name or a literal
+func objectKey(expr hclsyntax.Expression) (string, bool) {
+	if k, ok := expr.(*hclsyntax.ObjectConsKeyExpr); ok {
+		if name := hcl.ExprAsKeyword(k.Wrapped); name != "" {
+			return name, true
+		}
+	}
+
+	val, diags := expr.Value(nil)
+	if diags.HasErrors() || !val.IsKnown() || val.IsNull() || val.Type() != cty.String {
+		return "", false
+	}
+
+	return val.AsString(), true
+}
+
+// templateJSON returns an interpolation of the source as a JSON string
+func templateJSON(source string) json.RawMessage {
+	b, _ := json.Marshal("${" + source + "}")
+	return json.RawMessage(b)
+}
+
+// escapeTemplates escapes the template sequences in a JSON value, so strings
+// are not evaluated as templates
+func escapeTemplates(s string) string {
+	return strings.NewReplacer("${", "$${", "%{", "%%{").Replace(s)
+}
+
+// writeJSON writes a JSON value, indenting objects and arrays
+func writeJSON(buf *bytes.Buffer, v interface{}, indent string) {
+	switch v := v.(type) {
+	case jsonObject:
+		if len(v) == 0 {
+			buf.WriteString("{}")
+			return
+		}
+
+		buf.WriteString("{\n")
+		for i, p := range v {
+			key, _ := json.Marshal(p.Key)
+			buf.WriteString(indent + "  " + string(key) + ": ")
+			writeJSON(buf, p.Value, indent+"  ")
+			if i < len(v)-1 {
+				buf.WriteString(",")
+			}
+			buf.WriteString("\n")
+		}
+		buf.WriteString(indent + "}")
+	case []interface{}:
+		if len(v) == 0 {
+			buf.WriteString("[]")
+			return
+		}
+
+		buf.WriteString("[\n")
+		for i, e := range v {
+			buf.WriteString(indent + "  ")
+			writeJSON(buf, e, indent+"  ")
+			if i < len(v)-1 {
+				buf.WriteString(",")
+			}
+			buf.WriteString("\n")
+		}
+		buf.WriteString(indent + "]")
+	case json.RawMessage:
+		buf.Write(v)
+	}
+}
+
+// JSONToHCL converts a JSON recipe into a native recipe with the same blocks,
+// in the same order. Strings which are a single interpolation, such as
+// "${var.name}", become the expression they interpolate.
+func JSONToHCL(src []byte, filename string) ([]byte, hcl.Diagnostics) {
+	if _, diags := hcljson.Parse(src, filename); diags.HasErrors() {
+		return nil, diags
+	}
+
+	value, err := decodeJSON(json.NewDecoder(bytes.NewReader(src)))
+	if err != nil {
+		return nil, hcl.Diagnostics{{
+			Severity: hcl.DiagError,
+			Summary:  "Invalid JSON recipe",
+			Detail:   err.Error(),
+		}}
+	}
+
+	root, ok := value.(jsonObject)
+	if !ok {
+		return nil, hcl.Diagnostics{{
+			Severity: hcl.DiagError,
+			Summary:  "Invalid JSON recipe",
+			Detail:   "The root of a JSON recipe must be an object.",
+		}}
+	}
+
+	var labels = map[string]int{}
+	for _, b := range schema().Blocks {
+		labels[b.Type] = len(b.LabelNames)
+	}
+
+	// Composite resource types are blocks with a name, like any other
+	// resource type
+	for _, p := range root {
+		if types, ok := p.Value.(jsonObject); ok && p.Key == "resource_type" {
+			for _, t := range types {
+				labels[t.Key] = 1
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := writeBody(&buf, root, "", labels); err != nil {
+		return nil, hcl.Diagnostics{{
+			Severity: hcl.DiagError,
+			Summary:  "Invalid JSON recipe",
+			Detail:   err.Error(),
+		}}
+	}
+
+	return hclwrite.Format(buf.Bytes()), nil
+}
+
+// writeBody writes the attributes and blocks of a JSON body. The recipe and
+// resource types contain blocks, and other blocks only contain attributes.
+func writeBody(buf *bytes.Buffer, obj jsonObject, blockType string, labels map[string]int) error {
+	for _, p := range obj {
+		if n, isBlock := labels[p.Key]; isBlock && (blockType == "" || blockType == "resource_type") {
+			if err := writeBlocks(buf, p.Key, nil, n, p.Value, labels); err != nil {
+				return err
+			}
+			continue
+		}
+
+		var expr = hclExpr(p.Value)
+		if s, ok := p.Value.(string); ok && blockType == "variable" && p.Key == "type" {
+			expr = s
+		}
+		fmt.Fprintf(buf, "%s = %s\n", hclKey(p.Key), expr)
+	}
+
+	return nil
+}
+
+// writeBlocks writes the blocks of a JSON block value, which is an object of
+// labels until all of the labels of the block type are known, or an array of
+// such objects
+func writeBlocks(buf *bytes.Buffer, blockType string, labels []string, remaining int, value interface{}, blockLabels map[string]int) error {
+	if values, ok := value.([]interface{}); ok {
+		for _, v := range values {
+			if err := writeBlocks(buf, blockType, labels, remaining, v, blockLabels); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	obj, ok := value.(jsonObject)
+	if !ok {
+		return fmt.Errorf("%s blocks must be objects", blockType)
+	}
+
+	if remaining > 0 {
+		for _, p := range obj {
+			if err := writeBlocks(buf, blockType, append(labels, p.Key), remaining-1, p.Value, blockLabels); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var body bytes.Buffer
+	if err := writeBody(&body, obj, blockType, blockLabels); err != nil {
+		return err
+	}
+
+	if buf.Len() > 0 {
+		buf.WriteString("\n")
+	}
+
+	buf.WriteString(blockType)
+	for _, l := range labels {
+		fmt.Fprintf(buf, " %s", hclString(l))
+	}
+	buf.WriteString(" {\n")
+	buf.Write(body.Bytes())
+	buf.WriteString("}\n")
+
+	return nil
+}
+
+// interpolation returns the expression of a string which is a single
+// interpolation, such as "${var.name}"
+func interpolation(s string) (string, bool) {
+	if !strings.HasPrefix(s, "${") || !strings.HasSuffix(s, "}") {
+		return "", false
+	}
+
+	expr := s[2 : len(s)-1]
+	if strings.Contains(expr, "${") {
+		return "", false
+	}
+	if _, diags := hclsyntax.ParseExpression([]byte(expr), "", hcl.Pos{Line: 1, Column: 1}); diags.HasErrors() {
+		return "", false
+	}
+
+	return expr, true
+}
+
+// hclExpr converts a JSON value into a native expression
+func hclExpr(v interface{}) string {
+	switch v := v.(type) {
+	case jsonObject:
+		var items []string
+		for _, p := range v {
+			items = append(items, fmt.Sprintf("%s = %s", hclKey(p.Key), hclExpr(p.Value)))
+		}
+		if len(items) == 0 {
+			return "{}"
+		}
+		return "{\n" + strings.Join(items, "\n") + "\n}"
+	case []interface{}:
+		var items []string
+		for _, e := range v {
+			items = append(items, hclExpr(e))
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case string:
+		if expr, ok := interpolation(v); ok {
+			return expr
+		}
+		return hclTemplate(v)
+	case json.Number:
+		return v.String()
+	case bool:
+		return fmt.Sprintf("%t", v)
+	}
+
+	return "null"
+}
+
+// hclKey returns an object key or attribute name, quoting it unless it is a
+// valid identifier
+func hclKey(key string) string {
+	if hclsyntax.ValidIdentifier(key) {
+		return key
+	}
+
+	return hclString(key)
+}
+
+// hclTemplate quotes a JSON template as a native template, leaving the
+// source of its interpolations as it is
+func hclTemplate(s string) string {
+	expr, diags := hclsyntax.ParseTemplate([]byte(s), "", hcl.Pos{Line: 1, Column: 1})
+	if t, ok := expr.(*hclsyntax.TemplateExpr); ok && !diags.HasErrors() {
+		if out, ok := rebuildTemplate(t.Parts, []byte(s), quoteString); ok {
+			return `"` + out + `"`
+		}
+	}
+
+	return hclString(s)
+}
+
+// hclString quotes a string as a native string, keeping its template
+// sequences
+func hclString(s string) string {
+	return `"` + quoteString(s) + `"`
+}
+
+// quoteString escapes the characters of a string which cannot appear within
+// quotes
+func quoteString(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		switch c {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			if c < 0x20 {
+				fmt.Fprintf(&b, `\u%04x`, c)
+				continue
+			}
+			b.WriteRune(c)
+		}
+	}
+
+	return b.String()
+}
+
+// decodeJSON decodes the next JSON value, keeping the order of the
+// properties of objects
+func decodeJSON(d *json.Decoder) (interface{}, error) {
+	d.UseNumber()
+
+	t, err := d.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch t {
+	case json.Delim('{'):
+		var obj = jsonObject{}
+		for d.More() {
+			key, err := d.Token()
+			if err != nil {
+				return nil, err
+			}
+
+			value, err := decodeJSON(d)
+			if err != nil {
+				return nil, err
+			}
+			obj = append(obj, jsonProperty{key.(string), value})
+		}
+		_, err := d.Token()
+		return obj, err
+	case json.Delim('['):
+		var list = []interface{}{}
+		for d.More() {
+			value, err := decodeJSON(d)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, value)
+		}
+		_, err := d.Token()
+		return list, err
+	}
+
+	return t, nil
+}
@@ -0,0 +1,154 @@
+package recipe
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mikemackintosh/bakery/config"
+	"github.com/mikemackintosh/bakery/pantry"
+)
+
+var testConvertRecipe = `
+variable "name" {
+  default = "world"
+}
+
+locals {
+  greeting = "hello ${var.name}"
+  tools    = ["jq", "fzf"]
+  numbers  = { one = 1, "two words" = 2 }
+}
+
+shell "greet" {
+  script = local.greeting
+}
+
+brew "tools" {
+  for_each = toset(local.tools)
+  action   = "install"
+  not_if   = "brew list ${each.key}"
+}
+
+data "exec" "version" {
+  command = ["version"]
+}
+
+shell "count" {
+  count      = 2
+  script     = "echo ${count.index} ${upper(var.name)} ${local.numbers["two words"]}"
+  depends_on = "tools"
+}
+
+shell "with space" {
+  script = <<EOF
+echo ${shell.greet.stdout}
+echo $${HOME}
+EOF
+}
+
+shell "literal" {
+  script = "echo $${HOME} %%{x} ${data.exec.version.stdout}"
+}
+
+brew "last" {
+  action = "install"
+  not_if = "brew list last"
+}
+
+resource_type "greeting" {
+  variable "who" {
+    type = list(string)
+  }
+
+  shell "say" {
+    script = "hi ${join(" and ", var.who)} from ${self.name}"
+  }
+}
+
+greeting "home" {
+  who = [var.name, "you"]
+}
+`
+
+// runConverted loads and runs a recipe file, returning its runlist and the
+// stdout of its shell blocks
+func runConverted(t *testing.T, name string) (string, string) {
+	r, err := loadFile(name)
+	if err != nil {
+		t.Fatalf("%s: want no error but got %s", name, err)
+	}
+	if err := r.Run(); err != nil {
+		t.Fatalf("%s: want no error but got %s", name, err)
+	}
+
+	var shells []string
+	for _, n := range r.Runlist {
+		if n.Type == "shell" {
+			shells = append(shells, n.Outputs().GetAttr("stdout").AsString())
+		}
+	}
+
+	return addresses(r.Runlist), strings.Join(shells, "\n")
+}
+
+func TestConvert(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"config.yum": testConvertRecipe})
+	defer os.RemoveAll(dir)
+	config.Registry.TempDir = dir
+
+	previous := pantry.Runner
+	pantry.Runner = scriptRunner{}
+	defer func() {
+		pantry.Runner = previous
+	}()
+
+	jsonSrc, diags := HCLToJSON([]byte(testConvertRecipe), "config.yum")
+	if diags.HasErrors() {
+		t.Fatalf("want no error but got %s", diags)
+	}
+	hclSrc, diags := JSONToHCL(jsonSrc, "config.yum.json")
+	if diags.HasErrors() {
+		t.Fatalf("want no error but got %s", diags)
+	}
+
+	for name, src := range map[string][]byte{"json/config.yum.json": jsonSrc, "native/config.yum": hclSrc} {
+		name = filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(name, src, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	wantRunlist, wantShells := runConverted(t, filepath.Join(dir, "config.yum"))
+	if !strings.Contains(wantShells, "echo 1 WORLD 2") || !strings.Contains(wantShells, "echo ${HOME} %{x}") ||
+		!strings.Contains(wantShells, "hi world and you from home") {
+		t.Fatalf("want the shell scripts to be run but got %q", wantShells)
+	}
+
+	for _, name := range []string{"json/config.yum.json", "native/config.yum"} {
+		runlist, shells := runConverted(t, filepath.Join(dir, name))
+		if runlist != wantRunlist {
+			t.Errorf("%s: want %s but got %s", name, wantRunlist, runlist)
+		}
+		if shells != wantShells {
+			t.Errorf("%s: want %q but got %q", name, wantShells, shells)
+		}
+	}
+}
+
+func TestConvertErrors(t *testing.T) {
+	if _, diags := HCLToJSON([]byte(`shell "a" {`), "a.yum"); !diags.HasErrors() {
+		t.Errorf("want an error for invalid syntax")
+	}
+	if _, diags := JSONToHCL([]byte(`["shell"]`), "a.yum.json"); !diags.HasErrors() {
+		t.Errorf("want an error for a root which is not an object")
+	}
+	if _, diags := JSONToHCL([]byte(`{"shell": {"a": 1}}`), "a.yum.json"); !diags.HasErrors() {
+		t.Errorf("want an error for a block which is not an object")
+	}
+}
@@ -0,0 +1,239 @@
+package recipe
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/hashicorp/hcl2/hcl"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/convert"
+)
+
+// instanceKey is the key and value of an instance of a block with for_each
+// or count
+type instanceKey struct {
+	key   cty.Value
+	value cty.Value
+}
+
+// expand replaces each block with for_each or count in the runlist with its
+// instances, and removes modules, which only group their blocks. The
+// instances are known before the run starts, so for_each and count can only
+// use variables, facts and locals which do not depend on other blocks, which
+// are evaluated here.
+func (r *Recipe) expand() hcl.Diagnostics {
+	var diags hcl.Diagnostics
+	var known = map[*Node]bool{}
+	var runlist []*Node
+
+	for _, n := range r.Runlist {
+		if n.Local != nil && r.isKnown(n.DependsOn, known) {
+			val, valDiags := r.evaluate(n)
+			if !valDiags.HasErrors() {
+				n.value = &val
+				known[n] = true
+			} else if n.varType != cty.NilType {
+				// An input which does not match the type of its variable
+				// is reported before the run starts
+				diags = append(diags, valDiags...)
+			}
+		}
+
+		if n.isModule() {
+			continue
+		}
+
+		if !n.repeated() {
+			runlist = append(runlist, n)
+			continue
+		}
+
+		keys, keyDiags := r.instanceKeys(n, known)
+		diags = append(diags, keyDiags...)
+		if keyDiags.HasErrors() {
+			continue
+		}
+
+		for _, k := range keys {
+			i := r.instance(n, k)
+			n.instances = append(n.instances, i)
+			runlist = append(runlist, i)
+		}
+	}
+	if diags.HasErrors() {
+		return diags
+	}
+
+	for _, n := range runlist {
+		n.DependsOn = evaluated(n.DependsOn)
+	}
+
+	r.Runlist = runlist
+	return nil
+}
+
+// evaluated replaces the blocks which are not evaluated themselves with the
+// blocks they stand for: an expanded block with all of its instances, and a
+// module with all of its blocks
+func evaluated(nodes []*Node) []*Node {
+	var out []*Node
+	for _, n := range nodes {
+		switch {
+		case n.repeated():
+			out = append(out, n.instances...)
+		case n.isModule():
+			out = append(out, evaluated(n.DependsOn)...)
+		default:
+			out = append(out, n)
+		}
+	}
+
+	return out
+}
+
+// isKnown returns true when all of the blocks are known before the run starts
+func (r *Recipe) isKnown(nodes []*Node, known map[*Node]bool) bool {
+	for _, n := range nodes {
+		if !known[n] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// instanceKeys evaluates the for_each or count of a block into the keys of
+// its instances
+func (r *Recipe) instanceKeys(n *Node, known map[*Node]bool) ([]instanceKey, hcl.Diagnostics) {
+	var name, expr = "for_each", n.forEach
+	if n.count != nil {
+		name, expr = "count", n.count
+	}
+
+	for _, t := range expr.Variables() {
+		if dep, _, ok := r.reference(n.scope, t); !ok || known[dep] {
+			continue
+		}
+
+		return nil, hcl.Diagnostics{{
+			Severity: hcl.DiagError,
+			Summary:  fmt.Sprintf("Invalid %s argument", name),
+			Detail:   fmt.Sprintf("The %s value must be known before the run starts, so it can only use variables, facts and locals which do not depend on other blocks.", name),
+			Subject:  t.SourceRange().Ptr(),
+		}}
+	}
+
+	val, diags := expr.Value(r.BlockEvalContext(n))
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	var keys []instanceKey
+	var err error
+	if n.count != nil {
+		keys, err = countKeys(val)
+	} else {
+		keys, err = forEachKeys(val)
+	}
+	if err != nil {
+		return nil, hcl.Diagnostics{{
+			Severity: hcl.DiagError,
+			Summary:  fmt.Sprintf("Invalid %s argument", name),
+			Detail:   err.Error(),
+			Subject:  expr.Range().Ptr(),
+		}}
+	}
+
+	return keys, nil
+}
+
+// forEachKeys returns an instance for each element of a list or set of
+// strings, keyed by the string, or each element of a map keyed by its key
+func forEachKeys(val cty.Value) ([]instanceKey, error) {
+	var ty = val.Type()
+	if val.IsNull() || !(ty.IsCollectionType() || ty.IsObjectType() || ty.IsTupleType()) {
+		return nil, fmt.Errorf("The for_each value must be a list, set or map, not %s.", ty.FriendlyName())
+	}
+
+	var keys []instanceKey
+	var seen = map[string]bool{}
+	for it := val.ElementIterator(); it.Next(); {
+		k, v := it.Element()
+
+		if !ty.IsMapType() && !ty.IsObjectType() {
+			s, err := convert.Convert(v, cty.String)
+			if err != nil || s.IsNull() {
+				return nil, fmt.Errorf("The elements of a for_each list or set must be strings, to be used as the keys of the instances.")
+			}
+			k = s
+		}
+
+		if seen[k.AsString()] {
+			return nil, fmt.Errorf("The for_each value contains %q more than once.", k.AsString())
+		}
+		seen[k.AsString()] = true
+		keys = append(keys, instanceKey{key: k, value: v})
+	}
+
+	return keys, nil
+}
+
+// countKeys returns an instance for each index up to the count
+func countKeys(val cty.Value) ([]instanceKey, error) {
+	num, err := convert.Convert(val, cty.Number)
+	if err != nil || num.IsNull() {
+		return nil, fmt.Errorf("The count value must be a whole number.")
+	}
+
+	count, accuracy := num.AsBigFloat().Int64()
+	if accuracy != big.Exact || count < 0 {
+		return nil, fmt.Errorf("The count value must be a whole number, not less than zero.")
+	}
+
+	var keys []instanceKey
+	for i := int64(0); i < count; i++ {
+		keys = append(keys, instanceKey{key: cty.NumberIntVal(i)})
+	}
+
+	return keys, nil
+}
+
+// instance creates a node for an instance of a block. Instances of a block
+// with for_each are named after their key, so a brew block can install each
+// formula in a list.
+func (r *Recipe) instance(n *Node, k instanceKey) *Node {
+	var i = &Node{
+		Type:      n.Type,
+		Name:      n.Name,
+		Range:     n.Range,
+		Key:       k.key,
+		DependsOn: n.DependsOn,
+		scope:     n.scope,
+		block:     n.block,
+		body:      n.body,
+		index:     n.index,
+	}
+
+	var name = n.Name
+	if n.forEach != nil {
+		name = k.key.AsString()
+		i.Address = fmt.Sprintf("%s[%q]", n.Address, name)
+		i.instance = map[string]cty.Value{
+			"each": cty.ObjectVal(map[string]cty.Value{
+				"key":   k.key,
+				"value": k.value,
+			}),
+		}
+	} else {
+		index, _ := k.key.AsBigFloat().Int64()
+		i.Address = fmt.Sprintf("%s[%d]", n.Address, index)
+		i.instance = map[string]cty.Value{
+			"count": cty.ObjectVal(map[string]cty.Value{
+				"index": k.key,
+			}),
+		}
+	}
+
+	i.Resource, i.Data = r.create(n.block, name, n.body)
+	return i
+}
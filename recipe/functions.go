@@ -0,0 +1,523 @@
+package recipe
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/hashicorp/hcl2/hcl"
+	"github.com/hashicorp/hcl2/hcl/hclsyntax"
+	"github.com/mikemackintosh/bakery/pantry"
+	homedir "github.com/mitchellh/go-homedir"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/convert"
+	"github.com/zclconf/go-cty/cty/function"
+	"github.com/zclconf/go-cty/cty/function/stdlib"
+)
+
+// ReadBundleFile reads a file bundled with the binary, for bundle_file
+var ReadBundleFile = func(name string) ([]byte, error) {
+	return nil, fmt.Errorf("no files are bundled with this binary")
+}
+
+// functionDescriptions document each function for `bakery functions`
+var functionDescriptions = map[string]string{
+	"abs":             "Returns the absolute value of a number.",
+	"base64decode":    "Decodes a base64 string.",
+	"base64encode":    "Encodes a string as base64.",
+	"bundle_file":     "Reads a file bundled with the binary.",
+	"chomp":           "Removes newlines from the end of a string.",
+	"coalesce":        "Returns the first argument which is not null.",
+	"concat":          "Joins lists together.",
+	"contains":        "Returns true when the list contains the value.",
+	"csvdecode":       "Decodes CSV with a header row into a list of objects.",
+	"env":             "Returns the value of an environment variable, or an empty string when it is not set.",
+	"file":            "Reads a file, relative to the recipe, as a string.",
+	"format":          "Formats values with a printf style format string.",
+	"formatdate":      "Formats an RFC 3339 timestamp.",
+	"formatlist":      "Formats each element of lists with a printf style format string.",
+	"homedir":         "Returns the home directory of the user running bakery, or who ran it with sudo.",
+	"int":             "Truncates a number to an integer.",
+	"join":            "Joins a list of strings with a separator.",
+	"jsondecode":      "Decodes a JSON string.",
+	"jsonencode":      "Encodes a value as JSON.",
+	"keys":            "Returns the sorted keys of a map or object.",
+	"length":          "Returns the number of elements in a collection, or characters in a string.",
+	"lookup":          "Returns the value of a key in a map or object, or the default when it is not present.",
+	"lower":           "Converts a string to lower case.",
+	"max":             "Returns the largest number.",
+	"md5":             "Returns the hex MD5 of a string.",
+	"merge":           "Merges maps or objects, later arguments taking precedence.",
+	"min":             "Returns the smallest number.",
+	"range":           "Returns a list of numbers from start to limit by step.",
+	"regex":           "Returns the first match of a regular expression.",
+	"regexall":        "Returns every match of a regular expression.",
+	"replace":         "Replaces each occurrence of a substring.",
+	"reverse":         "Reverses the characters of a string.",
+	"semver_compare":  "Compares two versions, returning -1, 0 or 1.",
+	"setintersection": "Returns the elements which are in every set.",
+	"setsubtract":     "Returns the elements of the first set which are not in the second.",
+	"setunion":        "Returns the elements which are in any of the sets.",
+	"sha1":            "Returns the hex SHA-1 of a string.",
+	"sha256":          "Returns the hex SHA-256 of a string.",
+	"split":           "Splits a string by a separator into a list.",
+	"strlen":          "Returns the number of characters in a string.",
+	"substr":          "Returns part of a string, by offset and length.",
+	"templatefile":    "Renders a template file, relative to the recipe, with the variables in the object.",
+	"tolist":          "Converts a set or tuple to a list.",
+	"toset":           "Converts a list or tuple to a set, removing duplicates and sorting it.",
+	"trimspace":       "Removes whitespace from the start and end of a string.",
+	"upper":           "Converts a string to upper case.",
+	"values":          "Returns the values of a map or object, sorted by key.",
+}
+
+// Functions returns the functions which can be used in recipe expressions,
+// resolving relative paths from the directory of the recipe
+func Functions(dir string) map[string]function.Function {
+	funcs := baseFunctions(dir)
+	funcs["templatefile"] = templateFileFunc(dir, func() map[string]function.Function {
+		// templates cannot render other templates
+		return baseFunctions(dir)
+	})
+
+	return funcs
+}
+
+// baseFunctions are the functions available to templates
+func baseFunctions(dir string) map[string]function.Function {
+	return map[string]function.Function{
+		"abs":             stdlib.AbsoluteFunc,
+		"base64decode":    base64DecodeFunc,
+		"base64encode":    stringFunc(base64.StdEncoding.EncodeToString),
+		"bundle_file":     bundleFileFunc,
+		"chomp":           stringFunc(func(b []byte) string { return strings.TrimRight(string(b), "\r\n") }),
+		"coalesce":        stdlib.CoalesceFunc,
+		"concat":          stdlib.ConcatFunc,
+		"contains":        containsFunc,
+		"csvdecode":       stdlib.CSVDecodeFunc,
+		"env":             envFunc,
+		"file":            fileFunc(dir),
+		"format":          stdlib.FormatFunc,
+		"formatdate":      stdlib.FormatDateFunc,
+		"formatlist":      stdlib.FormatListFunc,
+		"homedir":         homedirFunc,
+		"int":             stdlib.IntFunc,
+		"join":            joinFunc,
+		"jsondecode":      stdlib.JSONDecodeFunc,
+		"jsonencode":      stdlib.JSONEncodeFunc,
+		"keys":            keysFunc,
+		"length":          stdlib.LengthFunc,
+		"lookup":          lookupFunc,
+		"lower":           stdlib.LowerFunc,
+		"max":             stdlib.MaxFunc,
+		"md5":             stringFunc(func(b []byte) string { return fmt.Sprintf("%x", md5.Sum(b)) }),
+		"merge":           mergeFunc,
+		"min":             stdlib.MinFunc,
+		"range":           stdlib.RangeFunc,
+		"regex":           stdlib.RegexFunc,
+		"regexall":        stdlib.RegexAllFunc,
+		"replace":         replaceFunc,
+		"reverse":         stdlib.ReverseFunc,
+		"semver_compare":  semverCompareFunc,
+		"setintersection": stdlib.SetIntersectionFunc,
+		"setsubtract":     stdlib.SetSubtractFunc,
+		"setunion":        stdlib.SetUnionFunc,
+		"sha1":            stringFunc(func(b []byte) string { return fmt.Sprintf("%x", sha1.Sum(b)) }),
+		"sha256":          stringFunc(func(b []byte) string { return fmt.Sprintf("%x", sha256.Sum256(b)) }),
+		"split":           splitFunc,
+		"strlen":          stdlib.StrlenFunc,
+		"substr":          stdlib.SubstrFunc,
+		"tolist":          convertFunc(cty.List(cty.DynamicPseudoType)),
+		"toset":           convertFunc(cty.Set(cty.DynamicPseudoType)),
+		"trimspace":       stringFunc(func(b []byte) string { return strings.TrimSpace(string(b)) }),
+		"upper":           stdlib.UpperFunc,
+		"values":          valuesFunc,
+	}
+}
+
+// FunctionDoc describes a function
+type FunctionDoc struct {
+	Name        string
+	Signature   string
+	Description string
+}
+
+// DescribeFunctions returns the signature and description of each function,
+// sorted by name
+func DescribeFunctions() []FunctionDoc {
+	var docs []FunctionDoc
+	for name, f := range Functions("") {
+		var params []string
+		for _, p := range f.Params() {
+			params = append(params, p.Name)
+		}
+		if p := f.VarParam(); p != nil {
+			params = append(params, p.Name+"...")
+		}
+
+		docs = append(docs, FunctionDoc{
+			Name:        name,
+			Signature:   fmt.Sprintf("%s(%s)", name, strings.Join(params, ", ")),
+			Description: functionDescriptions[name],
+		})
+	}
+
+	sort.Slice(docs, func(i, j int) bool {
+		return docs[i].Name < docs[j].Name
+	})
+
+	return docs
+}
+
+// stringFunc makes a function of one string from a Go function
+func stringFunc(f func([]byte) string) function.Function {
+	return function.New(&function.Spec{
+		Params: []function.Parameter{{Name: "str", Type: cty.String}},
+		Type:   function.StaticReturnType(cty.String),
+		Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+			return cty.StringVal(f([]byte(args[0].AsString()))), nil
+		},
+	})
+}
+
+var base64DecodeFunc = function.New(&function.Spec{
+	Params: []function.Parameter{{Name: "str", Type: cty.String}},
+	Type:   function.StaticReturnType(cty.String),
+	Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+		b, err := base64.StdEncoding.DecodeString(args[0].AsString())
+		if err != nil {
+			return cty.UnknownVal(cty.String), fmt.Errorf("invalid base64: %s", err)
+		}
+		if !utf8.Valid(b) {
+			return cty.UnknownVal(cty.String), fmt.Errorf("the decoded value is not UTF-8")
+		}
+
+		return cty.StringVal(string(b)), nil
+	},
+})
+
+var envFunc = function.New(&function.Spec{
+	Params: []function.Parameter{{Name: "name", Type: cty.String}},
+	Type:   function.StaticReturnType(cty.String),
+	Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+		return cty.StringVal(os.Getenv(args[0].AsString())), nil
+	},
+})
+
+var homedirFunc = function.New(&function.Spec{
+	Type: function.StaticReturnType(cty.String),
+	Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+		home, err := pantry.GetUserHome("self")
+		if err != nil {
+			return cty.UnknownVal(cty.String), err
+		}
+
+		return cty.StringVal(home), nil
+	},
+})
+
+var semverCompareFunc = function.New(&function.Spec{
+	Params: []function.Parameter{
+		{Name: "a", Type: cty.String},
+		{Name: "b", Type: cty.String},
+	},
+	Type: function.StaticReturnType(cty.Number),
+	Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+		return cty.NumberIntVal(int64(pantry.CompareVersions(args[0].AsString(), args[1].AsString()))), nil
+	},
+})
+
+var joinFunc = function.New(&function.Spec{
+	Params: []function.Parameter{
+		{Name: "separator", Type: cty.String},
+		{Name: "list", Type: cty.List(cty.String)},
+	},
+	Type: function.StaticReturnType(cty.String),
+	Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+		var parts []string
+		for it := args[1].ElementIterator(); it.Next(); {
+			_, v := it.Element()
+			if v.IsNull() {
+				return cty.UnknownVal(cty.String), fmt.Errorf("cannot join a null string")
+			}
+			parts = append(parts, v.AsString())
+		}
+
+		return cty.StringVal(strings.Join(parts, args[0].AsString())), nil
+	},
+})
+
+var splitFunc = function.New(&function.Spec{
+	Params: []function.Parameter{
+		{Name: "separator", Type: cty.String},
+		{Name: "str", Type: cty.String},
+	},
+	Type: function.StaticReturnType(cty.List(cty.String)),
+	Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+		var list []cty.Value
+		for _, s := range strings.Split(args[1].AsString(), args[0].AsString()) {
+			list = append(list, cty.StringVal(s))
+		}
+
+		return cty.ListVal(list), nil
+	},
+})
+
+var replaceFunc = function.New(&function.Spec{
+	Params: []function.Parameter{
+		{Name: "str", Type: cty.String},
+		{Name: "substr", Type: cty.String},
+		{Name: "replace", Type: cty.String},
+	},
+	Type: function.StaticReturnType(cty.String),
+	Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+		return cty.StringVal(strings.Replace(args[0].AsString(), args[1].AsString(), args[2].AsString(), -1)), nil
+	},
+})
+
+var containsFunc = function.New(&function.Spec{
+	Params: []function.Parameter{
+		{Name: "list", Type: cty.DynamicPseudoType},
+		{Name: "value", Type: cty.DynamicPseudoType},
+	},
+	Type: function.StaticReturnType(cty.Bool),
+	Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+		ty := args[0].Type()
+		if !ty.IsListType() && !ty.IsTupleType() && !ty.IsSetType() {
+			return cty.False, fmt.Errorf("contains needs a list, tuple or set")
+		}
+
+		for it := args[0].ElementIterator(); it.Next(); {
+			_, v := it.Element()
+			if eq := v.Equals(args[1]); eq.IsKnown() && eq.True() {
+				return cty.True, nil
+			}
+		}
+
+		return cty.False, nil
+	},
+})
+
+var lookupFunc = function.New(&function.Spec{
+	Params: []function.Parameter{
+		{Name: "map", Type: cty.DynamicPseudoType},
+		{Name: "key", Type: cty.String},
+		{Name: "default", Type: cty.DynamicPseudoType},
+	},
+	Type: function.StaticReturnType(cty.DynamicPseudoType),
+	Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+		m, key := args[0], args[1].AsString()
+		ty := m.Type()
+		if !ty.IsMapType() && !ty.IsObjectType() {
+			return cty.NilVal, fmt.Errorf("lookup needs a map or object")
+		}
+
+		if ty.IsObjectType() {
+			if ty.HasAttribute(key) {
+				return m.GetAttr(key), nil
+			}
+			return args[2], nil
+		}
+
+		if m.HasIndex(cty.StringVal(key)).True() {
+			return m.Index(cty.StringVal(key)), nil
+		}
+		return args[2], nil
+	},
+})
+
+// attributes returns the keys and values of a map or object
+func attributes(v cty.Value) (map[string]cty.Value, error) {
+	if !v.Type().IsMapType() && !v.Type().IsObjectType() {
+		return nil, fmt.Errorf("a map or object is needed")
+	}
+
+	var out = map[string]cty.Value{}
+	for it := v.ElementIterator(); it.Next(); {
+		k, e := it.Element()
+		out[k.AsString()] = e
+	}
+
+	return out, nil
+}
+
+// sortedKeys returns the keys of a map or object in order
+func sortedKeys(v cty.Value) ([]string, map[string]cty.Value, error) {
+	attrs, err := attributes(v)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var keys []string
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys, attrs, nil
+}
+
+var keysFunc = function.New(&function.Spec{
+	Params: []function.Parameter{{Name: "map", Type: cty.DynamicPseudoType}},
+	Type:   function.StaticReturnType(cty.List(cty.String)),
+	Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+		keys, _, err := sortedKeys(args[0])
+		if err != nil {
+			return cty.NilVal, err
+		}
+		if len(keys) == 0 {
+			return cty.ListValEmpty(cty.String), nil
+		}
+
+		var list []cty.Value
+		for _, k := range keys {
+			list = append(list, cty.StringVal(k))
+		}
+		return cty.ListVal(list), nil
+	},
+})
+
+var valuesFunc = function.New(&function.Spec{
+	Params: []function.Parameter{{Name: "map", Type: cty.DynamicPseudoType}},
+	Type:   function.StaticReturnType(cty.DynamicPseudoType),
+	Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+		keys, attrs, err := sortedKeys(args[0])
+		if err != nil {
+			return cty.NilVal, err
+		}
+		if len(keys) == 0 {
+			return cty.EmptyTupleVal, nil
+		}
+
+		var list []cty.Value
+		for _, k := range keys {
+			list = append(list, attrs[k])
+		}
+		return cty.TupleVal(list), nil
+	},
+})
+
+// convertFunc returns a function which converts its argument to the type
+func convertFunc(ty cty.Type) function.Function {
+	return function.New(&function.Spec{
+		Params: []function.Parameter{{Name: "value", Type: cty.DynamicPseudoType}},
+		Type:   function.StaticReturnType(cty.DynamicPseudoType),
+		Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+			return convert.Convert(args[0], ty)
+		},
+	})
+}
+
+var mergeFunc = function.New(&function.Spec{
+	VarParam: &function.Parameter{Name: "maps", Type: cty.DynamicPseudoType},
+	Type:     function.StaticReturnType(cty.DynamicPseudoType),
+	Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+		var out = map[string]cty.Value{}
+		for _, arg := range args {
+			attrs, err := attributes(arg)
+			if err != nil {
+				return cty.NilVal, err
+			}
+			for k, v := range attrs {
+				out[k] = v
+			}
+		}
+
+		return cty.ObjectVal(out), nil
+	},
+})
+
+// readFile reads a file relative to the directory, as a string
+func readFile(dir, name string) (string, error) {
+	name, err := homedir.Expand(name)
+	if err != nil {
+		return "", err
+	}
+
+	if !filepath.IsAbs(name) {
+		name = filepath.Join(dir, name)
+	}
+
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return "", err
+	}
+	if !utf8.Valid(b) {
+		return "", fmt.Errorf("%s is not UTF-8", name)
+	}
+
+	return string(b), nil
+}
+
+// fileFunc reads files relative to the directory
+func fileFunc(dir string) function.Function {
+	return function.New(&function.Spec{
+		Params: []function.Parameter{{Name: "path", Type: cty.String}},
+		Type:   function.StaticReturnType(cty.String),
+		Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+			s, err := readFile(dir, args[0].AsString())
+			if err != nil {
+				return cty.UnknownVal(cty.String), err
+			}
+
+			return cty.StringVal(s), nil
+		},
+	})
+}
+
+var bundleFileFunc = function.New(&function.Spec{
+	Params: []function.Parameter{{Name: "path", Type: cty.String}},
+	Type:   function.StaticReturnType(cty.String),
+	Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+		b, err := ReadBundleFile(args[0].AsString())
+		if err != nil {
+			return cty.UnknownVal(cty.String), err
+		}
+
+		return cty.StringVal(string(b)), nil
+	},
+})
+
+// templateFileFunc renders templates relative to the directory, with the
+// functions available to the template
+func templateFileFunc(dir string, funcs func() map[string]function.Function) function.Function {
+	return function.New(&function.Spec{
+		Params: []function.Parameter{
+			{Name: "path", Type: cty.String},
+			{Name: "vars", Type: cty.DynamicPseudoType},
+		},
+		Type: function.StaticReturnType(cty.String),
+		Impl: func(args []cty.Value, retType cty.Type) (cty.Value, error) {
+			name := args[0].AsString()
+			src, err := readFile(dir, name)
+			if err != nil {
+				return cty.UnknownVal(cty.String), err
+			}
+
+			expr, diags := hclsyntax.ParseTemplate([]byte(src), name, hcl.Pos{Line: 1, Column: 1})
+			if diags.HasErrors() {
+				return cty.UnknownVal(cty.String), diags
+			}
+
+			vars, err := attributes(args[1])
+			if err != nil {
+				return cty.UnknownVal(cty.String), fmt.Errorf("the template variables must be %s", err)
+			}
+
+			val, diags := expr.Value(&hcl.EvalContext{Variables: vars, Functions: funcs()})
+			if diags.HasErrors() {
+				return cty.UnknownVal(cty.String), diags
+			}
+
+			return convert.Convert(val, cty.String)
+		},
+	})
+}
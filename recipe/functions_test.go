@@ -0,0 +1,78 @@
+package recipe
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/hcl2/hcl"
+	"github.com/hashicorp/hcl2/hcl/hclsyntax"
+	"github.com/zclconf/go-cty/cty"
+)
+
+var testFunctions = []struct {
+	Expr     string
+	Expected cty.Value
+}{
+	{`lower("JQ")`, cty.StringVal("jq")},
+	{`format("%s-%d", "fzf", 4)`, cty.StringVal("fzf-4")},
+	{`join(",", ["a", "b"])`, cty.StringVal("a,b")},
+	{`split(",", "a,b")[1]`, cty.StringVal("b")},
+	{`replace("1.2.3", ".", "_")`, cty.StringVal("1_2_3")},
+	{`trimspace(" v1 \n")`, cty.StringVal("v1")},
+	{`chomp("v1\n")`, cty.StringVal("v1")},
+	{`sha256("bakery")`, cty.StringVal("144ac86d05c00f559949c5db9d12a6c477292f690606ac2e8bdb2c9f9ee03406")},
+	{`base64decode(base64encode("bakery"))`, cty.StringVal("bakery")},
+	{`jsonencode({a = 1})`, cty.StringVal(`{"a":1}`)},
+	{`coalesce(null, "b")`, cty.StringVal("b")},
+	{`lookup({a = "1"}, "a", "none")`, cty.StringVal("1")},
+	{`lookup({a = "1"}, "b", "none")`, cty.StringVal("none")},
+	{`contains(["a", "b"], "b")`, cty.True},
+	{`keys({b = 1, a = 2})[0]`, cty.StringVal("a")},
+	{`merge({a = 1}, {a = 2}).a`, cty.NumberIntVal(2)},
+	{`semver_compare("1.10.0", "1.9.2")`, cty.NumberIntVal(1)},
+	{`semver_compare("v1.2", "1.2.0")`, cty.NumberIntVal(0)},
+	{`env("BAKERY_TEST_FUNCTION")`, cty.StringVal("set")},
+	{`file("version")`, cty.StringVal("1.2.3\n")},
+	{`templatefile("greeting.tpl", {name = "bakery"})`, cty.StringVal("hello BAKERY\n")},
+}
+
+func TestFunctions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "functions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ioutil.WriteFile(filepath.Join(dir, "version"), []byte("1.2.3\n"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "greeting.tpl"), []byte("hello ${upper(name)}\n"), 0644)
+	os.Setenv("BAKERY_TEST_FUNCTION", "set")
+	defer os.Unsetenv("BAKERY_TEST_FUNCTION")
+
+	ctx := &hcl.EvalContext{Functions: Functions(dir)}
+	for _, test := range testFunctions {
+		expr, diags := hclsyntax.ParseExpression([]byte(test.Expr), "test", hcl.Pos{Line: 1, Column: 1})
+		if diags.HasErrors() {
+			t.Fatalf("want no error but got %s", diags)
+		}
+
+		val, diags := expr.Value(ctx)
+		if diags.HasErrors() {
+			t.Errorf("%s: want no error but got %s", test.Expr, diags)
+			continue
+		}
+
+		if !val.RawEquals(test.Expected) {
+			t.Errorf("%s: want %#v but got %#v", test.Expr, test.Expected, val)
+		}
+	}
+}
+
+func TestDescribeFunctions(t *testing.T) {
+	for _, f := range DescribeFunctions() {
+		if len(f.Description) == 0 {
+			t.Errorf("want a description for %s", f.Name)
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package recipe
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl2/hcl"
+	"github.com/hashicorp/hcl2/hclparse"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/convert"
+)
+
+// bundlePrefix marks the paths of files bundled with the binary
+const bundlePrefix = "bundle:"
+
+// GlobBundleFiles returns the files bundled with the binary which match the
+// pattern, for include
+var GlobBundleFiles = func(pattern string) ([]string, error) {
+	return nil, fmt.Errorf("no files are bundled with this binary")
+}
+
+// parseFiles parses the recipe files, and the files they include, into a
+// single body
+func parseFiles(names []string) (hcl.Body, hcl.Diagnostics) {
+	files, diags := parseRecipeFiles(names)
+	return hcl.MergeFiles(files), diags
+}
+
+// parseRecipeFiles parses the recipe files, followed by the files they
+// include
+func parseRecipeFiles(names []string) ([]*hcl.File, hcl.Diagnostics) {
+	var diags hcl.Diagnostics
+	var files []*hcl.File
+	var seen = map[string]bool{}
+	for _, name := range names {
+		seen[includeKey(name)] = true
+		file, fileDiags := parseFile(name)
+		diags = append(diags, fileDiags...)
+		if fileDiags.HasErrors() {
+			continue
+		}
+
+		included, includeDiags := includes(file, includeDir(name), seen)
+		diags = append(diags, includeDiags...)
+		files = append(files, included...)
+	}
+
+	return files, diags
+}
+
+// version returns the sha256 of the contents of the files, which changes
+// whenever the recipe does
+func version(files []*hcl.File) string {
+	hash := sha256.New()
+	for _, f := range files {
+		hash.Write(f.Bytes)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// includes returns the file, without its include attribute, followed by the
+// files it includes. Relative patterns are resolved from the directory of
+// the file which includes them, and each file is only included once.
+func includes(file *hcl.File, dir string, seen map[string]bool) ([]*hcl.File, hcl.Diagnostics) {
+	content, remain, diags := file.Body.PartialContent(&hcl.BodySchema{
+		Attributes: []hcl.AttributeSchema{{Name: "include"}},
+	})
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	var files = []*hcl.File{{Body: remain, Bytes: file.Bytes}}
+	attr, ok := content.Attributes["include"]
+	if !ok {
+		return files, diags
+	}
+
+	val, valDiags := attr.Expr.Value(&hcl.EvalContext{Functions: Functions(dir)})
+	diags = append(diags, valDiags...)
+	if valDiags.HasErrors() {
+		return nil, diags
+	}
+
+	patterns, err := stringList(val)
+	if err != nil {
+		return nil, append(diags, &hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  "Invalid include",
+			Detail:   "The include value must be a path or a list of paths.",
+			Subject:  attr.Expr.Range().Ptr(),
+		})
+	}
+
+	for _, pattern := range patterns {
+		names, err := globInclude(dir, pattern)
+		if err == nil && len(names) == 0 {
+			err = fmt.Errorf("no files match %q", pattern)
+		}
+		if err != nil {
+			diags = append(diags, &hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  "Invalid include",
+				Detail:   fmt.Sprintf("Cannot include %s: %s.", pattern, err),
+				Subject:  attr.Expr.Range().Ptr(),
+			})
+			continue
+		}
+
+		for _, name := range names {
+			if seen[includeKey(name)] {
+				continue
+			}
+			seen[includeKey(name)] = true
+
+			included, fileDiags := parseFile(name)
+			diags = append(diags, fileDiags...)
+			if fileDiags.HasErrors() {
+				continue
+			}
+
+			more, includeDiags := includes(included, includeDir(name), seen)
+			diags = append(diags, includeDiags...)
+			files = append(files, more...)
+		}
+	}
+
+	return files, diags
+}
+
+// globInclude returns the files which match the pattern, in lexical order.
+// Patterns starting with bundle: match the files bundled with the binary.
+func globInclude(dir, pattern string) ([]string, error) {
+	if !strings.HasPrefix(pattern, bundlePrefix) && !filepath.IsAbs(pattern) {
+		if strings.HasPrefix(dir, bundlePrefix) {
+			pattern = bundlePrefix + path.Join(strings.TrimPrefix(dir, bundlePrefix), pattern)
+		} else {
+			pattern = filepath.Join(dir, pattern)
+		}
+	}
+
+	if !strings.HasPrefix(pattern, bundlePrefix) {
+		return filepath.Glob(pattern)
+	}
+
+	names, err := GlobBundleFiles(strings.TrimPrefix(pattern, bundlePrefix))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+
+	for i, name := range names {
+		names[i] = bundlePrefix + name
+	}
+
+	return names, nil
+}
+
+// includeDir returns the directory patterns included by the file are
+// resolved from
+func includeDir(name string) string {
+	if strings.HasPrefix(name, bundlePrefix) {
+		return bundlePrefix + path.Dir(strings.TrimPrefix(name, bundlePrefix))
+	}
+
+	return filepath.Dir(name)
+}
+
+// includeKey returns the name a file is only included once by
+func includeKey(name string) string {
+	if strings.HasPrefix(name, bundlePrefix) {
+		return name
+	}
+
+	if abs, err := filepath.Abs(name); err == nil {
+		return abs
+	}
+
+	return name
+}
+
+// parseFile reads and parses a recipe file, from the bundle when its name
+// starts with bundle:
+func parseFile(name string) (*hcl.File, hcl.Diagnostics) {
+	var src []byte
+	var err error
+	if strings.HasPrefix(name, bundlePrefix) {
+		src, err = ReadBundleFile(strings.TrimPrefix(name, bundlePrefix))
+	} else {
+		src, err = ioutil.ReadFile(name)
+	}
+	if err != nil {
+		return nil, hcl.Diagnostics{{
+			Severity: hcl.DiagError,
+			Summary:  "Failed to read file",
+			Detail:   fmt.Sprintf("The file %q could not be read: %s.", name, err),
+		}}
+	}
+
+	if strings.HasSuffix(name, ".json") {
+		return hclparse.NewParser().ParseJSON(src, name)
+	}
+
+	return hclparse.NewParser().ParseHCL(src, name)
+}
+
+// recipeFiles returns the .yum and .yum.json files in the directory, in
+// lexical order
+func recipeFiles(dir string) ([]string, error) {
+	var names []string
+	for _, pattern := range []string{"*.yum", "*.yum.json"} {
+		matches, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, matches...)
+	}
+	sort.Strings(names)
+
+	if len(names) == 0 {
+		return nil, fmt.Errorf("there are no .yum or .yum.json files in %s", dir)
+	}
+
+	return names, nil
+}
+
+// stringList returns a string or a list of strings as a list
+func stringList(val cty.Value) ([]string, error) {
+	if val.Type() == cty.String {
+		val = cty.TupleVal([]cty.Value{val})
+	}
+
+	list, err := convert.Convert(val, cty.List(cty.String))
+	if err != nil {
+		return nil, err
+	}
+	if list.IsNull() || !list.IsWhollyKnown() {
+		return nil, fmt.Errorf("the value must be known")
+	}
+
+	var out []string
+	for it := list.ElementIterator(); it.Next(); {
+		_, v := it.Element()
+		if v.IsNull() {
+			return nil, fmt.Errorf("the value must not contain null")
+		}
+		out = append(out, v.AsString())
+	}
+
+	return out, nil
+}
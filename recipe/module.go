@@ -0,0 +1,348 @@
+package recipe
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/hcl2/hcl"
+	"github.com/mikemackintosh/bakery/config"
+	"github.com/mikemackintosh/bakery/pantry"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// addModule loads the recipe files of a module into a scope of its own. The
+// attributes of the module block are the values of the module's variables,
+// evaluated in the scope the module is declared in.
+func (r *Recipe) addModule(s *scope, block *hcl.Block) hcl.Diagnostics {
+	content, inputs, diags := block.Body.PartialContent(&hcl.BodySchema{
+		Attributes: []hcl.AttributeSchema{
+			{Name: "source", Required: true},
+			{Name: "checksum"},
+		},
+	})
+	if diags.HasErrors() {
+		return diags
+	}
+
+	source, diags := staticString(content.Attributes["source"], s.dir)
+	if diags.HasErrors() {
+		return diags
+	}
+
+	var checksum string
+	if attr, ok := content.Attributes["checksum"]; ok {
+		if checksum, diags = staticString(attr, s.dir); diags.HasErrors() {
+			return diags
+		}
+	}
+
+	dir, err := moduleDir(s.dir, source, checksum)
+	if err != nil {
+		return hcl.Diagnostics{{
+			Severity: hcl.DiagError,
+			Summary:  "Failed to load module",
+			Detail:   fmt.Sprintf("The module %q could not be loaded: %s.", block.Labels[0], err),
+			Subject:  content.Attributes["source"].Expr.Range().Ptr(),
+		}}
+	}
+
+	body, diags := parseDir(dir)
+	if diags.HasErrors() {
+		return diags
+	}
+
+	m := &Node{
+		Address: "module." + block.Labels[0],
+		Type:    "module",
+		Name:    block.Labels[0],
+		Range:   block.DefRange,
+		group:   true,
+	}
+
+	return r.addGroup(s, block, m, newScope, dir, body, inputs)
+}
+
+// addGroup adds a module or composite resource, loading its body into a scope
+// of its own. The inputs are the values of its variables, evaluated in the
+// scope it is declared in.
+func (r *Recipe) addGroup(s *scope, block *hcl.Block, m *Node, scopeFor func(prefix, dir string) *scope, dir string, body, inputs hcl.Body) hcl.Diagnostics {
+	if diags := r.addNode(s, m); diags.HasErrors() {
+		return diags
+	}
+
+	var first = len(r.Nodes)
+	ms := scopeFor(m.Address+".", dir)
+	if diags := r.load(ms, body, m); diags.HasErrors() {
+		return diags
+	}
+
+	attrs, diags := inputs.JustAttributes()
+	for _, attr := range sortedAttributes(attrs) {
+		if _, ok := ms.variables[attr.Name]; !ok {
+			diags = append(diags, &hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  "Unsupported input",
+				Detail:   fmt.Sprintf("The %s %q has no variable %q.", block.Type, m.Name, attr.Name),
+				Subject:  attr.NameRange.Ptr(),
+			})
+			continue
+		}
+
+		diags = append(diags, r.addNode(ms, &Node{
+			Address: "var." + attr.Name,
+			Type:    "var",
+			Name:    attr.Name,
+			Range:   attr.NameRange,
+			Local:   attr.Expr,
+			scope:   s,
+			varType: ms.types[attr.Name],
+		})...)
+	}
+
+	for name := range ms.required {
+		if _, ok := attrs[name]; !ok {
+			diags = append(diags, &hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  "Missing input",
+				Detail:   fmt.Sprintf("The %s %q requires the variable %q, which has no default.", block.Type, m.Name, name),
+				Subject:  block.DefRange.Ptr(),
+			})
+		}
+	}
+
+	// The group stands for all of its blocks, for depends_on
+	m.DependsOn = append(m.DependsOn, r.Nodes[first:]...)
+	return diags
+}
+
+// addOutput adds an output of a module, which is referenced from the scope
+// the module is declared in as module.name.output
+func (r *Recipe) addOutput(s *scope, block *hcl.Block, module *Node) hcl.Diagnostics {
+	if module == nil {
+		return hcl.Diagnostics{{
+			Severity: hcl.DiagError,
+			Summary:  "Unexpected output block",
+			Detail:   "Outputs can only be declared in modules, use locals in the recipe.",
+			Subject:  block.DefRange.Ptr(),
+		}}
+	}
+
+	content, diags := block.Body.Content(&hcl.BodySchema{
+		Attributes: []hcl.AttributeSchema{{Name: "value", Required: true}},
+	})
+	if diags.HasErrors() {
+		return diags
+	}
+
+	n := &Node{
+		Address: module.Type + "." + module.Name + "." + block.Labels[0],
+		Type:    "output",
+		Name:    block.Labels[0],
+		Range:   block.DefRange,
+		Local:   content.Attributes["value"].Expr,
+		scope:   s,
+	}
+	if diags := r.register(module.scope, n); diags.HasErrors() {
+		return diags
+	}
+
+	module.outputs = append(module.outputs, n)
+	return nil
+}
+
+// parseDir parses the recipe files in the directory into a single body
+func parseDir(dir string) (hcl.Body, hcl.Diagnostics) {
+	names, err := recipeFiles(dir)
+	if err != nil {
+		return nil, hcl.Diagnostics{{
+			Severity: hcl.DiagError,
+			Summary:  "Failed to read recipe",
+			Detail:   err.Error(),
+		}}
+	}
+
+	return parseFiles(names)
+}
+
+// staticString evaluates an attribute which can only use functions, as it is
+// needed before any blocks are evaluated
+func staticString(attr *hcl.Attribute, dir string) (string, hcl.Diagnostics) {
+	val, diags := attr.Expr.Value(&hcl.EvalContext{Functions: Functions(dir)})
+	if diags.HasErrors() {
+		return "", diags
+	}
+
+	if val.Type() != cty.String || val.IsNull() {
+		return "", hcl.Diagnostics{{
+			Severity: hcl.DiagError,
+			Summary:  fmt.Sprintf("Invalid %s", attr.Name),
+			Detail:   fmt.Sprintf("The %s must be a string.", attr.Name),
+			Subject:  attr.Expr.Range().Ptr(),
+		}}
+	}
+
+	return val.AsString(), nil
+}
+
+// moduleDir returns the directory of a module source: a local path relative
+// to the recipe, a git repository prefixed with git:: and optionally pinned
+// with ?ref=, or an HTTPS tarball pinned with its checksum. Repositories and
+// tarballs are fetched into the temp directory the first time they are used.
+func moduleDir(dir, source, checksum string) (string, error) {
+	switch {
+	case strings.HasPrefix(source, "git::"):
+		return fetchGitModule(strings.TrimPrefix(source, "git::"))
+	case strings.HasPrefix(source, "https://"):
+		return fetchTarballModule(source, checksum)
+	case strings.HasPrefix(source, "http://"):
+		return "", fmt.Errorf("modules must be downloaded with https")
+	}
+
+	if !filepath.IsAbs(source) {
+		source = filepath.Join(dir, source)
+	}
+
+	return source, nil
+}
+
+// moduleCache returns the directory a module is fetched into
+func moduleCache(key string) string {
+	return filepath.Join(config.Registry.CacheDir, "modules", fmt.Sprintf("%x", sha256.Sum256([]byte(key))))
+}
+
+// fetchGitModule clones a repository, at the branch or tag of its ref. The
+// clone is only moved into the cache once it succeeds, so a clone which
+// fails part way is not used as the module.
+func fetchGitModule(source string) (string, error) {
+	var dest = moduleCache(source)
+	if pantry.FileExists(dest) {
+		return dest, nil
+	}
+
+	var args = []string{"git", "clone", "--depth", "1"}
+	if i := strings.Index(source, "?ref="); i >= 0 {
+		args = append(args, "--branch", source[i+len("?ref="):])
+		source = source[:i]
+	}
+
+	var tmp = dest + ".tmp"
+	os.RemoveAll(tmp)
+	if _, err := pantry.Runner.Run(&pantry.Command{Args: append(args, source, tmp), Stream: source}); err != nil {
+		os.RemoveAll(tmp)
+		return "", fmt.Errorf("Error cloning %s: %s", source, err)
+	}
+	if err := os.Rename(tmp, dest); err != nil {
+		os.RemoveAll(tmp)
+		return "", err
+	}
+
+	return dest, nil
+}
+
+// fetchTarballModule downloads and extracts a gzipped tarball, which must
+// match its checksum
+func fetchTarballModule(source, checksum string) (string, error) {
+	if checksum == "" {
+		return "", fmt.Errorf("a module downloaded from %s must set its checksum", source)
+	}
+
+	var dest = moduleCache(checksum)
+	if !pantry.FileExists(dest) {
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return "", err
+		}
+
+		// Neither the archive nor a partial extraction is kept, so a
+		// download which fails its checksum is not used by a later run
+		var archive = dest + ".tar.gz"
+		defer os.Remove(archive)
+		if err := pantry.DownloadFile(source, archive, checksum); err != nil {
+			return "", err
+		}
+
+		if err := extractTarball(archive, dest+".tmp"); err != nil {
+			os.RemoveAll(dest + ".tmp")
+			return "", err
+		}
+		if err := os.Rename(dest+".tmp", dest); err != nil {
+			os.RemoveAll(dest + ".tmp")
+			return "", err
+		}
+	}
+
+	return tarballRoot(dest)
+}
+
+// tarballRoot returns the directory a tarball was extracted into, or the
+// directory within it when it only contains a single directory
+func tarballRoot(dir string) (string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+
+	if len(entries) == 1 && entries[0].IsDir() {
+		return filepath.Join(dir, entries[0].Name()), nil
+	}
+
+	return dir, nil
+}
+
+// extractTarball extracts the directories and files of a gzipped tarball
+func extractTarball(name, dest string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var target = filepath.Join(dest, h.Name)
+		if target != dest && !strings.HasPrefix(target, dest+string(os.PathSeparator)) {
+			return fmt.Errorf("%s is outside of the tarball", h.Name)
+		}
+
+		switch h.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+
+			out, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.FileMode(h.Mode)&0755|0644)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(out, tr)
+			out.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,347 @@
+package recipe
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mikemackintosh/bakery/config"
+	"github.com/mikemackintosh/bakery/pantry"
+)
+
+// writeFiles writes the files into a new temporary directory
+func writeFiles(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "recipe")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, content := range files {
+		name = filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+// loadFile loads a recipe file or directory
+func loadFile(name string) (*Recipe, error) {
+	r, diags := LoadPath(name)
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	return r, nil
+}
+
+func TestInclude(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"config.yum":     `include = ["roles/*.yum", "config.yum"]` + "\n" + `shell "main" { script = "main" }`,
+		"roles/a.yum":    `shell "a" { script = "a" }`,
+		"roles/b.yum":    `include = "../common.yum"` + "\n" + `shell "b" { script = shell.c.stdout }`,
+		"common.yum":     `shell "c" { script = "c" }`,
+		"duplicate.yum":  `include = "roles/a.yum"` + "\n" + `shell "a" { script = "a" }`,
+		"missing.yum":    `include = "nothing/*.yum"`,
+		"bundle.yum":     `include = "bundle:base/*.yum"`,
+		"not_a_list.yum": `include = 1`,
+	})
+	defer os.RemoveAll(dir)
+
+	r, err := loadFile(filepath.Join(dir, "config.yum"))
+	if err != nil {
+		t.Fatalf("want no error but got %s", err)
+	}
+	if got := addresses(r.Runlist); got != "shell.main shell.a shell.c shell.b" {
+		t.Errorf("want shell.main shell.a shell.c shell.b but got %s", got)
+	}
+
+	previousGlob, previousRead := GlobBundleFiles, ReadBundleFile
+	GlobBundleFiles = func(pattern string) ([]string, error) {
+		return []string{"base/tools.yum"}, nil
+	}
+	ReadBundleFile = func(name string) ([]byte, error) {
+		return []byte(`brew "jq" {}`), nil
+	}
+	defer func() {
+		GlobBundleFiles, ReadBundleFile = previousGlob, previousRead
+	}()
+
+	r, err = loadFile(filepath.Join(dir, "bundle.yum"))
+	if err != nil {
+		t.Fatalf("want no error but got %s", err)
+	}
+	if got := addresses(r.Runlist); got != "brew.jq" {
+		t.Errorf("want brew.jq but got %s", got)
+	}
+
+	for name, want := range map[string]string{
+		"duplicate.yum":  "roles/a.yum:1",
+		"missing.yum":    `no files match "nothing/*.yum"`,
+		"not_a_list.yum": "must be a path or a list of paths",
+	} {
+		if _, err := loadFile(filepath.Join(dir, name)); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: want %s but got %v", name, want, err)
+		}
+	}
+}
+
+func TestLoadDir(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"recipe/b.yum.json":   `{"shell": {"b": {"script": "${shell.c.stdout}"}}}`,
+		"recipe/a.yum":        `shell "a" { script = "a" }`,
+		"recipe/c.yum":        `shell "c" { script = "c" }`,
+		"recipe/notes.txt":    `not a recipe`,
+		"duplicate/a.yum":     `shell "a" { script = "a" }`,
+		"duplicate/b.yum":     "\n\nshell \"a\" { script = \"b\" }",
+		"variables/a.yum":     `variable "name" {}`,
+		"variables/b.yum":     `variable "name" {}`,
+		"empty/recipe.yum.bk": `shell "a" {}`,
+	})
+	defer os.RemoveAll(dir)
+
+	r, err := loadFile(filepath.Join(dir, "recipe"))
+	if err != nil {
+		t.Fatalf("want no error but got %s", err)
+	}
+	if got := addresses(r.Runlist); got != "shell.a shell.c shell.b" {
+		t.Errorf("want shell.a shell.c shell.b but got %s", got)
+	}
+
+	for name, want := range map[string]string{
+		"duplicate": "duplicate/b.yum:3,1-10: Duplicate block; shell.a was already declared at " + filepath.Join(dir, "duplicate/a.yum") + ":1",
+		"variables": "var.name was already declared",
+		"empty":     "there are no .yum or .yum.json files",
+	} {
+		if _, err := loadFile(filepath.Join(dir, name)); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: want %s but got %v", name, want, err)
+		}
+	}
+}
+
+var testModuleFiles = map[string]string{
+	"modules/ssh/variables.yum": `
+variable "port" {}
+
+variable "user" {
+  default = "root"
+}
+`,
+	"modules/ssh/main.yum": `
+shell "config" {
+  script = "port ${var.port} ${var.user}"
+}
+
+output "config" {
+  value = shell.config.stdout
+}
+`,
+}
+
+func TestModule(t *testing.T) {
+	files := map[string]string{
+		"config.yum": `
+shell "port" { script = "22" }
+
+module "ssh" {
+  source = "./modules/ssh"
+  port   = shell.port.stdout
+}
+
+shell "report" { script = module.ssh.config }
+
+shell "after" {
+  script     = "after"
+  depends_on = "module.ssh"
+}
+`,
+		"missing.yum":     `module "ssh" { source = "./modules/ssh" }`,
+		"unsupported.yum": `module "ssh" {` + "\n" + `source = "./modules/ssh"` + "\n" + `port = 22` + "\n" + `nope = 1` + "\n" + `}`,
+		"output.yum":      `output "a" { value = 1 }`,
+		"http.yum":        `module "a" { source = "http://example.com/a.tar.gz" }`,
+		"unpinned.yum":    `module "a" { source = "https://example.com/a.tar.gz" }`,
+		"undeclared.yum":  `module "ssh" {` + "\n" + `source = "./modules/ssh"` + "\n" + `port = 22` + "\n" + `}` + "\n" + `shell "a" { script = module.ssh.nope }`,
+	}
+	for name, content := range testModuleFiles {
+		files[name] = content
+	}
+
+	dir := writeFiles(t, files)
+	defer os.RemoveAll(dir)
+	config.Registry.TempDir = dir
+	config.Registry.CacheDir = dir
+
+	previous := pantry.Runner
+	pantry.Runner = scriptRunner{}
+	defer func() {
+		pantry.Runner = previous
+	}()
+
+	r, err := loadFile(filepath.Join(dir, "config.yum"))
+	if err != nil {
+		t.Fatalf("want no error but got %s", err)
+	}
+
+	want := "shell.port module.ssh.var.port module.ssh.shell.config module.ssh.config shell.report shell.after"
+	if got := addresses(r.Runlist); got != want {
+		t.Errorf("want %s but got %s", want, got)
+	}
+
+	if err := r.Run(); err != nil {
+		t.Fatalf("want no error but got %s", err)
+	}
+	if got := r.EvalContext().Variables["shell"].GetAttr("report").GetAttr("stdout").AsString(); got != "port 22 root" {
+		t.Errorf("want port 22 root but got %s", got)
+	}
+
+	for name, want := range map[string]string{
+		"missing.yum":     `requires the variable "port"`,
+		"unsupported.yum": `has no variable "nope"`,
+		"output.yum":      "Outputs can only be declared in modules",
+		"http.yum":        "must be downloaded with https",
+		"unpinned.yum":    "must set its checksum",
+		"undeclared.yum":  "module.ssh.nope is not declared",
+	} {
+		if _, err := loadFile(filepath.Join(dir, name)); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: want %s but got %v", name, want, err)
+		}
+	}
+}
+
+// testTarball returns a gzipped tarball of the files
+func testTarball(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tw.Close()
+	gz.Close()
+
+	return buf.Bytes()
+}
+
+func TestTarballModule(t *testing.T) {
+	var files = map[string]string{}
+	for name, content := range testModuleFiles {
+		files["ssh-1.0/"+strings.TrimPrefix(name, "modules/ssh/")] = content
+	}
+	tarball := testTarball(t, files)
+
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(tarball)
+	}))
+	defer ts.Close()
+
+	previousTransport := http.DefaultTransport
+	http.DefaultTransport = ts.Client().Transport
+	defer func() {
+		http.DefaultTransport = previousTransport
+	}()
+
+	dir := writeFiles(t, map[string]string{
+		"config.yum": fmt.Sprintf(`
+module "ssh" {
+  source   = "%s/ssh-1.0.tar.gz"
+  checksum = "%x"
+  port     = 2222
+}`, ts.URL, sha256.Sum256(tarball)),
+		"tampered.yum": fmt.Sprintf(`
+module "ssh" {
+  source   = "%s/ssh-1.0.tar.gz"
+  checksum = "%x"
+  port     = 2222
+}`, ts.URL, sha256.Sum256([]byte("tampered"))),
+	})
+	defer os.RemoveAll(dir)
+	config.Registry.TempDir = dir
+	config.Registry.CacheDir = dir
+
+	r, err := loadFile(filepath.Join(dir, "config.yum"))
+	if err != nil {
+		t.Fatalf("want no error but got %s", err)
+	}
+	if got := addresses(r.Runlist); got != "module.ssh.var.port module.ssh.shell.config module.ssh.config" {
+		t.Errorf("want the module blocks but got %s", got)
+	}
+
+	if _, err := loadFile(filepath.Join(dir, "tampered.yum")); err == nil || !strings.Contains(err.Error(), "Failed to validate file") {
+		t.Errorf("want a checksum error but got %v", err)
+	}
+	if cached, _ := filepath.Glob(moduleCache(fmt.Sprintf("%x", sha256.Sum256([]byte("tampered")))) + "*"); len(cached) != 0 {
+		t.Errorf("want nothing cached for the tampered module but got %v", cached)
+	}
+}
+
+// failedCloneRunner leaves part of a clone behind, then fails
+type failedCloneRunner struct{}
+
+func (failedCloneRunner) Run(c *pantry.Command) (*pantry.CommandResponse, error) {
+	dest := c.Args[len(c.Args)-1]
+	os.MkdirAll(dest, 0755)
+	ioutil.WriteFile(filepath.Join(dest, "partial.yum"), []byte("shell \"a\" {"), 0644)
+	return &pantry.CommandResponse{ExitCode: 128}, fmt.Errorf("exit status 128")
+}
+
+func TestGitModuleFailedClone(t *testing.T) {
+	dir, err := ioutil.TempDir("", "module")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	previousConfig := *config.Registry
+	config.Registry.CacheDir = dir
+	previous := pantry.Runner
+	pantry.Runner = failedCloneRunner{}
+	defer func() {
+		*config.Registry = previousConfig
+		pantry.Runner = previous
+	}()
+
+	for i := 0; i < 2; i++ {
+		if _, err := fetchGitModule("https://example.com/modules.git"); err == nil {
+			t.Fatalf("want the failed clone to be an error every time")
+		}
+	}
+	if entries, _ := ioutil.ReadDir(filepath.Join(dir, "modules")); len(entries) != 0 {
+		t.Errorf("want nothing to be left in the cache but got %d entries", len(entries))
+	}
+}
+
+func TestExtractTarballOutside(t *testing.T) {
+	dir, err := ioutil.TempDir("", "recipe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	name := filepath.Join(dir, "evil.tar.gz")
+	if err := ioutil.WriteFile(name, testTarball(t, map[string]string{"../evil.yum": "evil"}), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := extractTarball(name, filepath.Join(dir, "out")); err == nil || !strings.Contains(err.Error(), "outside of the tarball") {
+		t.Errorf("want an error but got %v", err)
+	}
+}
@@ -0,0 +1,895 @@
+// Package recipe loads recipes, working out the order their blocks must be
+// evaluated in from the dependencies between them
+package recipe
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/hcl2/ext/typeexpr"
+	"github.com/hashicorp/hcl2/hcl"
+	"github.com/hashicorp/hcl2/hcl/hclsyntax"
+	"github.com/mikemackintosh/bakery/facts"
+	"github.com/mikemackintosh/bakery/pantry"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/convert"
+)
+
+// Recipe is a loaded recipe
+type Recipe struct {
+	Variables map[string]cty.Value
+
+	// Dir is the directory relative paths in the recipe are resolved from
+	Dir string
+
+	// Nodes are the blocks of the recipe and its modules in the order they
+	// were declared, and Runlist the order they are evaluated in
+	Nodes   []*Node
+	Runlist []*Node
+
+	// Version identifies the contents of the recipe files, and Results are
+	// the results of the resource and data blocks in the last run
+	Version string
+	Results []*Result
+
+	// Started and Finished, when they are set, are called as each resource
+	// and data block of a run starts and finishes, to follow the run as it
+	// happens. Blocks which are skipped without starting only finish.
+	Started  func(n *Node)
+	Finished func(res *Result)
+
+	root  *scope
+	facts cty.Value
+
+	// expanding are the resource types whose blocks are being loaded
+	expanding map[*resourceType]bool
+
+	// stopped is set when the run is asked to stop
+	mu      sync.Mutex
+	stopped bool
+}
+
+// scope is the recipe or one of its modules, holding the blocks which can be
+// referenced by the expressions within it
+type scope struct {
+	// prefix is prepended to the addresses of the blocks in a module, such
+	// as module.ssh.
+	prefix    string
+	dir       string
+	variables map[string]cty.Value
+	declared  map[string]hcl.Range
+
+	// required are the variables without a default, which must be set by
+	// the module block, and types the type constraints of variables
+	required map[string]bool
+	types    map[string]cty.Type
+
+	// resourceTypes are the composite resource types declared with
+	// resource_type blocks, and self is the name of the composite resource
+	// the scope belongs to
+	resourceTypes map[string]*resourceType
+	self          string
+
+	// nodes are the blocks by their address within the scope, and list the
+	// blocks whose values are added to the EvalContext
+	nodes map[string]*Node
+	list  []*Node
+}
+
+// newScope returns an empty scope
+func newScope(prefix, dir string) *scope {
+	return &scope{
+		prefix:    prefix,
+		dir:       dir,
+		variables: map[string]cty.Value{},
+		declared:  map[string]hcl.Range{},
+		required:  map[string]bool{},
+		types:     map[string]cty.Type{},
+		nodes:     map[string]*Node{},
+
+		resourceTypes: map[string]*resourceType{},
+	}
+}
+
+// Node is a resource, data block, local value, module or one of its inputs or
+// outputs in the recipe. A block with for_each or count is expanded into a
+// node for each of its instances.
+type Node struct {
+	// Address identifies the block, as type.name for resources,
+	// data.type.name for data blocks and local.name for local values, with
+	// the key of an instance appended such as brew.tools["jq"], and the
+	// module prepended such as module.ssh.shell.keys
+	Address string
+	Type    string
+	Name    string
+	Range   hcl.Range
+
+	// Key is the each.key or count.index of an instance, and cty.NilVal
+	// otherwise
+	Key cty.Value
+
+	Resource pantry.PantryInterface
+	Data     pantry.DataSource
+	Local    hcl.Expression
+
+	// DependsOn are the blocks which are evaluated before this one, either
+	// listed in depends_on or referenced by its expressions
+	DependsOn []*Node
+
+	// scope is where the expressions of the block are evaluated, which for
+	// the inputs of a module is the scope the module is declared in
+	scope *scope
+	block *hcl.Block
+	body  hcl.Body
+	value *cty.Value
+	index int
+
+	// forEach or count are set on a block which is expanded into instances,
+	// and instance holds the each or count values of an instance
+	forEach   hcl.Expression
+	count     hcl.Expression
+	instances []*Node
+	instance  map[string]cty.Value
+
+	// group is set on modules and composite resources, with their outputs
+	// and the depends_on of a composite resource
+	group          bool
+	outputs        []*Node
+	groupDependsOn *hcl.Attribute
+
+	// varType is the type constraint of a module or composite resource input
+	varType cty.Type
+
+	// running is set while the block runs, when its values are unknown to
+	// the blocks running alongside it
+	mu      sync.Mutex
+	running bool
+}
+
+// isData returns true for data blocks
+func (n *Node) isData() bool {
+	return n.block != nil && n.block.Type == "data"
+}
+
+// isResource returns true for resource blocks
+func (n *Node) isResource() bool {
+	return n.block != nil && n.block.Type != "data"
+}
+
+// isModule returns true for modules and composite resources, which group
+// their blocks but are not evaluated themselves
+func (n *Node) isModule() bool {
+	return n.group
+}
+
+// repeated returns true for a block which is expanded into instances
+func (n *Node) repeated() bool {
+	return n.forEach != nil || n.count != nil
+}
+
+// Outputs returns the values of the block which can be referenced, which for
+// a block with for_each is an object of its instances by key, for a block
+// with count a tuple of its instances, and for a module an object of its
+// outputs
+func (n *Node) Outputs() cty.Value {
+	if n.forEach != nil {
+		var values = map[string]cty.Value{}
+		for _, i := range n.instances {
+			values[i.Key.AsString()] = i.Outputs()
+		}
+		return cty.ObjectVal(values)
+	}
+
+	if n.count != nil {
+		var values []cty.Value
+		for _, i := range n.instances {
+			values = append(values, i.Outputs())
+		}
+		if len(values) == 0 {
+			return cty.EmptyTupleVal
+		}
+		return cty.TupleVal(values)
+	}
+
+	if n.isModule() {
+		var values = map[string]cty.Value{}
+		for _, o := range n.outputs {
+			values[o.Name] = o.Outputs()
+		}
+		return cty.ObjectVal(values)
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.running {
+		return cty.DynamicVal
+	}
+
+	if n.Local != nil {
+		if n.value == nil {
+			return cty.NullVal(cty.DynamicPseudoType)
+		}
+		return *n.value
+	}
+
+	if n.Data != nil {
+		return n.Data.Outputs()
+	}
+
+	if o, ok := n.Resource.(pantry.OutputInterface); ok {
+		return o.Outputs()
+	}
+
+	return cty.EmptyObjectVal
+}
+
+// schema returns the blocks a recipe can contain
+func schema() *hcl.BodySchema {
+	s := &hcl.BodySchema{
+		Blocks: []hcl.BlockHeaderSchema{
+			{Type: "variable", LabelNames: []string{"name"}},
+			{Type: "locals"},
+			{Type: "data", LabelNames: []string{"type", "name"}},
+			{Type: "module", LabelNames: []string{"name"}},
+			{Type: "output", LabelNames: []string{"name"}},
+			{Type: "resource_type", LabelNames: []string{"name"}},
+		},
+	}
+
+	for _, t := range pantry.ResourceTypes() {
+		s.Blocks = append(s.Blocks, hcl.BlockHeaderSchema{Type: t, LabelNames: []string{"name"}})
+	}
+
+	return s
+}
+
+// schema returns the blocks the scope can contain, including its composite
+// resource types
+func (s *scope) schema() *hcl.BodySchema {
+	bodySchema := schema()
+	for name := range s.resourceTypes {
+		bodySchema.Blocks = append(bodySchema.Blocks, hcl.BlockHeaderSchema{Type: name, LabelNames: []string{"name"}})
+	}
+
+	return bodySchema
+}
+
+// blockTypeHints adds the valid block types to the diagnostics for blocks of
+// an unknown type
+func blockTypeHints(diags hcl.Diagnostics, s *hcl.BodySchema) hcl.Diagnostics {
+	var types []string
+	for _, b := range s.Blocks {
+		types = append(types, b.Type)
+	}
+	sort.Strings(types)
+
+	for _, diag := range diags {
+		switch diag.Summary {
+		case "Unsupported block type", "Extraneous JSON object property":
+			diag.Detail += fmt.Sprintf(" The valid block types are %s.", strings.Join(types, ", "))
+		}
+	}
+
+	return diags
+}
+
+// LoadPath loads a recipe file, which is read from the bundle when its name
+// starts with bundle:, or every .yum and .yum.json file in a directory in
+// lexical order, along with the files they include. A recipe file or tarball
+// of a directory can also be fetched from an https:// URL, when it is signed
+// by a trusted key.
+func LoadPath(name string) (*Recipe, hcl.Diagnostics) {
+	switch {
+	case strings.HasPrefix(name, "https://"):
+		local, err := fetchRemote(name)
+		if err != nil {
+			return nil, hcl.Diagnostics{{
+				Severity: hcl.DiagError,
+				Summary:  "Failed to fetch recipe",
+				Detail:   err.Error(),
+			}}
+		}
+		name = local
+	case strings.HasPrefix(name, "http://"):
+		return nil, hcl.Diagnostics{{
+			Severity: hcl.DiagError,
+			Summary:  "Failed to fetch recipe",
+			Detail:   "Remote recipes must be fetched with https.",
+		}}
+	}
+
+	var names = []string{name}
+	var dir = filepath.Dir(name)
+
+	if info, err := os.Stat(name); err == nil && info.IsDir() {
+		if names, err = recipeFiles(name); err != nil {
+			return nil, hcl.Diagnostics{{
+				Severity: hcl.DiagError,
+				Summary:  "Failed to read recipe",
+				Detail:   err.Error(),
+			}}
+		}
+		dir = name
+	}
+
+	files, diags := parseRecipeFiles(names)
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	r, loadDiags := Load(hcl.MergeFiles(files), dir)
+	if r != nil {
+		r.Version = version(files)
+	}
+	return r, append(diags, loadDiags...)
+}
+
+// Load decodes the recipe body, and orders its blocks by their dependencies.
+// Blocks are only parsed as they are evaluated, so they can use the values of
+// the blocks they depend on. Relative paths are resolved from the directory.
+func Load(body hcl.Body, dir string) (*Recipe, hcl.Diagnostics) {
+	r := &Recipe{
+		Dir:   dir,
+		root:  newScope("", dir),
+		facts: factsValue(facts.Get()),
+	}
+	r.Variables = r.root.variables
+
+	diags := r.load(r.root, body, nil)
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	for _, n := range r.Nodes {
+		diags = append(diags, r.dependencies(n)...)
+	}
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	diags = append(diags, r.order()...)
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	diags = append(diags, r.expand()...)
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	return r, diags
+}
+
+// load adds the blocks of the body to the scope. The outputs of a module are
+// added to the module node.
+func (r *Recipe) load(s *scope, body hcl.Body, module *Node) hcl.Diagnostics {
+	// Resource types are declared first, so blocks can use them wherever
+	// they are declared in the recipe
+	types, _, diags := body.PartialContent(resourceTypeSchema)
+	if diags.HasErrors() {
+		return diags
+	}
+	for _, block := range types.Blocks {
+		diags = append(diags, r.addResourceType(s, block)...)
+	}
+	if diags.HasErrors() {
+		return diags
+	}
+
+	bodySchema := s.schema()
+	content, diags := body.Content(bodySchema)
+	if diags.HasErrors() {
+		return blockTypeHints(diags, bodySchema)
+	}
+
+	for _, block := range content.Blocks {
+		if t, ok := s.resourceTypes[block.Type]; ok {
+			diags = append(diags, r.addComposite(s, block, t)...)
+			continue
+		}
+
+		switch block.Type {
+		case "resource_type":
+		case "variable":
+			diags = append(diags, r.addVariable(s, block)...)
+		case "locals":
+			diags = append(diags, r.addLocals(s, block)...)
+		case "data":
+			diags = append(diags, r.addData(s, block)...)
+		case "module":
+			diags = append(diags, r.addModule(s, block)...)
+		case "output":
+			diags = append(diags, r.addOutput(s, block, module)...)
+		default:
+			diags = append(diags, r.addBlock(s, block, &Node{
+				Address: block.Type + "." + block.Labels[0],
+				Type:    block.Type,
+				Name:    block.Labels[0],
+				Range:   block.DefRange,
+			})...)
+		}
+	}
+
+	return diags
+}
+
+// addVariable evaluates the default value of a variable
+func (r *Recipe) addVariable(s *scope, block *hcl.Block) hcl.Diagnostics {
+	if existing, ok := s.declared[block.Labels[0]]; ok {
+		return hcl.Diagnostics{{
+			Severity: hcl.DiagError,
+			Summary:  "Duplicate variable",
+			Detail:   fmt.Sprintf("var.%s was already declared at %s.", block.Labels[0], existing),
+			Subject:  block.DefRange.Ptr(),
+		}}
+	}
+	s.declared[block.Labels[0]] = block.DefRange
+
+	content, diags := block.Body.Content(&hcl.BodySchema{
+		Attributes: []hcl.AttributeSchema{{Name: "default"}, {Name: "type"}},
+	})
+	if diags.HasErrors() {
+		return diags
+	}
+
+	var varType = cty.DynamicPseudoType
+	if attr, ok := content.Attributes["type"]; ok {
+		if varType, diags = typeexpr.TypeConstraint(attr.Expr); diags.HasErrors() {
+			return diags
+		}
+		s.types[block.Labels[0]] = varType
+	}
+
+	var val = cty.NullVal(varType)
+	if attr, ok := content.Attributes["default"]; ok {
+		val, diags = attr.Expr.Value(&hcl.EvalContext{Functions: Functions(s.dir)})
+		if diags.HasErrors() {
+			return diags
+		}
+
+		if val, diags = convertVariable(block.Labels[0], val, varType, attr.Expr.Range()); diags.HasErrors() {
+			return diags
+		}
+	} else {
+		s.required[block.Labels[0]] = true
+	}
+
+	s.variables[block.Labels[0]] = val
+	return nil
+}
+
+// convertVariable converts the value of a variable to its type
+func convertVariable(name string, val cty.Value, varType cty.Type, rng hcl.Range) (cty.Value, hcl.Diagnostics) {
+	out, err := convert.Convert(val, varType)
+	if err != nil {
+		return cty.NilVal, hcl.Diagnostics{{
+			Severity: hcl.DiagError,
+			Summary:  "Invalid value for variable",
+			Detail:   fmt.Sprintf("The value of var.%s must be %s: %s.", name, varType.FriendlyName(), err),
+			Subject:  rng.Ptr(),
+		}}
+	}
+
+	return out, nil
+}
+
+// addLocals adds each local value, which is evaluated like a block so it can
+// reference variables, facts, other locals and the values of blocks
+func (r *Recipe) addLocals(s *scope, block *hcl.Block) hcl.Diagnostics {
+	attrs, diags := block.Body.JustAttributes()
+	if diags.HasErrors() {
+		return diags
+	}
+
+	for _, attr := range sortedAttributes(attrs) {
+		diags = append(diags, r.addNode(s, &Node{
+			Address: "local." + attr.Name,
+			Type:    "local",
+			Name:    attr.Name,
+			Range:   attr.NameRange,
+			Local:   attr.Expr,
+		})...)
+	}
+
+	return diags
+}
+
+// sortedAttributes returns the attributes in the order they were declared
+func sortedAttributes(attrs hcl.Attributes) []*hcl.Attribute {
+	var out []*hcl.Attribute
+	for _, attr := range attrs {
+		out = append(out, attr)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Range.Start.Byte < out[j].Range.Start.Byte
+	})
+
+	return out
+}
+
+// addData adds a data block of a known type
+func (r *Recipe) addData(s *scope, block *hcl.Block) hcl.Diagnostics {
+	if _, ok := pantry.DataSources[block.Labels[0]]; !ok {
+		var types []string
+		for t := range pantry.DataSources {
+			types = append(types, t)
+		}
+		sort.Strings(types)
+
+		return hcl.Diagnostics{{
+			Severity: hcl.DiagError,
+			Summary:  "Unsupported data type",
+			Detail:   fmt.Sprintf("There is no data type %q, the data types are %s.", block.Labels[0], strings.Join(types, ", ")),
+			Subject:  block.LabelRanges[0].Ptr(),
+		}}
+	}
+
+	return r.addBlock(s, block, &Node{
+		Address: "data." + block.Labels[0] + "." + block.Labels[1],
+		Type:    block.Labels[0],
+		Name:    block.Labels[1],
+		Range:   block.DefRange,
+	})
+}
+
+// addBlock adds a resource or data block. A block with for_each or count is
+// added without a resource, which is created for each instance when the
+// block is expanded.
+func (r *Recipe) addBlock(s *scope, block *hcl.Block, n *Node) hcl.Diagnostics {
+	content, body, diags := block.Body.PartialContent(&hcl.BodySchema{
+		Attributes: []hcl.AttributeSchema{{Name: "for_each"}, {Name: "count"}},
+	})
+	if diags.HasErrors() {
+		return diags
+	}
+
+	n.block = block
+	n.body = body
+
+	forEach, hasForEach := content.Attributes["for_each"]
+	count, hasCount := content.Attributes["count"]
+	switch {
+	case hasForEach && hasCount:
+		return hcl.Diagnostics{{
+			Severity: hcl.DiagError,
+			Summary:  "Invalid count argument",
+			Detail:   "A block can use for_each or count, but not both.",
+			Subject:  count.NameRange.Ptr(),
+		}}
+	case hasForEach:
+		n.forEach = forEach.Expr
+	case hasCount:
+		n.count = count.Expr
+	default:
+		n.Resource, n.Data = r.create(block, n.Name, body)
+	}
+
+	return r.addNode(s, n)
+}
+
+// create returns the resource or data source for a block
+func (r *Recipe) create(block *hcl.Block, name string, body hcl.Body) (pantry.PantryInterface, pantry.DataSource) {
+	if block.Type == "data" {
+		return nil, pantry.DataSources[block.Labels[0]](name, body)
+	}
+
+	// The schema only allows registered block types
+	resource, _ := pantry.NewResource(block.Type, name, body)
+	return resource, nil
+}
+
+// addNode adds a block to the scope, where its values can be referenced
+func (r *Recipe) addNode(s *scope, n *Node) hcl.Diagnostics {
+	if diags := r.register(s, n); diags.HasErrors() {
+		return diags
+	}
+
+	s.list = append(s.list, n)
+	return nil
+}
+
+// register adds a block which can be referenced by its address within the
+// scope, unless one with the same address was already added. The address of
+// the block is prefixed with the module of the scope.
+func (r *Recipe) register(s *scope, n *Node) hcl.Diagnostics {
+	if existing, ok := s.nodes[n.Address]; ok {
+		return hcl.Diagnostics{{
+			Severity: hcl.DiagError,
+			Summary:  "Duplicate block",
+			Detail:   fmt.Sprintf("%s was already declared at %s.", s.prefix+n.Address, existing.Range),
+			Subject:  n.Range.Ptr(),
+		}}
+	}
+
+	s.nodes[n.Address] = n
+	n.Address = s.prefix + n.Address
+	if n.scope == nil {
+		n.scope = s
+	}
+
+	n.index = len(r.Nodes)
+	r.Nodes = append(r.Nodes, n)
+	return nil
+}
+
+// dependencies finds the blocks listed in depends_on, by name or address,
+// and the blocks referenced by the expressions of the block
+func (r *Recipe) dependencies(n *Node) hcl.Diagnostics {
+	var diags hcl.Diagnostics
+	var deps = map[*Node]bool{}
+
+	if n.isModule() {
+		if n.groupDependsOn == nil {
+			return nil
+		}
+
+		// The depends_on of a composite resource applies to all of its blocks
+		diags = r.dependsOn(n, n.groupDependsOn, deps)
+		for _, member := range n.DependsOn {
+			if member.isModule() {
+				continue
+			}
+			for dep := range deps {
+				member.DependsOn = append(member.DependsOn, dep)
+			}
+		}
+		return diags
+	}
+
+	if n.Local != nil {
+		return r.references(n, n.Local.Variables(), deps)
+	}
+
+	var refs = traversals(n.body)
+	for _, expr := range []hcl.Expression{n.forEach, n.count} {
+		if expr != nil {
+			refs = append(refs, expr.Variables()...)
+		}
+	}
+
+	content, _, _ := n.body.PartialContent(&hcl.BodySchema{
+		Attributes: []hcl.AttributeSchema{{Name: "depends_on"}},
+	})
+	if attr, ok := content.Attributes["depends_on"]; ok {
+		diags = append(diags, r.dependsOn(n, attr, deps)...)
+	}
+
+	return append(diags, r.references(n, refs, deps)...)
+}
+
+// dependsOn adds the blocks listed in depends_on, by name or address, to the
+// dependencies
+func (r *Recipe) dependsOn(n *Node, attr *hcl.Attribute, deps map[*Node]bool) hcl.Diagnostics {
+	val, diags := attr.Expr.Value(r.BlockEvalContext(n))
+	if diags.HasErrors() || val.Type() != cty.String || val.IsNull() {
+		return diags
+	}
+
+	for _, name := range strings.Split(val.AsString(), ",") {
+		dep, err := r.lookup(n.scope, strings.TrimSpace(name))
+		if err != nil {
+			diags = append(diags, &hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  "Invalid depends_on",
+				Detail:   err.Error(),
+				Subject:  attr.Expr.Range().Ptr(),
+			})
+			continue
+		}
+		deps[dep] = true
+	}
+
+	return diags
+}
+
+// references adds the blocks referenced by the traversals to the
+// dependencies of the node
+func (r *Recipe) references(n *Node, refs []hcl.Traversal, deps map[*Node]bool) hcl.Diagnostics {
+	var diags hcl.Diagnostics
+	for _, t := range refs {
+		dep, address, ok := r.reference(n.scope, t)
+		if !ok {
+			continue
+		}
+
+		if dep == nil {
+			diags = append(diags, &hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  "Reference to undeclared block",
+				Detail:   fmt.Sprintf("%s is not declared in the recipe.", n.scope.prefix+address),
+				Subject:  t.SourceRange().Ptr(),
+			})
+			continue
+		}
+		deps[dep] = true
+	}
+
+	for dep := range deps {
+		n.DependsOn = append(n.DependsOn, dep)
+	}
+	sort.Slice(n.DependsOn, func(i, j int) bool {
+		return n.DependsOn[i].index < n.DependsOn[j].index
+	})
+
+	return diags
+}
+
+// reference returns the block a traversal refers to in the scope. It returns
+// false when the traversal does not refer to a block, such as facts or a
+// variable which is not the input of a module.
+func (r *Recipe) reference(s *scope, t hcl.Traversal) (*Node, string, bool) {
+	address, ok := r.referenceAddress(s, t)
+	if !ok {
+		return nil, "", false
+	}
+
+	dep, ok := s.nodes[address]
+	if !ok && t.RootName() == "var" {
+		return nil, "", false
+	}
+
+	return dep, address, true
+}
+
+// lookup finds a block by its address, or a resource by its name when it is
+// the only one with that name. The address of an instance refers to the block
+// it is expanded from.
+func (r *Recipe) lookup(s *scope, name string) (*Node, error) {
+	if n, ok := s.nodes[name]; ok {
+		return n, nil
+	}
+
+	if i := strings.Index(name, "["); i > 0 {
+		if n, ok := s.nodes[name[:i]]; ok && n.repeated() {
+			return n, nil
+		}
+	}
+
+	var found []*Node
+	for _, n := range s.list {
+		if n.isResource() && n.Name == name {
+			found = append(found, n)
+		}
+	}
+
+	switch len(found) {
+	case 0:
+		return nil, fmt.Errorf("%q is not declared in the recipe", name)
+	case 1:
+		return found[0], nil
+	}
+
+	var addresses []string
+	for _, n := range found {
+		addresses = append(addresses, n.Address)
+	}
+	return nil, fmt.Errorf("%q could refer to %s, use the address instead", name, strings.Join(addresses, " or "))
+}
+
+// referenceAddress returns the address of the block a traversal refers to,
+// such as shell.name.stdout, data.exec.name.stdout, local.name, var.name,
+// module.name.output or the output of a composite resource
+func (r *Recipe) referenceAddress(s *scope, t hcl.Traversal) (string, bool) {
+	var root = t.RootName()
+	var parts = []string{root}
+	var length = 2
+	var group = root == "module" || s.resourceTypes[root] != nil
+
+	switch {
+	case root == "data", group:
+		length = 3
+	case root == "local", root == "var", pantry.IsResourceType(root):
+	default:
+		return "", false
+	}
+
+	for _, step := range t[1:] {
+		if len(parts) == length {
+			break
+		}
+
+		name, ok := stepName(step)
+		if !ok {
+			break
+		}
+		parts = append(parts, name)
+	}
+
+	// A module or composite resource can be referenced as a whole, as an
+	// object of its outputs
+	if len(parts) != length && !(group && len(parts) == 2) {
+		return "", false
+	}
+
+	return strings.Join(parts, "."), true
+}
+
+// stepName returns the name of an attribute, or the string key of an index
+func stepName(step hcl.Traverser) (string, bool) {
+	switch s := step.(type) {
+	case hcl.TraverseAttr:
+		return s.Name, true
+	case hcl.TraverseIndex:
+		if s.Key.Type() == cty.String && s.Key.IsKnown() && !s.Key.IsNull() {
+			return s.Key.AsString(), true
+		}
+	}
+
+	return "", false
+}
+
+// traversals returns the variables referenced by the expressions in the
+// body, including those of nested blocks
+func traversals(body hcl.Body) []hcl.Traversal {
+	var out []hcl.Traversal
+	if b, ok := body.(*hclsyntax.Body); ok {
+		for _, attr := range b.Attributes {
+			out = append(out, attr.Expr.Variables()...)
+		}
+		for _, block := range b.Blocks {
+			out = append(out, traversals(block.Body)...)
+		}
+		return out
+	}
+
+	attrs, _ := body.JustAttributes()
+	for _, attr := range attrs {
+		out = append(out, attr.Expr.Variables()...)
+	}
+
+	return out
+}
+
+// order sorts the blocks so each comes after its dependencies, keeping the
+// declared order otherwise, and reports any dependency cycles
+func (r *Recipe) order() hcl.Diagnostics {
+	var diags hcl.Diagnostics
+	var visited = map[*Node]bool{}
+	var visiting []*Node
+
+	var visit func(n *Node)
+	visit = func(n *Node) {
+		if visited[n] {
+			return
+		}
+
+		for i, v := range visiting {
+			if v != n {
+				continue
+			}
+
+			var cycle []string
+			for _, c := range append(visiting[i:], n) {
+				cycle = append(cycle, c.Address)
+			}
+			diags = append(diags, &hcl.Diagnostic{
+				Severity: hcl.DiagError,
+				Summary:  "Dependency cycle",
+				Detail:   fmt.Sprintf("The blocks depend on each other: %s.", strings.Join(cycle, " -> ")),
+				Subject:  n.Range.Ptr(),
+			})
+			return
+		}
+
+		visiting = append(visiting, n)
+		for _, dep := range n.DependsOn {
+			visit(dep)
+		}
+		visiting = visiting[:len(visiting)-1]
+
+		if !visited[n] {
+			visited[n] = true
+			r.Runlist = append(r.Runlist, n)
+		}
+	}
+
+	for _, n := range r.Nodes {
+		visit(n)
+	}
+
+	return diags
+}
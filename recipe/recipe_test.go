@@ -0,0 +1,495 @@
+package recipe
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/hcl2/hcl"
+	"github.com/hashicorp/hcl2/hclparse"
+	"github.com/mikemackintosh/bakery/config"
+	"github.com/mikemackintosh/bakery/pantry"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// testLoad parses and loads a recipe for the tests
+func testLoad(src string) (*Recipe, hcl.Diagnostics) {
+	file, diags := hclparse.NewParser().ParseHCL([]byte(src), "test.yum")
+	if diags.HasErrors() {
+		return nil, diags
+	}
+
+	return Load(file.Body, ".")
+}
+
+// addresses returns the addresses of the nodes
+func addresses(nodes []*Node) string {
+	var out []string
+	for _, n := range nodes {
+		out = append(out, n.Address)
+	}
+
+	return strings.Join(out, " ")
+}
+
+var testRunlists = []struct {
+	Src      string
+	Expected string
+}{
+	{
+		`shell "a" { script = "a" }
+		 shell "b" { script = "b" }`,
+		"shell.a shell.b",
+	},
+	{
+		`shell "a" { script = shell.b.stdout }
+		 shell "b" { script = "b" }`,
+		"shell.b shell.a",
+	},
+	{
+		`shell "a" {
+		   script     = "a"
+		   depends_on = "c"
+		 }
+		 shell "b" { script = "b" }
+		 brew "c" {}`,
+		"brew.c shell.a shell.b",
+	},
+	{
+		`shell "a" {
+		   script     = "a"
+		   depends_on = "data.exec.c"
+		 }
+		 shell "b" { script = shell["with space"].stdout }
+		 shell "with space" { script = data.exec.c.stdout }
+		 data "exec" "c" { command = "c" }`,
+		"data.exec.c shell.a shell.with space shell.b",
+	},
+	{
+		`shell "a" { script = local.greeting }
+		 locals {
+		   greeting = "hello ${local.name}"
+		   name     = var.name
+		 }
+		 variable "name" {}`,
+		"local.name local.greeting shell.a",
+	},
+	{
+		`shell "a" {
+		   script     = "a"
+		   depends_on = "tools"
+		 }
+		 brew "tools" { for_each = ["jq", "fzf"] }`,
+		`brew.tools["jq"] brew.tools["fzf"] shell.a`,
+	},
+	{
+		`shell "b" { script = shell.a["x"].stdout }
+		 shell "a" {
+		   for_each = local.scripts
+		   script   = each.value
+		 }
+		 locals {
+		   scripts = { y = "y", x = "x" }
+		 }`,
+		`local.scripts shell.a["x"] shell.a["y"] shell.b`,
+	},
+	{
+		`shell "a" {
+		   count  = var.count
+		   script = count.index
+		 }
+		 shell "none" {
+		   count  = 0
+		   script = "none"
+		 }
+		 variable "count" { default = 2 }`,
+		"shell.a[0] shell.a[1]",
+	},
+}
+
+func TestRunlist(t *testing.T) {
+	for _, test := range testRunlists {
+		r, diags := testLoad(test.Src)
+		if diags.HasErrors() {
+			t.Fatalf("want no error but got %s", diags)
+		}
+
+		if got := addresses(r.Runlist); got != test.Expected {
+			t.Errorf("want %s but got %s", test.Expected, got)
+		}
+	}
+}
+
+var testLoadErrors = []struct {
+	Src      string
+	Expected string
+}{
+	{`shell "a" { script = shell.b.stdout }`, "shell.b is not declared"},
+	{`shell "a" {
+	    script     = "a"
+	    depends_on = "b"
+	  }`, `"b" is not declared`},
+	{`shell "a" { script = "a" }
+	  brew "a" {}
+	  shell "b" {
+	    script     = "b"
+	    depends_on = "a"
+	  }`, "could refer to shell.a or brew.a"},
+	{`shell "a" { script = "a" }
+	  shell "a" { script = "a" }`, "shell.a was already declared"},
+	{`shell "a" { script = shell.b.stdout }
+	  shell "b" { script = shell.a.stdout }`, "shell.a -> shell.b -> shell.a"},
+	{`data "nope" "a" {}`, `no data type "nope"`},
+	{`locals { a = local.b }
+	  locals { b = local.a }`, "local.a -> local.b -> local.a"},
+	{`locals { a = "a" }
+	  locals { a = "b" }`, "local.a was already declared"},
+	{`shell "a" { script = local.missing }`, "local.missing is not declared"},
+	{`shell "a" {
+	    for_each = ["a"]
+	    count    = 1
+	  }`, "for_each or count, but not both"},
+	{`data "exec" "tools" { command = "ls" }
+	  brew "tools" { for_each = data.exec.tools.stdout }`, "must be known before the run starts"},
+	{`locals { tools = data.exec.tools.stdout }
+	  data "exec" "tools" { command = "ls" }
+	  brew "tools" { for_each = local.tools }`, "must be known before the run starts"},
+	{`brew "tools" { for_each = ["jq", "jq"] }`, `contains "jq" more than once`},
+	{`brew "tools" { for_each = 3 }`, "must be a list, set or map, not number"},
+	{`brew "tools" { count = -1 }`, "not less than zero"},
+	{`shel "a" { script = "a" }`, `Did you mean "shell"? The valid block types are brew, brewfile, cargo_install,`},
+}
+
+func TestInstanceNames(t *testing.T) {
+	r, diags := testLoad(`
+brew "tools" { for_each = toset(["jq", "fzf"]) }
+brew "repeated" { count = 1 }
+`)
+	if diags.HasErrors() {
+		t.Fatalf("want no error but got %s", diags)
+	}
+
+	var names []string
+	for _, n := range r.Runlist {
+		if brew, ok := n.Resource.(*pantry.Brew); ok {
+			names = append(names, brew.Name)
+		}
+	}
+	if got := strings.Join(names, " "); got != "fzf jq repeated" {
+		t.Errorf("want fzf jq repeated but got %s", got)
+	}
+}
+
+// testWidget is a resource type registered by the tests
+type testWidget struct {
+	pantry.PantryItem
+	baked bool
+}
+
+func (w *testWidget) Parse(*hcl.EvalContext) error { return nil }
+func (w *testWidget) Bake()                        { w.baked = true }
+
+// Failed fails the widgets named broken
+func (w *testWidget) Failed() error {
+	if w.Name == "broken" {
+		return fmt.Errorf("the widget is broken")
+	}
+	return nil
+}
+
+var testWidgetOnce sync.Once
+
+// registerTestWidget registers the test_widget resource type, once
+func registerTestWidget() {
+	testWidgetOnce.Do(func() {
+		pantry.Register("test_widget", func(name string, config hcl.Body) pantry.PantryInterface {
+			return &testWidget{PantryItem: pantry.PantryItem{Name: name, Config: config}}
+		})
+	})
+}
+
+func TestRegisteredType(t *testing.T) {
+	registerTestWidget()
+
+	r, diags := testLoad(`
+test_widget "a" {}
+shell "b" {
+  script     = "b"
+  depends_on = "test_widget.a"
+}
+`)
+	if diags.HasErrors() {
+		t.Fatalf("want no error but got %s", diags)
+	}
+	if got := addresses(r.Runlist); got != "test_widget.a shell.b" {
+		t.Errorf("want test_widget.a shell.b but got %s", got)
+	}
+	if w, ok := r.Runlist[0].Resource.(*testWidget); !ok || w.Name != "a" {
+		t.Errorf("want a test widget named a but got %#v", r.Runlist[0].Resource)
+	}
+
+	r, diags = testLoad(`
+test_widget "broken" {}
+shell "b" { script = "b" }
+`)
+	if diags.HasErrors() {
+		t.Fatalf("want no error but got %s", diags)
+	}
+	if err := r.Run(); err == nil || err.Error() != "test_widget.broken: the widget is broken" {
+		t.Errorf("want the run to stop at the broken widget but got %v", err)
+	}
+}
+
+func TestFailurePolicy(t *testing.T) {
+	registerTestWidget()
+
+	previous := *config.Registry
+	config.Registry.Parallelism = 2
+	config.Registry.FailurePolicy = config.FailureContinue
+	defer func() {
+		*config.Registry = previous
+	}()
+
+	r, diags := testLoad(`
+test_widget "broken" {}
+test_widget "after" { depends_on = "broken" }
+test_widget "a" {}
+test_widget "b" { depends_on = "a" }
+`)
+	if diags.HasErrors() {
+		t.Fatalf("want no error but got %s", diags)
+	}
+	var started, finished []string
+	r.Started = func(n *Node) { started = append(started, n.Name) }
+	r.Finished = func(res *Result) { finished = append(finished, res.Name) }
+
+	if err := r.Run(); err == nil || err.Error() != "test_widget.broken: the widget is broken" {
+		t.Errorf("want the broken widget to fail but got %v", err)
+	}
+	if len(started) != 3 || len(finished) != 4 {
+		t.Errorf("want 3 blocks to start and 4 to finish but got %v and %v", started, finished)
+	}
+
+	for _, n := range r.Runlist {
+		var want = n.Name != "after"
+		if got := n.Resource.(*testWidget).baked; got != want {
+			t.Errorf("want %s baked to be %t but got %t", n.Address, want, got)
+		}
+	}
+
+	var statuses []string
+	for _, res := range r.Results {
+		statuses = append(statuses, strings.TrimSuffix(res.Address+"="+res.Status+":"+res.Reason, ":"))
+	}
+	want := "test_widget.broken=failed test_widget.after=skipped:dependency_failed test_widget.a=changed test_widget.b=changed"
+	if got := strings.Join(statuses, " "); got != want {
+		t.Errorf("want %s but got %s", want, got)
+	}
+}
+
+var shellFailurePolicyTests = []struct {
+	Policy   string
+	Expected string
+}{
+	{config.FailureStop, "shell.broken=failed shell.after=skipped:stopped shell.other=skipped:stopped"},
+	{config.FailureContinue, "shell.broken=failed shell.after=skipped:dependency_failed shell.other=changed"},
+}
+
+func TestShellFailurePolicy(t *testing.T) {
+	for _, test := range shellFailurePolicyTests {
+		dir, err := ioutil.TempDir("", "recipe")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		previous := *config.Registry
+		config.Registry.TempDir = dir
+		config.Registry.FailurePolicy = test.Policy
+
+		r, diags := testLoad(fmt.Sprintf(`
+shell "broken" { script = "exit 3" }
+shell "after" {
+  script     = "touch %[1]s/after"
+  depends_on = "broken"
+}
+shell "other" { script = "touch %[1]s/other" }
+`, dir))
+		if diags.HasErrors() {
+			t.Fatalf("want no error but got %s", diags)
+		}
+
+		if err := r.Run(); err == nil || err.Error() != "shell.broken: exit status 3" {
+			t.Errorf("want the broken shell to fail with %s but got %v", test.Policy, err)
+		}
+		*config.Registry = previous
+
+		var statuses []string
+		for _, res := range r.Results {
+			statuses = append(statuses, strings.TrimSuffix(res.Address+"="+res.Status+":"+res.Reason, ":"))
+		}
+		if got := strings.Join(statuses, " "); got != test.Expected {
+			t.Errorf("want %s with %s but got %s", test.Expected, test.Policy, got)
+		}
+		if _, err := os.Stat(dir + "/after"); !os.IsNotExist(err) {
+			t.Errorf("want the shell after the broken one not to run with %s", test.Policy)
+		}
+		if _, err := os.Stat(dir + "/other"); os.IsNotExist(err) != (test.Policy == config.FailureStop) {
+			t.Errorf("want the other shell to run only with %s", config.FailureContinue)
+		}
+	}
+}
+
+func TestStop(t *testing.T) {
+	registerTestWidget()
+
+	r, diags := testLoad(`test_widget "a" {}`)
+	if diags.HasErrors() {
+		t.Fatalf("want no error but got %s", diags)
+	}
+
+	r.Stop()
+	if err := r.Run(); err == nil || err.Error() != "The run was stopped before it finished" {
+		t.Errorf("want the run to be stopped but got %v", err)
+	}
+	if r.Runlist[0].Resource.(*testWidget).baked {
+		t.Errorf("want nothing to run once the run is stopped")
+	}
+}
+
+func TestRunInstances(t *testing.T) {
+	dir, err := ioutil.TempDir("", "recipe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	config.Registry.TempDir = dir
+
+	previous := pantry.Runner
+	pantry.Runner = scriptRunner{}
+	defer func() {
+		pantry.Runner = previous
+	}()
+
+	r, diags := testLoad(`
+shell "all" {
+  script = join(",", [for s in shell.greet : s.stdout])
+}
+
+shell "greet" {
+  for_each = { a = "x", b = "y" }
+  script   = "${each.key}=${each.value}"
+}
+
+shell "index" {
+  count  = 2
+  script = "index ${count.index}"
+}
+`)
+	if diags.HasErrors() {
+		t.Fatalf("want no error but got %s", diags)
+	}
+
+	if err := r.Run(); err != nil {
+		t.Fatalf("want no error but got %s", err)
+	}
+
+	shells := r.EvalContext().Variables["shell"]
+	if got := shells.GetAttr("all").GetAttr("stdout").AsString(); got != "a=x,b=y" {
+		t.Errorf("want a=x,b=y but got %s", got)
+	}
+	if got := shells.GetAttr("index").Index(cty.NumberIntVal(1)).GetAttr("stdout").AsString(); got != "index 1" {
+		t.Errorf("want index 1 but got %s", got)
+	}
+}
+
+func TestFacts(t *testing.T) {
+	r, diags := testLoad(`locals { os = facts.os }`)
+	if diags.HasErrors() {
+		t.Fatalf("want no error but got %s", diags)
+	}
+	r.facts = cty.ObjectVal(map[string]cty.Value{"os": cty.StringVal("darwin")})
+
+	if err := r.Run(); err != nil {
+		t.Fatalf("want no error but got %s", err)
+	}
+
+	got := r.EvalContext().Variables["local"].GetAttr("os")
+	if !got.RawEquals(cty.StringVal("darwin")) {
+		t.Errorf("want darwin but got %#v", got)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	for _, test := range testLoadErrors {
+		_, diags := testLoad(test.Src)
+		if !strings.Contains(diags.Error(), test.Expected) {
+			t.Errorf("want %s but got %s", test.Expected, diags)
+		}
+	}
+}
+
+// scriptRunner runs scripts by echoing their contents
+type scriptRunner struct{}
+
+func (scriptRunner) Run(c *pantry.Command) (*pantry.CommandResponse, error) {
+	var out = "exec"
+	if len(c.Args) == 2 {
+		b, _ := ioutil.ReadFile(c.Args[1])
+		out = string(b)
+	}
+
+	return &pantry.CommandResponse{Raw: out, Stdout: out}, nil
+}
+
+func TestRunOutputs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "recipe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	config.Registry.TempDir = dir
+
+	previous := pantry.Runner
+	pantry.Runner = scriptRunner{}
+	defer func() {
+		pantry.Runner = previous
+	}()
+
+	r, diags := testLoad(`
+variable "name" {
+  default = "world"
+}
+
+locals {
+  greeting = "hello ${shell.name.stdout}"
+}
+
+shell "greet" {
+  script = "${local.greeting} from ${data.exec.from.stdout}"
+}
+
+shell "name" {
+  script = var.name
+}
+
+data "exec" "from" {
+  command = "from"
+}
+`)
+	if diags.HasErrors() {
+		t.Fatalf("want no error but got %s", diags)
+	}
+
+	if err := r.Run(); err != nil {
+		t.Fatalf("want no error but got %s", err)
+	}
+
+	got := r.EvalContext().Variables["shell"].GetAttr("greet").GetAttr("stdout").AsString()
+	if got != "hello world from exec" {
+		t.Errorf("want hello world from exec but got %s", got)
+	}
+}
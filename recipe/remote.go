@@ -0,0 +1,234 @@
+package recipe
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mikemackintosh/bakery/cli"
+	"github.com/mikemackintosh/bakery/config"
+	"github.com/mikemackintosh/bakery/pantry"
+)
+
+// TrustedKeys are the base64 encoded ed25519 public keys remote recipes must
+// be signed with, separated by commas. They are built into the binary with
+// -ldflags "-X github.com/mikemackintosh/bakery/recipe.TrustedKeys=<keys>".
+var TrustedKeys string
+
+// signatureSuffix is appended to the URL of a remote recipe to fetch its
+// detached signature
+const signatureSuffix = ".sig"
+
+// remoteClient fetches remote recipes
+var remoteClient = &http.Client{Timeout: time.Minute}
+
+// remoteRecipe is a recipe file or tarball fetched from a URL, with its
+// signature and ETag
+type remoteRecipe struct {
+	body      []byte
+	signature []byte
+	etag      string
+}
+
+// fetchRemote fetches a recipe file, or a gzipped tarball of a recipe
+// directory, and returns the path it can be loaded from once its signature
+// is verified. The last good copy is cached with its ETag, so it is only
+// downloaded again when it changes, and is used when the recipe cannot be
+// fetched.
+func fetchRemote(source string) (string, error) {
+	keys, err := trustedKeys()
+	if err != nil {
+		return "", err
+	}
+
+	var dir = filepath.Join(config.Registry.CacheDir, "recipes", fmt.Sprintf("%x", sha256.Sum256([]byte(source))))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	var name = filepath.Join(dir, "recipe")
+	var etagName = filepath.Join(dir, "etag")
+	var etag string
+	if pantry.FileExists(name) {
+		b, _ := ioutil.ReadFile(etagName)
+		etag = strings.TrimSpace(string(b))
+	}
+
+	fetched, err := fetchSigned(source, etag)
+	switch {
+	case err != nil && pantry.FileExists(name):
+		cli.Debug(cli.WARNING, "Using the last good copy of the recipe, as it could not be fetched", err)
+	case err != nil:
+		return "", fmt.Errorf("Error fetching recipe %s: %s", source, err)
+	case fetched == nil:
+		cli.Debug(cli.INFO, "Using the cached recipe, as it has not changed", source)
+	default:
+		if err := verifySignature(fetched.body, fetched.signature, keys); err != nil {
+			return "", fmt.Errorf("The recipe from %s %s", source, err)
+		}
+
+		if err := writeFileAtomic(name, fetched.body); err != nil {
+			return "", err
+		}
+		if err := writeFileAtomic(name+signatureSuffix, fetched.signature); err != nil {
+			return "", err
+		}
+		if err := writeFileAtomic(etagName, []byte(fetched.etag)); err != nil {
+			return "", err
+		}
+	}
+
+	// The cached copy is verified every time it is used, not only when it is
+	// downloaded
+	body, err := ioutil.ReadFile(name)
+	if err != nil {
+		return "", err
+	}
+	signature, err := ioutil.ReadFile(name + signatureSuffix)
+	if err != nil {
+		return "", err
+	}
+	if err := verifySignature(body, signature, keys); err != nil {
+		return "", fmt.Errorf("The cached recipe from %s %s", source, err)
+	}
+
+	return unpackRemote(source, name, body)
+}
+
+// fetchSigned fetches the recipe and its signature, or returns nil when the
+// recipe has not changed since the ETag
+func fetchSigned(source, etag string) (*remoteRecipe, error) {
+	source = config.Registry.Mirror(source)
+
+	body, etag, err := fetchURL(source, etag)
+	if err != nil || body == nil {
+		return nil, err
+	}
+
+	signature, _, err := fetchURL(source+signatureSuffix, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return &remoteRecipe{body: body, signature: signature, etag: etag}, nil
+}
+
+// fetchURL returns the body of the URL and its ETag, or nil when it matches
+// the ETag it was fetched with
+func fetchURL(source, etag string) ([]byte, string, error) {
+	req, err := http.NewRequest(http.MethodGet, source, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	resp, err := remoteClient.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified && etag != "" {
+		return nil, etag, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("%s returned %s", source, resp.Status)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return body, resp.Header.Get("ETag"), nil
+}
+
+// unpackRemote unpacks the cached recipe, returning the recipe file, or the
+// directory a tarball was extracted into
+func unpackRemote(source, name string, body []byte) (string, error) {
+	var current = filepath.Join(filepath.Dir(name), "current")
+	if err := os.RemoveAll(current); err != nil {
+		return "", err
+	}
+
+	if bytes.HasPrefix(body, []byte{0x1f, 0x8b}) {
+		if err := extractTarball(name, current); err != nil {
+			return "", fmt.Errorf("Error extracting recipe %s: %s", source, err)
+		}
+		return tarballRoot(current)
+	}
+
+	if err := os.MkdirAll(current, 0755); err != nil {
+		return "", err
+	}
+
+	// The file keeps its name, so a JSON recipe is parsed as JSON
+	var file = "config.yum"
+	if u, err := url.Parse(source); err == nil && path.Base(u.Path) != "/" && path.Base(u.Path) != "." {
+		file = path.Base(u.Path)
+	}
+	file = filepath.Join(current, file)
+
+	return file, ioutil.WriteFile(file, body, 0644)
+}
+
+// trustedKeys returns the public keys built into the binary
+func trustedKeys() ([]ed25519.PublicKey, error) {
+	var keys []ed25519.PublicKey
+	for _, k := range strings.Split(TrustedKeys, ",") {
+		if k = strings.TrimSpace(k); k == "" {
+			continue
+		}
+
+		b, err := base64.StdEncoding.DecodeString(k)
+		if err != nil || len(b) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("The trusted key %q is not a base64 encoded ed25519 public key", k)
+		}
+		keys = append(keys, ed25519.PublicKey(b))
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("Remote recipes cannot be used, as no trusted keys were built into bakery")
+	}
+
+	return keys, nil
+}
+
+// verifySignature returns an error unless the base64 encoded signature of
+// the body was made by one of the keys
+func verifySignature(body, signature []byte, keys []ed25519.PublicKey) error {
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(signature)))
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return fmt.Errorf("has an invalid signature, which must be a base64 encoded ed25519 signature")
+	}
+
+	for _, key := range keys {
+		if ed25519.Verify(key, body, sig) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("is not signed by a trusted key")
+}
+
+// writeFileAtomic writes the file by renaming a temporary file over it, so
+// it is never left half written
+func writeFileAtomic(name string, b []byte) error {
+	if err := ioutil.WriteFile(name+".tmp", b, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(name+".tmp", name)
+}
@@ -0,0 +1,126 @@
+package recipe
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/mikemackintosh/bakery/config"
+)
+
+func TestRemoteRecipe(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, untrusted, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sign := func(key ed25519.PrivateKey, b []byte) string {
+		return base64.StdEncoding.EncodeToString(ed25519.Sign(key, b))
+	}
+
+	var recipe = `shell "a" { script = "a" }`
+	var tarball = testTarball(t, map[string]string{
+		"recipes/a.yum":      `shell "a" { script = "a" }`,
+		"recipes/b.yum.json": `{"shell": {"b": {"script": "b"}}}`,
+	})
+	var files = map[string]string{
+		"/config.yum":         recipe,
+		"/config.yum.sig":     sign(private, []byte(recipe)),
+		"/recipes.tar.gz":     string(tarball),
+		"/recipes.tar.gz.sig": sign(private, tarball),
+		"/tampered.yum":       `shell "a" { script = "tampered" }`,
+		"/tampered.yum.sig":   sign(private, []byte(recipe)),
+		"/untrusted.yum":      recipe,
+		"/untrusted.yum.sig":  sign(untrusted, []byte(recipe)),
+	}
+
+	var fetched int
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		content, ok := files[req.URL.Path]
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+
+		w.Header().Set("ETag", `"v1"`)
+		if req.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		fetched++
+		w.Write([]byte(content))
+	}))
+
+	previous := remoteClient
+	remoteClient = ts.Client()
+	defer func() {
+		remoteClient = previous
+		TrustedKeys = ""
+	}()
+
+	dir := writeFiles(t, nil)
+	defer os.RemoveAll(dir)
+	config.Registry.CacheDir = dir
+
+	if _, diags := LoadPath(ts.URL + "/config.yum"); !strings.Contains(diags.Error(), "no trusted keys were built into bakery") {
+		t.Errorf("want an error without trusted keys but got %s", diags)
+	}
+	TrustedKeys = base64.StdEncoding.EncodeToString(public)
+
+	r, diags := LoadPath(ts.URL + "/config.yum")
+	if diags.HasErrors() {
+		t.Fatalf("want no error but got %s", diags)
+	}
+	if got := addresses(r.Runlist); got != "shell.a" {
+		t.Errorf("want shell.a but got %s", got)
+	}
+
+	// The recipe has not changed, so only its ETag is checked
+	fetched = 0
+	if _, diags := LoadPath(ts.URL + "/config.yum"); diags.HasErrors() {
+		t.Fatalf("want no error but got %s", diags)
+	}
+	if fetched != 0 {
+		t.Errorf("want the cached recipe to be used but it was fetched %d times", fetched)
+	}
+
+	r, diags = LoadPath(ts.URL + "/recipes.tar.gz")
+	if diags.HasErrors() {
+		t.Fatalf("want no error but got %s", diags)
+	}
+	if got := addresses(r.Runlist); got != "shell.a shell.b" {
+		t.Errorf("want shell.a shell.b but got %s", got)
+	}
+
+	for _, name := range []string{"/tampered.yum", "/untrusted.yum"} {
+		if _, diags := LoadPath(ts.URL + name); !strings.Contains(diags.Error(), "is not signed by a trusted key") {
+			t.Errorf("want %s to be rejected but got %s", name, diags)
+		}
+	}
+	if _, diags := LoadPath(ts.URL + "/missing.yum"); !strings.Contains(diags.Error(), "404 Not Found") {
+		t.Errorf("want an error for a missing recipe but got %s", diags)
+	}
+	if _, diags := LoadPath("http://example.com/config.yum"); !strings.Contains(diags.Error(), "must be fetched with https") {
+		t.Errorf("want an error for an http recipe but got %s", diags)
+	}
+
+	// The last good copy is used while the server is down
+	url := ts.URL
+	ts.Close()
+	r, diags = LoadPath(url + "/config.yum")
+	if diags.HasErrors() {
+		t.Fatalf("want the cached recipe but got %s", diags)
+	}
+	if got := addresses(r.Runlist); got != "shell.a" {
+		t.Errorf("want shell.a but got %s", got)
+	}
+}
@@ -0,0 +1,437 @@
+package recipe
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/hcl2/hcl"
+	"github.com/mikemackintosh/bakery/cli"
+	"github.com/mikemackintosh/bakery/config"
+	"github.com/mikemackintosh/bakery/facts"
+	"github.com/mikemackintosh/bakery/pantry"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// EvalContext returns the values the blocks of the recipe can reference:
+// var, facts, local, module, the outputs of resources by type and name, and
+// data by type and name
+func (r *Recipe) EvalContext() *hcl.EvalContext {
+	return r.scopeEvalContext(r.root)
+}
+
+// scopeEvalContext returns the values the blocks of the recipe or a module
+// can reference
+func (r *Recipe) scopeEvalContext(s *scope) *hcl.EvalContext {
+	var resources = map[string]map[string]cty.Value{}
+	var data = map[string]map[string]cty.Value{}
+	var vars = map[string]cty.Value{}
+	for k, v := range s.variables {
+		vars[k] = v
+	}
+
+	for _, n := range s.list {
+		if n.Type == "var" {
+			vars[n.Name] = n.Outputs()
+			continue
+		}
+
+		var values = resources
+		if n.isData() {
+			values = data
+		}
+
+		if values[n.Type] == nil {
+			values[n.Type] = map[string]cty.Value{}
+		}
+		values[n.Type][n.Name] = n.Outputs()
+	}
+
+	var variables = map[string]cty.Value{
+		"var":   cty.ObjectVal(vars),
+		"facts": r.facts,
+		"data":  objects(data),
+	}
+	for t, values := range resources {
+		variables[t] = cty.ObjectVal(values)
+	}
+	if s.self != "" {
+		variables["self"] = cty.ObjectVal(map[string]cty.Value{"name": cty.StringVal(s.self)})
+	}
+	for _, t := range []string{"local", "module"} {
+		if _, ok := variables[t]; !ok {
+			variables[t] = cty.EmptyObjectVal
+		}
+	}
+
+	return &hcl.EvalContext{
+		Variables: variables,
+		Functions: Functions(s.dir),
+	}
+}
+
+// BlockEvalContext returns the EvalContext for a block, which for an instance
+// of a block with for_each or count includes each or count
+func (r *Recipe) BlockEvalContext(n *Node) *hcl.EvalContext {
+	ctx := r.scopeEvalContext(n.scope)
+	for k, v := range n.instance {
+		ctx.Variables[k] = v
+	}
+
+	return ctx
+}
+
+// factsValue returns the facts about the host as an object
+func factsValue(f *facts.Facts) cty.Value {
+	return cty.ObjectVal(map[string]cty.Value{
+		"os":               cty.StringVal(f.OS),
+		"arch":             cty.StringVal(f.Arch),
+		"hostname":         cty.StringVal(f.Hostname),
+		"platform":         cty.StringVal(f.Platform),
+		"platform_family":  cty.StringVal(f.PlatformFamily),
+		"platform_version": cty.StringVal(f.PlatformVersion),
+	})
+}
+
+// objects returns an object of objects
+func objects(values map[string]map[string]cty.Value) cty.Value {
+	var out = map[string]cty.Value{}
+	for k, v := range values {
+		out[k] = cty.ObjectVal(v)
+	}
+
+	return cty.ObjectVal(out)
+}
+
+// evaluate evaluates a local value, or the input of a module or composite
+// resource converted to the type of its variable
+func (r *Recipe) evaluate(n *Node) (cty.Value, hcl.Diagnostics) {
+	val, diags := n.Local.Value(r.BlockEvalContext(n))
+	if diags.HasErrors() || n.varType == cty.NilType {
+		return val, diags
+	}
+
+	return convertVariable(n.Name, val, n.varType, n.Local.Range())
+}
+
+// Statuses of the resources and data blocks in a run
+const (
+	StatusChanged   = "changed"
+	StatusUnchanged = "unchanged"
+	StatusSkipped   = "skipped"
+	StatusFailed    = "failed"
+)
+
+// Reasons a resource or data block was skipped
+const (
+	ReasonNotIf            = "not_if"
+	ReasonOnlyIf           = "only_if"
+	ReasonDependencyFailed = "dependency_failed"
+	ReasonStopped          = "stopped"
+	ReasonAlreadyPresent   = "already_present"
+)
+
+// Result is what happened to a resource or data block in a run. A resource
+// which was baked has changed, unless it reports that it was already
+// present.
+type Result struct {
+	Address  string
+	Type     string
+	Name     string
+	Status   string
+	Reason   string
+	Duration time.Duration
+	Err      error
+
+	// phase is how far the block got: evaluate, parse, check, bake or read
+	phase string
+	node  *Node
+}
+
+// Changed returns true when the block changed the host
+func (res *Result) Changed() bool {
+	return res.Status == StatusChanged
+}
+
+// Run evaluates the blocks in the runlist, reading data and baking
+// resources. Each block runs once the blocks it depends on have run, with up
+// to the configured parallelism running at once, in runlist order. When a
+// block fails the run stops, or with the continue failure policy, carries on
+// with the blocks which do not depend on it. Stop also stops the run. The
+// result of each resource and data block is kept in Results.
+func (r *Recipe) Run() error {
+	var parallelism = config.Registry.Parallelism
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	var carryOn = config.Registry.FailurePolicy == config.FailureContinue
+
+	var (
+		pending  = r.Runlist
+		done     = map[*Node]bool{}
+		failed   = map[*Node]bool{}
+		finished = map[*Node]*Result{}
+		results  = make(chan *Result)
+		running  int
+		errs     []string
+		stop     bool
+	)
+
+	for len(pending) > 0 || running > 0 {
+		if !stop && r.isStopped() {
+			errs = append(errs, "The run was stopped before it finished")
+			stop = true
+		}
+
+		var waiting []*Node
+		for _, n := range pending {
+			switch {
+			case stop:
+				// Nothing more starts once the run is stopping
+			case dependsOnAny(n, failed):
+				failed[n] = true
+				finished[n] = newResult(n, StatusSkipped, ReasonDependencyFailed)
+				cli.Log(cli.WARNING, "Skipping, a block it depends on failed", finished[n].fields())
+				r.finished(finished[n])
+			case running < parallelism && dependsOnAll(n, done):
+				running++
+				n.setRunning(true)
+				r.started(n)
+				go func(n *Node) {
+					var started = time.Now()
+					res := r.run(n)
+					res.Duration = time.Since(started)
+					res.log()
+					results <- res
+				}(n)
+			default:
+				waiting = append(waiting, n)
+			}
+		}
+		pending = waiting
+
+		if running == 0 {
+			break
+		}
+
+		res := <-results
+		running--
+		res.node.setRunning(false)
+		done[res.node] = true
+		finished[res.node] = res
+		r.finished(res)
+		if res.Err != nil {
+			failed[res.node] = true
+			errs = append(errs, fmt.Sprintf("%s: %s", res.Address, res.Err))
+			stop = !carryOn
+		}
+	}
+
+	// Blocks which never started were skipped when the run stopped
+	r.Results = nil
+	for _, n := range r.Runlist {
+		if n.Local != nil {
+			continue
+		}
+
+		res, ok := finished[n]
+		if !ok {
+			res = newResult(n, StatusSkipped, ReasonStopped)
+			r.finished(res)
+		}
+		r.Results = append(r.Results, res)
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "\n"))
+	}
+
+	return nil
+}
+
+// newResult returns the result of a block
+func newResult(n *Node, status, reason string) *Result {
+	return &Result{Address: n.Address, Type: n.Type, Name: n.Name, Status: status, Reason: reason, node: n}
+}
+
+// started calls Started for a resource or data block which starts
+func (r *Recipe) started(n *Node) {
+	if r.Started != nil && n.Local == nil {
+		r.Started(n)
+	}
+}
+
+// finished calls Finished for a resource or data block which finishes
+func (r *Recipe) finished(res *Result) {
+	if r.Finished != nil && res.node.Local == nil {
+		r.Finished(res)
+	}
+}
+
+// fields returns the fields the block and its result are logged with
+func (res *Result) fields() cli.Fields {
+	var fields = cli.Fields{
+		"address": res.Address,
+		"type":    res.Type,
+		"name":    res.Name,
+		"status":  res.Status,
+	}
+	if res.phase != "" {
+		fields["phase"] = res.phase
+	}
+	if res.Reason != "" {
+		fields["reason"] = res.Reason
+	}
+	if res.Duration > 0 {
+		fields["duration"] = res.Duration
+	}
+	if res.Err != nil {
+		fields["error"] = res.Err
+	}
+
+	return fields
+}
+
+// log logs the result of a block which ran, as an error when it failed.
+// Local values log their value as they are evaluated instead.
+func (res *Result) log() {
+	switch {
+	case res.Status == StatusFailed:
+		cli.Log(cli.ERROR, "Failed", res.fields())
+	case res.node.Local != nil:
+	case res.Status == StatusSkipped:
+		cli.Log(cli.INFO, "Skipped", res.fields())
+	default:
+		cli.Log(cli.INFO, "Finished", res.fields())
+	}
+}
+
+// logPhase logs that the block is starting a phase
+func logPhase(n *Node, phase string) {
+	cli.Log(cli.DEBUG, "Starting "+phase, cli.Fields{
+		"address": n.Address,
+		"type":    n.Type,
+		"name":    n.Name,
+		"phase":   phase,
+	})
+}
+
+// Stop stops the run once the blocks which are running finish, without
+// starting any more
+func (r *Recipe) Stop() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stopped = true
+}
+
+// isStopped returns true once the run is asked to stop
+func (r *Recipe) isStopped() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.stopped
+}
+
+// setRunning marks the block as running, or as having run
+func (n *Node) setRunning(running bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.running = running
+}
+
+// dependsOnAny returns true when the block depends on any of the blocks
+func dependsOnAny(n *Node, nodes map[*Node]bool) bool {
+	for _, dep := range n.DependsOn {
+		if nodes[dep] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// dependsOnAll returns true when all of the blocks the block depends on are
+// in the blocks
+func dependsOnAll(n *Node, nodes map[*Node]bool) bool {
+	for _, dep := range n.DependsOn {
+		if !nodes[dep] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// run parses the block with the values of the blocks before it, then reads
+// or bakes it
+func (r *Recipe) run(n *Node) *Result {
+	var res = newResult(n, StatusUnchanged, "")
+	var phase = func(phase string) {
+		res.phase = phase
+		logPhase(n, phase)
+	}
+	var fail = func(err error) *Result {
+		res.Status, res.Err = StatusFailed, err
+		return res
+	}
+
+	if n.Local != nil {
+		phase("evaluate")
+		val, diags := r.evaluate(n)
+		if diags.HasErrors() {
+			return fail(diags)
+		}
+
+		cli.Debug(cli.DEBUG, "Evaluated "+n.Address, val.GoString())
+		n.value = &val
+		return res
+	}
+
+	if n.Data != nil {
+		phase("parse")
+		if err := n.Data.Parse(r.BlockEvalContext(n)); err != nil {
+			return fail(err)
+		}
+
+		phase("read")
+		cli.Log(cli.INFO, "Reading", cli.Fields{"address": n.Address, "type": n.Type, "name": n.Name})
+		if err := n.Data.Read(); err != nil {
+			return fail(err)
+		}
+		return res
+	}
+
+	m := n.Resource
+	phase("parse")
+	if err := m.Parse(r.BlockEvalContext(n)); err != nil {
+		return fail(err)
+	}
+
+	phase("check")
+	if m.ValidateOnlyIf() {
+		res.Status, res.Reason = StatusSkipped, ReasonOnlyIf
+		return res
+	}
+
+	if m.ValidateNotIf() {
+		res.Status, res.Reason = StatusSkipped, ReasonNotIf
+		return res
+	}
+
+	phase("bake")
+	cli.Log(cli.INFO, "Baking", cli.Fields{"address": n.Address, "type": n.Type, "name": n.Name})
+	m.Bake()
+	m.Baked()
+
+	if f, ok := m.(pantry.FailureInterface); ok {
+		if err := f.Failed(); err != nil {
+			return fail(err)
+		}
+	}
+
+	res.Status = StatusChanged
+	if c, ok := m.(pantry.ChangeInterface); ok && !c.Changed() {
+		res.Status, res.Reason = StatusUnchanged, ReasonAlreadyPresent
+	}
+
+	return res
+}
@@ -0,0 +1,193 @@
+package report
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/mikemackintosh/bakery/facts"
+	"github.com/mikemackintosh/bakery/recipe"
+)
+
+// SchemaVersion is the version of the events, which changes only when an
+// event or field is removed or changes its meaning
+const SchemaVersion = 1
+
+// Exit codes of a run, so wrappers can tell whether it changed the host
+const (
+	ExitNoChanges = 0
+	ExitFailed    = 1
+	ExitChanged   = 2
+)
+
+// Events, one of which starts each line of the output
+const (
+	EventRunStart          = "run_start"
+	EventResourceStart     = "resource_start"
+	EventResourceSkipped   = "resource_skipped"
+	EventResourceChanged   = "resource_changed"
+	EventResourceUnchanged = "resource_unchanged"
+	EventResourceFailed    = "resource_failed"
+	EventRunSummary        = "run_summary"
+)
+
+// header starts every event
+type header struct {
+	Event string    `json:"event"`
+	Time  time.Time `json:"time"`
+}
+
+// runStart is the first event of a run
+type runStart struct {
+	header
+	SchemaVersion int    `json:"schema_version"`
+	Hostname      string `json:"hostname"`
+	Recipe        string `json:"recipe"`
+	RecipeVersion string `json:"recipe_version"`
+}
+
+// resourceStart is sent when a resource or data block starts
+type resourceStart struct {
+	header
+	Address string `json:"address"`
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+}
+
+// resourceFinish is sent when a resource or data block finishes, or is
+// skipped without starting
+type resourceFinish struct {
+	header
+	Address  string  `json:"address"`
+	Type     string  `json:"type"`
+	Name     string  `json:"name"`
+	Reason   string  `json:"reason,omitempty"`
+	Duration float64 `json:"duration_seconds"`
+	Error    string  `json:"error,omitempty"`
+}
+
+// runSummary is the last event of a run
+type runSummary struct {
+	header
+	Success  bool    `json:"success"`
+	Error    string  `json:"error,omitempty"`
+	Duration float64 `json:"duration_seconds"`
+	Totals   Totals  `json:"totals"`
+	ExitCode int     `json:"exit_code"`
+}
+
+// Events writes the events of a run as they happen, each as a line of JSON
+type Events struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewEvents returns events written to the writer
+func NewEvents(w io.Writer) *Events {
+	return &Events{enc: json.NewEncoder(w)}
+}
+
+// Follow writes the events of the resource and data blocks of the recipe as
+// it runs
+func (e *Events) Follow(r *recipe.Recipe) {
+	r.Started = e.ResourceStart
+	r.Finished = e.ResourceFinish
+}
+
+// RunStart writes the start of a run of the recipe from the source
+func (e *Events) RunStart(r *recipe.Recipe, source string) {
+	e.write(&runStart{
+		header:        header{Event: EventRunStart, Time: time.Now()},
+		SchemaVersion: SchemaVersion,
+		Hostname:      facts.Get().Hostname,
+		Recipe:        source,
+		RecipeVersion: r.Version,
+	})
+}
+
+// RunFailed writes the start and summary of a run of the recipe from the
+// source which could not start, such as when the recipe could not be loaded
+func (e *Events) RunFailed(source string, err error) {
+	var now = time.Now()
+	e.write(&runStart{
+		header:        header{Event: EventRunStart, Time: now},
+		SchemaVersion: SchemaVersion,
+		Hostname:      facts.Get().Hostname,
+		Recipe:        source,
+	})
+	e.write(&runSummary{
+		header:   header{Event: EventRunSummary, Time: now},
+		Error:    err.Error(),
+		ExitCode: ExitFailed,
+	})
+}
+
+// ResourceStart writes the start of a resource or data block
+func (e *Events) ResourceStart(n *recipe.Node) {
+	e.write(&resourceStart{
+		header:  header{Event: EventResourceStart, Time: time.Now()},
+		Address: n.Address,
+		Type:    n.Type,
+		Name:    n.Name,
+	})
+}
+
+// ResourceFinish writes what happened to a resource or data block. A
+// resource which was already present is skipped, as nothing was done.
+func (e *Events) ResourceFinish(res *recipe.Result) {
+	var event = EventResourceChanged
+	switch {
+	case res.Status == recipe.StatusFailed:
+		event = EventResourceFailed
+	case res.Status == recipe.StatusSkipped, res.Reason == recipe.ReasonAlreadyPresent:
+		event = EventResourceSkipped
+	case res.Status == recipe.StatusUnchanged:
+		event = EventResourceUnchanged
+	}
+
+	var finish = &resourceFinish{
+		header:   header{Event: event, Time: time.Now()},
+		Address:  res.Address,
+		Type:     res.Type,
+		Name:     res.Name,
+		Reason:   res.Reason,
+		Duration: res.Duration.Seconds(),
+	}
+	if res.Err != nil {
+		finish.Error = res.Err.Error()
+	}
+	e.write(finish)
+}
+
+// RunSummary writes the outcome of the run from its report
+func (e *Events) RunSummary(r *Report) {
+	e.write(&runSummary{
+		header:   header{Event: EventRunSummary, Time: r.Finished},
+		Success:  r.Success,
+		Error:    r.Error,
+		Duration: r.Duration,
+		Totals:   r.Totals,
+		ExitCode: r.ExitCode(),
+	})
+}
+
+// write writes an event as a line
+func (e *Events) write(event interface{}) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.enc.Encode(event)
+}
+
+// ExitCode returns the exit code of the run, which failed, changed the host
+// or found nothing to change
+func (r *Report) ExitCode() int {
+	switch {
+	case !r.Success:
+		return ExitFailed
+	case r.Totals.Changed > 0:
+		return ExitChanged
+	}
+
+	return ExitNoChanges
+}
@@ -0,0 +1,112 @@
+// Package report describes the outcome of a run, so the hosts which
+// converged can be seen in one place
+package report
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mikemackintosh/bakery/facts"
+	"github.com/mikemackintosh/bakery/recipe"
+)
+
+// Report is the outcome of a run
+type Report struct {
+	Host          *facts.Facts `json:"host"`
+	Recipe        string       `json:"recipe"`
+	RecipeVersion string       `json:"recipe_version"`
+	Started       time.Time    `json:"started"`
+	Finished      time.Time    `json:"finished"`
+	Duration      float64      `json:"duration_seconds"`
+	Success       bool         `json:"success"`
+	Error         string       `json:"error,omitempty"`
+	Resources     []Resource   `json:"resources"`
+	Totals        Totals       `json:"totals"`
+}
+
+// Resource is the outcome of a resource or data block
+type Resource struct {
+	Address  string  `json:"address"`
+	Type     string  `json:"type"`
+	Status   string  `json:"status"`
+	Reason   string  `json:"reason,omitempty"`
+	Changed  bool    `json:"changed"`
+	Duration float64 `json:"duration_seconds"`
+	Error    string  `json:"error,omitempty"`
+}
+
+// Totals count the resources and data blocks by status
+type Totals struct {
+	Resources int `json:"resources"`
+	Changed   int `json:"changed"`
+	Unchanged int `json:"unchanged"`
+	Skipped   int `json:"skipped"`
+	Failed    int `json:"failed"`
+}
+
+// New returns the report of a run of the recipe from the source, which
+// started at the time and finished with the error
+func New(r *recipe.Recipe, source string, started time.Time, err error) *Report {
+	var report = &Report{
+		Host:          facts.Get(),
+		Recipe:        source,
+		RecipeVersion: r.Version,
+		Started:       started,
+		Finished:      time.Now(),
+		Success:       err == nil,
+		Resources:     []Resource{},
+	}
+	report.Duration = report.Finished.Sub(started).Seconds()
+	if err != nil {
+		report.Error = err.Error()
+	}
+
+	for _, res := range r.Results {
+		var resource = Resource{
+			Address:  res.Address,
+			Type:     res.Type,
+			Status:   res.Status,
+			Reason:   res.Reason,
+			Changed:  res.Changed(),
+			Duration: res.Duration.Seconds(),
+		}
+		if res.Err != nil {
+			resource.Error = res.Err.Error()
+		}
+		report.Resources = append(report.Resources, resource)
+
+		report.Totals.Resources++
+		switch res.Status {
+		case recipe.StatusChanged:
+			report.Totals.Changed++
+		case recipe.StatusUnchanged:
+			report.Totals.Unchanged++
+		case recipe.StatusSkipped:
+			report.Totals.Skipped++
+		case recipe.StatusFailed:
+			report.Totals.Failed++
+		}
+	}
+
+	return report
+}
+
+// WriteFile writes the report as JSON, replacing the file
+func (r *Report) WriteFile(name string) error {
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(name+".tmp", append(b, '\n'), 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(name+".tmp", name)
+}
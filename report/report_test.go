@@ -0,0 +1,274 @@
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mikemackintosh/bakery/config"
+	"github.com/mikemackintosh/bakery/recipe"
+)
+
+// testRecipe has a resource which changes the host, one which is already
+// present, one which is skipped and one which fails
+const testRecipe = `
+shell "changes" {
+  script = "echo changed"
+}
+
+shell "present" {
+  script  = "echo present"
+  creates = "TEMPDIR"
+}
+
+shell "guarded" {
+  script = "echo guarded"
+  not_if = "true"
+}
+
+shell "fails" {
+  script = "exit 3"
+}
+`
+
+// testRun loads and runs the recipe, with the temporary directory it runs in
+// in place of TEMPDIR, returning its report. The run is followed by the events
+// when they are not nil, as bakery does with JSON output.
+func testRun(t *testing.T, src string, events *Events) *Report {
+	dir, err := ioutil.TempDir("", "report")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	previous := *config.Registry
+	defer func() { *config.Registry = previous }()
+	config.Registry.TempDir = dir
+	config.Registry.FailurePolicy = config.FailureContinue
+
+	name := filepath.Join(dir, "config.yum")
+	if err := ioutil.WriteFile(name, []byte(strings.Replace(src, "TEMPDIR", dir, -1)), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r, diags := recipe.LoadPath(name)
+	if diags.HasErrors() {
+		t.Fatalf("want no errors but got %s", diags)
+	}
+	if events != nil {
+		events.Follow(r)
+		events.RunStart(r, "config.yum")
+	}
+
+	var started = time.Now()
+	rep := New(r, "config.yum", started, r.Run())
+	if events != nil {
+		events.RunSummary(rep)
+	}
+
+	return rep
+}
+
+// testReport returns the report of a run of the test recipe
+func testReport(t *testing.T) *Report {
+	return testRun(t, testRecipe, nil)
+}
+
+func TestReport(t *testing.T) {
+	rep := testReport(t)
+
+	if rep.Success || rep.Error != "shell.fails: exit status 3" || rep.RecipeVersion == "" {
+		t.Errorf("want a failed run of a version of the recipe but got %#v", rep)
+	}
+	if rep.Host == nil || rep.Host.OS == "" {
+		t.Errorf("want the facts of the host but got %#v", rep.Host)
+	}
+	if want := (Totals{Resources: 4, Changed: 1, Unchanged: 1, Skipped: 1, Failed: 1}); rep.Totals != want {
+		t.Errorf("want %#v but got %#v", want, rep.Totals)
+	}
+
+	var statuses []string
+	for _, res := range rep.Resources {
+		statuses = append(statuses, strings.TrimRight(fmt.Sprintf("%s:%s:%s:%s", res.Address, res.Status, res.Reason, res.Error), ":"))
+	}
+	want := "shell.changes:changed shell.present:unchanged:already_present shell.guarded:skipped:not_if shell.fails:failed::exit status 3"
+	if strings.Join(statuses, " ") != want {
+		t.Errorf("want %s but got %s", want, strings.Join(statuses, " "))
+	}
+	if !rep.Resources[0].Changed || rep.Resources[1].Changed {
+		t.Errorf("want only the first resource to have changed but got %#v", rep.Resources)
+	}
+
+	dir, err := ioutil.TempDir("", "report")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	name := filepath.Join(dir, "state", "last_run.json")
+	if err := rep.WriteFile(name); err != nil {
+		t.Fatalf("want no error but got %s", err)
+	}
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var written Report
+	if err := json.Unmarshal(b, &written); err != nil || written.Totals != rep.Totals {
+		t.Errorf("want the report to be written but got %s", b)
+	}
+}
+
+// readEvents returns the events written by a run, and the last of them
+func readEvents(t *testing.T, out *bytes.Buffer) ([]string, map[string]interface{}) {
+	var got []string
+	var last map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		last = map[string]interface{}{}
+		if err := json.Unmarshal([]byte(line), &last); err != nil {
+			t.Fatalf("want a line of JSON but got %q", line)
+		}
+		got = append(got, strings.TrimSuffix(fmt.Sprintf("%s:%v", last["event"], last["reason"]), ":<nil>"))
+	}
+
+	return got, last
+}
+
+func TestEvents(t *testing.T) {
+	var out bytes.Buffer
+	testRun(t, testRecipe, NewEvents(&out))
+
+	got, last := readEvents(t, &out)
+	want := "run_start resource_start resource_changed resource_start resource_skipped:already_present resource_start resource_skipped:not_if resource_start resource_failed run_summary"
+	if strings.Join(got, " ") != want {
+		t.Errorf("want %s but got %s", want, strings.Join(got, " "))
+	}
+	if last["exit_code"] != float64(ExitFailed) || last["success"] != false {
+		t.Errorf("want the summary of a failed run but got %v", last)
+	}
+}
+
+func TestRunFailed(t *testing.T) {
+	var out bytes.Buffer
+	NewEvents(&out).RunFailed("config.yum", fmt.Errorf("- config.yum:1,1-2: Argument or block definition required"))
+
+	got, last := readEvents(t, &out)
+	if strings.Join(got, " ") != "run_start run_summary" {
+		t.Errorf("want the start and summary of the run but got %v", got)
+	}
+	if last["exit_code"] != float64(ExitFailed) || last["success"] != false || last["error"] != "- config.yum:1,1-2: Argument or block definition required" {
+		t.Errorf("want the summary of a failed run but got %v", last)
+	}
+}
+
+var exitCodeTests = []struct {
+	Src      string
+	Expected int
+}{
+	{`shell "fails" { script = "exit 3" }`, ExitFailed},
+	{`shell "changes" { script = "echo changed" }`, ExitChanged},
+	{`shell "present" {
+	    script  = "echo present"
+	    creates = "TEMPDIR"
+	  }`, ExitNoChanges},
+	{`shell "guarded" {
+	    script = "echo guarded"
+	    not_if = "true"
+	  }`, ExitNoChanges},
+}
+
+func TestExitCode(t *testing.T) {
+	for _, test := range exitCodeTests {
+		var out bytes.Buffer
+		rep := testRun(t, test.Src, NewEvents(&out))
+
+		_, last := readEvents(t, &out)
+		if got := rep.ExitCode(); got != test.Expected || last["exit_code"] != float64(test.Expected) {
+			t.Errorf("want %d but got %d and %v for %s", test.Expected, got, last["exit_code"], test.Src)
+		}
+
+		// Text output has the same exit code, without the events
+		if got := testRun(t, test.Src, nil).ExitCode(); got != test.Expected {
+			t.Errorf("want %d with text output but got %d for %s", test.Expected, got, test.Src)
+		}
+	}
+}
+
+func TestSender(t *testing.T) {
+	dir, err := ioutil.TempDir("", "report")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var status = http.StatusServiceUnavailable
+	var posted []Report
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		var rep Report
+		json.NewDecoder(req.Body).Decode(&rep)
+		if status == http.StatusCreated {
+			posted = append(posted, rep)
+		}
+		w.WriteHeader(status)
+	}))
+	defer ts.Close()
+
+	s := NewSender(ts.URL, "secret", filepath.Join(dir, "spool"))
+	s.Client = ts.Client()
+	s.Backoff = time.Millisecond
+
+	// The report is kept while the endpoint is down
+	first := testReport(t)
+	if err := s.Send(first); err == nil {
+		t.Errorf("want an error while the endpoint is down")
+	}
+	if names, _ := filepath.Glob(filepath.Join(dir, "spool", "*.json")); len(names) != 1 {
+		t.Errorf("want the report to be spooled but got %v", names)
+	}
+
+	// Then posted before the next report, once the endpoint is back
+	status = http.StatusCreated
+	second := testReport(t)
+	second.Finished = first.Finished.Add(time.Second)
+	if err := s.Send(second); err != nil {
+		t.Errorf("want no error but got %s", err)
+	}
+	if len(posted) != 2 || !posted[0].Finished.Equal(first.Finished) || !posted[1].Finished.Equal(second.Finished) {
+		t.Errorf("want both reports to be posted in order but got %d", len(posted))
+	}
+	if names, _ := filepath.Glob(filepath.Join(dir, "spool", "*.json")); len(names) != 0 {
+		t.Errorf("want the spool to be empty but got %v", names)
+	}
+
+	// Reports refused with the wrong token are kept
+	s.Token = "wrong"
+	if err := s.Send(testReport(t)); err == nil {
+		t.Errorf("want an error while the token is wrong")
+	}
+	if names, _ := filepath.Glob(filepath.Join(dir, "spool", "*.json")); len(names) != 1 {
+		t.Errorf("want the report to be spooled but got %v", names)
+	}
+
+	// Reports which are rejected are not kept
+	s.Token = "secret"
+	status = http.StatusUnprocessableEntity
+	if err := s.Send(testReport(t)); err != nil {
+		t.Errorf("want a rejected report to be dropped but got %s", err)
+	}
+	if names, _ := filepath.Glob(filepath.Join(dir, "spool", "*.json")); len(names) != 0 {
+		t.Errorf("want the spool to be empty but got %v", names)
+	}
+}
@@ -0,0 +1,155 @@
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/mikemackintosh/bakery/cli"
+)
+
+// maxSpooled is how many reports are kept while the endpoint cannot be
+// reached, dropping the oldest
+const maxSpooled = 100
+
+// Sender posts reports to an HTTPS endpoint. Each report is spooled to disk
+// before it is posted, so the reports of runs made while offline are posted
+// once the endpoint can be reached again.
+type Sender struct {
+	URL   string
+	Token string
+	Spool string
+
+	Client  *http.Client
+	Retries int
+	Backoff time.Duration
+}
+
+// rejectedError is returned when the endpoint rejects a report as invalid or
+// too large, which will not be accepted however often it is posted
+type rejectedError struct {
+	status string
+}
+
+func (e *rejectedError) Error() string {
+	return fmt.Sprintf("the report was rejected with %s", e.status)
+}
+
+// NewSender returns a sender which posts to the URL with the bearer token,
+// when it is set, spooling reports in the directory
+func NewSender(url, token, spool string) *Sender {
+	return &Sender{
+		URL:     url,
+		Token:   token,
+		Spool:   spool,
+		Client:  &http.Client{Timeout: 30 * time.Second},
+		Retries: 3,
+		Backoff: time.Second,
+	}
+}
+
+// Send spools the report, then posts every spooled report, oldest first
+func (s *Sender) Send(r *Report) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(s.Spool, 0750); err != nil {
+		return err
+	}
+	name := filepath.Join(s.Spool, r.Finished.UTC().Format("20060102T150405.000000000")+".json")
+	if err := ioutil.WriteFile(name, b, 0640); err != nil {
+		return err
+	}
+
+	return s.Flush()
+}
+
+// Flush posts the spooled reports, oldest first, stopping at the first which
+// cannot be posted so it is tried again later
+func (s *Sender) Flush() error {
+	names, err := filepath.Glob(filepath.Join(s.Spool, "*.json"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+
+	for len(names) > maxSpooled {
+		cli.Debug(cli.WARNING, "Dropping report, too many are waiting to be posted", names[0])
+		os.Remove(names[0])
+		names = names[1:]
+	}
+
+	for _, name := range names {
+		b, err := ioutil.ReadFile(name)
+		if err != nil {
+			return err
+		}
+
+		err = s.post(b)
+		if _, ok := err.(*rejectedError); ok {
+			cli.Debug(cli.ERROR, "Dropping report "+name, err)
+		} else if err != nil {
+			return fmt.Errorf("Error posting report to %s, it will be posted after the next run: %s", s.URL, err)
+		}
+
+		os.Remove(name)
+	}
+
+	return nil
+}
+
+// post posts a report, retrying with a growing backoff until it is accepted
+// or rejected
+func (s *Sender) post(b []byte) error {
+	var err error
+	for attempt := 0; attempt <= s.Retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(s.Backoff << uint(attempt-1))
+		}
+
+		err = s.postOnce(b)
+		if _, ok := err.(*rejectedError); ok || err == nil {
+			return err
+		}
+		cli.Debug(cli.DEBUG, "\t-> Error posting report", err)
+	}
+
+	return err
+}
+
+// postOnce posts a report once
+func (s *Sender) postOnce(b []byte) error {
+	req, err := http.NewRequest(http.MethodPost, s.URL, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.Token)
+	}
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusBadRequest, resp.StatusCode == http.StatusRequestEntityTooLarge, resp.StatusCode == http.StatusUnprocessableEntity:
+		// The report itself is refused, unlike a bad token, which is kept
+		// until the token is fixed
+		return &rejectedError{status: resp.Status}
+	}
+
+	return fmt.Errorf("%s returned %s", s.URL, resp.Status)
+}
@@ -0,0 +1,128 @@
+// Package sdk is used to write resource plugins, which add resource types to
+// bakery without changing it.
+//
+// A plugin is an executable named bakery-resource-<type> in the plugin path.
+// Bakery runs it with the phase as its only argument:
+//
+//	bakery-resource-vpn_profile schema
+//	bakery-resource-vpn_profile check
+//	bakery-resource-vpn_profile apply
+//
+// The schema phase writes the attributes of the resource type as JSON to
+// stdout. The check and apply phases read a Request from stdin, and write a
+// Response to stdout. Check reports whether the resource needs to be changed,
+// without changing anything, and apply is only run when it does. Anything
+// written to stderr is logged.
+package sdk
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Attribute types
+const (
+	TypeString = "string"
+	TypeNumber = "number"
+	TypeBool   = "bool"
+	TypeList   = "list"
+	TypeMap    = "map"
+	TypeAny    = "any"
+)
+
+// Phases a plugin is run with
+const (
+	PhaseSchema = "schema"
+	PhaseCheck  = "check"
+	PhaseApply  = "apply"
+)
+
+// Schema describes the attributes of a resource type
+type Schema struct {
+	Attributes map[string]Attribute `json:"attributes"`
+}
+
+// Attribute is an attribute of a resource block. Its type is one of string,
+// number, bool, list (of strings), map (of strings) or any.
+type Attribute struct {
+	Type        string `json:"type"`
+	Required    bool   `json:"required,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// Request is the resource block a plugin is run for
+type Request struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
+
+	// Config holds the attributes which are set, as a JSON object
+	Config json.RawMessage `json:"config"`
+}
+
+// Decode decodes the attributes of the block into v
+func (r *Request) Decode(v interface{}) error {
+	return json.Unmarshal(r.Config, v)
+}
+
+// Response is the result of checking or applying a resource. Outputs can be
+// referenced by other blocks in the recipe, along with changed.
+type Response struct {
+	Changed bool                   `json:"changed"`
+	Error   string                 `json:"error,omitempty"`
+	Outputs map[string]interface{} `json:"outputs,omitempty"`
+}
+
+// Resource is implemented by plugins. Check returns whether the resource
+// needs to be changed, and Apply changes it.
+type Resource interface {
+	Schema() Schema
+	Check(*Request) (*Response, error)
+	Apply(*Request) (*Response, error)
+}
+
+// Serve runs the phase of the plugin given by its arguments, exiting when it
+// cannot be run
+func Serve(r Resource) {
+	if err := Run(r, os.Args[1:], os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// Run runs the phase given by the arguments, reading the request from in and
+// writing the response to out. An error returned by Check or Apply is
+// written as the error of the response.
+func Run(r Resource, args []string, in io.Reader, out io.Writer) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: bakery-resource-<type> schema|check|apply")
+	}
+
+	var phase func(*Request) (*Response, error)
+	switch args[0] {
+	case PhaseSchema:
+		return json.NewEncoder(out).Encode(r.Schema())
+	case PhaseCheck:
+		phase = r.Check
+	case PhaseApply:
+		phase = r.Apply
+	default:
+		return fmt.Errorf("unknown phase %q", args[0])
+	}
+
+	var req Request
+	if err := json.NewDecoder(in).Decode(&req); err != nil {
+		return fmt.Errorf("error decoding the request: %s", err)
+	}
+
+	res, err := phase(&req)
+	if res == nil {
+		res = &Response{}
+	}
+	if err != nil {
+		res.Error = err.Error()
+	}
+
+	return json.NewEncoder(out).Encode(res)
+}
@@ -0,0 +1,58 @@
+package sdk
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+type testResource struct{}
+
+func (testResource) Schema() Schema {
+	return Schema{Attributes: map[string]Attribute{
+		"path": {Type: TypeString, Required: true},
+	}}
+}
+
+func (testResource) Check(req *Request) (*Response, error) {
+	var config struct {
+		Path string `json:"path"`
+	}
+	if err := req.Decode(&config); err != nil {
+		return nil, err
+	}
+
+	return &Response{Changed: config.Path != "/done"}, nil
+}
+
+func (testResource) Apply(req *Request) (*Response, error) {
+	return nil, fmt.Errorf("cannot apply %s", req.Name)
+}
+
+func TestRun(t *testing.T) {
+	var tests = []struct {
+		Phase    string
+		Request  string
+		Expected string
+	}{
+		{"schema", "", `{"attributes":{"path":{"type":"string","required":true}}}`},
+		{"check", `{"type":"test","name":"a","config":{"path":"/todo"}}`, `{"changed":true}`},
+		{"check", `{"type":"test","name":"a","config":{"path":"/done"}}`, `{"changed":false}`},
+		{"apply", `{"type":"test","name":"a","config":{}}`, `{"changed":false,"error":"cannot apply a"}`},
+	}
+
+	for _, test := range tests {
+		var out bytes.Buffer
+		if err := Run(testResource{}, []string{test.Phase}, strings.NewReader(test.Request), &out); err != nil {
+			t.Fatalf("%s: want no error but got %s", test.Phase, err)
+		}
+		if got := strings.TrimSpace(out.String()); got != test.Expected {
+			t.Errorf("%s: want %s but got %s", test.Phase, test.Expected, got)
+		}
+	}
+
+	if err := Run(testResource{}, []string{"destroy"}, nil, &bytes.Buffer{}); err == nil {
+		t.Errorf("want an error for an unknown phase")
+	}
+}